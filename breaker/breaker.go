@@ -0,0 +1,267 @@
+package breaker
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/logger"
+	"github.com/chu108/cmany_db/metrics"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+/*
+熔断期间返回的错误，errors.Is(err, dberr.ErrUnreachable) 为 true
+*/
+var ErrOpen = dberr.Wrap(dberr.ErrUnreachable, "", errors.New("circuit breaker is open"))
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenMax      = 1
+)
+
+/*
+熔断配置，写在各数据库 JSON 配置的 breaker 字段中，不配置时不熔断
+{"failure_threshold":5,"cool_down":"30s","half_open_max":1,"fallback":"master"}
+*/
+type Config struct {
+	FailureThreshold int             `json:"failure_threshold"` //连续失败多少次后熔断，默认 5
+	CoolDown         config.Duration `json:"cool_down"`         //熔断后多久进入半开状态，默认 30s
+	HalfOpenMax      int             `json:"half_open_max"`     //半开状态允许同时试探的请求数，默认 1
+	Fallback         string          `json:"fallback"`          //熔断时的降级方式，mysql 从库支持 master，redis 支持 miss
+}
+
+/*
+校验熔断配置，fallback 只能是 master 或 miss，是否支持由各数据库决定
+*/
+func (c *Config) Validate(p *config.Problems) {
+	if c.FailureThreshold < 0 {
+		p.Addf("failure_threshold", "must not be negative, got %d", c.FailureThreshold)
+	}
+	p.NonNegative("cool_down", c.CoolDown)
+	if c.HalfOpenMax < 0 {
+		p.Addf("half_open_max", "must not be negative, got %d", c.HalfOpenMax)
+	}
+	switch c.Fallback {
+	case "", "master", "miss":
+	default:
+		p.Addf("fallback", "unknown fallback %q", c.Fallback)
+	}
+}
+
+/*
+熔断器，连续失败达到阈值后打开，冷却后半开，试探成功后关闭
+*/
+type Breaker struct {
+	name string
+	cfg  Config
+
+	//只把连接类的错误计为失败，为 nil 时所有错误都计为失败
+	isFailure func(error) bool
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+var (
+	mu        sync.RWMutex
+	breakers  = make(map[string]*Breaker)
+	listeners []func(name string, from, to State)
+)
+
+/*
+创建熔断器并按名称注册，同名的熔断器会被替换
+isFailure 判断错误是否计为失败，为 nil 时使用 IsConnError
+*/
+func New(name string, cfg Config, isFailure func(error) bool) *Breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = config.Duration(defaultCoolDown)
+	}
+	if cfg.HalfOpenMax <= 0 {
+		cfg.HalfOpenMax = defaultHalfOpenMax
+	}
+	if isFailure == nil {
+		isFailure = IsConnError
+	}
+	b := &Breaker{name: name, cfg: cfg, isFailure: isFailure}
+	mu.Lock()
+	breakers[name] = b
+	mu.Unlock()
+	metrics.SetBreakerState(name, int(StateClosed))
+	return b
+}
+
+/*
+取消注册熔断器，连接关闭后调用，之后 States 和指标中不再包含它
+*/
+func Unregister(name string) {
+	mu.Lock()
+	delete(breakers, name)
+	mu.Unlock()
+	metrics.DeleteBreakerState(name)
+}
+
+/*
+按名称获取熔断器，没有时返回 nil
+*/
+func Get(name string) *Breaker {
+	mu.RLock()
+	defer mu.RUnlock()
+	return breakers[name]
+}
+
+/*
+所有熔断器的状态
+*/
+func States() map[string]State {
+	mu.RLock()
+	defer mu.RUnlock()
+	states := make(map[string]State, len(breakers))
+	for name, b := range breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+/*
+监听状态变化，可用于降级或告警
+*/
+func OnStateChange(fn func(name string, from, to State)) {
+	mu.Lock()
+	defer mu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) Fallback() string {
+	return b.cfg.Fallback
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+/*
+是否允许执行，允许时调用方必须用 ReportResult 报告结果
+熔断时返回 ErrOpen
+*/
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	switch b.state {
+	case StateOpen:
+		return ErrOpen
+	case StateHalfOpen:
+		if b.trials >= b.cfg.HalfOpenMax {
+			return ErrOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+/*
+报告执行结果，nil 表示成功
+方法名与 go-redis 的 Limiter 接口一致
+*/
+func (b *Breaker) ReportResult(err error) {
+	failed := err != nil && b.isFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		if failed {
+			b.setState(StateOpen)
+		} else {
+			b.setState(StateClosed)
+		}
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.setState(StateOpen)
+		}
+	}
+}
+
+/*
+放弃 Allow 得到的执行机会，不计为成功或失败，用于调用方最终没有访问数据库的情况
+*/
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+/*
+打开状态冷却结束后进入半开状态，调用方需持有锁
+*/
+func (b *Breaker) refresh() {
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.cfg.CoolDown.Std() {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	from := b.state
+	if from == state {
+		return
+	}
+	b.state = state
+	b.failures = 0
+	b.trials = 0
+	if state == StateOpen {
+		b.openedAt = time.Now()
+	}
+	metrics.SetBreakerState(b.name, int(state))
+	logger.For(b.name).Warn("circuit breaker state changed", logger.F("from", from.String()), logger.F("to", state.String()))
+
+	mu.RLock()
+	fns := listeners
+	mu.RUnlock()
+	for _, fn := range fns {
+		go fn(b.name, from, state)
+	}
+}
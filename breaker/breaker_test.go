@@ -0,0 +1,106 @@
+package breaker_test
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"testing"
+	"time"
+)
+
+var (
+	errConn  = errors.New("connection refused")
+	errQuery = errors.New("syntax error")
+)
+
+func isFailure(err error) bool {
+	return err == errConn
+}
+
+/*
+一步操作，执行后检查状态
+allow 调用 Allow 并检查是否返回 ErrOpen，report 报告 result，release 调用 Release，wait 等待冷却结束
+*/
+type step struct {
+	op     string
+	open   bool
+	result error
+	state  breaker.State
+}
+
+func TestTransitions(t *testing.T) {
+	const coolDown = 50 * time.Millisecond
+	cfg := breaker.Config{FailureThreshold: 2, CoolDown: config.Duration(coolDown), HalfOpenMax: 1}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"failures below threshold stay closed", []step{
+			{op: "allow", state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateClosed},
+		}},
+		{"success resets failures", []step{
+			{op: "report", result: errConn, state: breaker.StateClosed},
+			{op: "report", result: nil, state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateClosed},
+		}},
+		{"non connection errors are not failures", []step{
+			{op: "report", result: errQuery, state: breaker.StateClosed},
+			{op: "report", result: errQuery, state: breaker.StateClosed},
+			{op: "report", result: errQuery, state: breaker.StateClosed},
+		}},
+		{"threshold opens", []step{
+			{op: "report", result: errConn, state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateOpen},
+			{op: "allow", open: true, state: breaker.StateOpen},
+		}},
+		{"cool down half opens and success closes", []step{
+			{op: "report", result: errConn, state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateOpen},
+			{op: "wait", state: breaker.StateHalfOpen},
+			{op: "allow", state: breaker.StateHalfOpen},
+			{op: "allow", open: true, state: breaker.StateHalfOpen},
+			{op: "report", result: nil, state: breaker.StateClosed},
+			{op: "allow", state: breaker.StateClosed},
+		}},
+		{"half open failure reopens", []step{
+			{op: "report", result: errConn, state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateOpen},
+			{op: "wait", state: breaker.StateHalfOpen},
+			{op: "allow", state: breaker.StateHalfOpen},
+			{op: "report", result: errConn, state: breaker.StateOpen},
+			{op: "allow", open: true, state: breaker.StateOpen},
+		}},
+		{"release returns the trial", []step{
+			{op: "report", result: errConn, state: breaker.StateClosed},
+			{op: "report", result: errConn, state: breaker.StateOpen},
+			{op: "wait", state: breaker.StateHalfOpen},
+			{op: "allow", state: breaker.StateHalfOpen},
+			{op: "release", state: breaker.StateHalfOpen},
+			{op: "allow", state: breaker.StateHalfOpen},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := breaker.New(t.Name(), cfg, isFailure)
+			defer breaker.Unregister(t.Name())
+			for i, s := range tt.steps {
+				switch s.op {
+				case "allow":
+					if err := b.Allow(); (err == breaker.ErrOpen) != s.open {
+						t.Fatalf("step %d: Allow = %v, want open %v", i, err, s.open)
+					}
+				case "report":
+					b.ReportResult(s.result)
+				case "release":
+					b.Release()
+				case "wait":
+					time.Sleep(coolDown)
+				}
+				if got := b.State(); got != s.state {
+					t.Fatalf("step %d: state = %v, want %v", i, got, s.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package breaker
+
+import (
+	"github.com/chu108/cmany_db/dberr"
+)
+
+/*
+是否是连接类的错误（网络错误、连接断开、超时），服务端返回的业务错误不算，等价于 dberr.IsConnError
+*/
+func IsConnError(err error) bool {
+	return dberr.IsConnError(err)
+}
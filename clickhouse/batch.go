@@ -0,0 +1,172 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/chu108/cmany_db/logger"
+	"sync"
+	"time"
+)
+
+/*
+攒批时缓冲区的上限是 size 的倍数，发送一直失败时丢弃最早的行，避免内存无限增长
+*/
+const maxBufferBatches = 10
+
+var ErrBatcherClosed = errors.New("clickhouse: batcher is closed")
+
+/*
+批量插入，rows 中的结构体按 ch 标签对应列名，在一个 INSERT 中发送
+table 表名，可以带库名，如 events.clicks
+*/
+func InsertBatch[T any](ctx context.Context, conn driver.Conn, table string, rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	batch, err := conn.PrepareBatch(ctx, "INSERT INTO "+table)
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+	for i := range rows {
+		if err := batch.AppendStruct(&rows[i]); err != nil {
+			batch.Abort()
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+/*
+攒批写入，行数达到 size 或距上次发送超过 interval 时用 InsertBatch 发送
+ClickHouse 适合少量大批次的写入，逐行 INSERT 会产生大量 part
+发送失败的行保留到下一次发送，超过 size 的 10 倍时丢弃最早的行
+*/
+type Batcher[T any] struct {
+	conn     driver.Conn
+	table    string
+	size     int
+	interval time.Duration
+
+	mu     sync.Mutex
+	buf    []T
+	closed bool
+
+	flushMu sync.Mutex //同一时间只有一个发送，保证行的顺序
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+/*
+创建后台定时发送的 Batcher，用完需要调用 Close 发送剩余的行
+size 每批的行数，小于等于 0 时为 10000
+interval 定时发送的间隔，小于等于 0 时为 1s
+*/
+func NewBatcher[T any](conn driver.Conn, table string, size int, interval time.Duration) *Batcher[T] {
+	if size <= 0 {
+		size = 10000
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	b := &Batcher[T]{
+		conn:     conn,
+		table:    table,
+		size:     size,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+/*
+加入缓冲区，达到 size 时在当前 goroutine 中发送
+*/
+func (b *Batcher[T]) Add(ctx context.Context, rows ...T) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBatcherClosed
+	}
+	b.buf = append(b.buf, rows...)
+	full := len(b.buf) >= b.size
+	b.mu.Unlock()
+	if full {
+		return b.Flush(ctx)
+	}
+	return nil
+}
+
+/*
+发送缓冲区中的所有行
+*/
+func (b *Batcher[T]) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for {
+		b.mu.Lock()
+		n := len(b.buf)
+		if n > b.size {
+			n = b.size
+		}
+		rows := append([]T(nil), b.buf[:n]...)
+		b.mu.Unlock()
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := InsertBatch(ctx, b.conn, b.table, rows); err != nil {
+			b.trim()
+			return err
+		}
+		b.mu.Lock()
+		b.buf = b.buf[n:]
+		b.mu.Unlock()
+	}
+}
+
+func (b *Batcher[T]) trim() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.size * maxBufferBatches
+	if over := len(b.buf) - limit; over > 0 {
+		b.buf = append(b.buf[:0:0], b.buf[over:]...)
+		logger.For(b.table).Warn("batch buffer full, dropping rows", logger.F("dropped", over))
+	}
+}
+
+/*
+停止定时发送并发送剩余的行，之后 Add 返回 ErrBatcherClosed
+*/
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+	close(b.stop)
+	<-b.done
+	return b.Flush(ctx)
+}
+
+func (b *Batcher[T]) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), b.interval*10)
+			if err := b.Flush(ctx); err != nil {
+				logger.For(b.table).Warn("batch flush failed", logger.F("error", err.Error()))
+			}
+			cancel()
+		}
+	}
+}
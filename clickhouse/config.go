@@ -0,0 +1,152 @@
+package clickhouse
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/chu108/cmany_db/config"
+	"io/ioutil"
+	"net"
+)
+
+const (
+	defaultMaxOpen     = 10
+	defaultMaxIdle     = 5
+	defaultCompression = "lz4"
+)
+
+var strategies = map[string]ch.ConnOpenStrategy{
+	"in_order":    ch.ConnOpenInOrder,
+	"round_robin": ch.ConnOpenRoundRobin,
+	"random":      ch.ConnOpenRandom,
+}
+
+var protocols = map[string]ch.Protocol{
+	"native": ch.Native,
+	"http":   ch.HTTP,
+}
+
+var compressions = map[string]ch.CompressionMethod{
+	"none":    ch.CompressionNone,
+	"lz4":     ch.CompressionLZ4,
+	"lz4hc":   ch.CompressionLZ4HC,
+	"zstd":    ch.CompressionZSTD,
+	"gzip":    ch.CompressionGZIP,
+	"deflate": ch.CompressionDeflate,
+	"br":      ch.CompressionBrotli,
+}
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+protocol 默认 native，strategy 默认 in_order，compression 默认 lz4，max_open 默认 10，max_idle 默认 5
+*/
+func (c *dbConn) SetDefaults() {
+	if c.Protocol == "" {
+		c.Protocol = "native"
+	}
+	if c.Strategy == "" {
+		c.Strategy = "in_order"
+	}
+	if c.Compression == "" {
+		c.Compression = defaultCompression
+	}
+	if c.MaxOpen == 0 {
+		c.MaxOpen = defaultMaxOpen
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = defaultMaxIdle
+		if c.MaxIdle > c.MaxOpen && c.MaxOpen > 0 {
+			c.MaxIdle = c.MaxOpen
+		}
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	if len(c.Hosts) == 0 {
+		p.Add("hosts", "required")
+	}
+	for i, host := range c.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			p.Sub("hosts").Index(i).Add("", err.Error())
+		}
+	}
+	if _, ok := protocols[c.Protocol]; !ok {
+		p.Addf("protocol", "must be native or http, got %q", c.Protocol)
+	}
+	if _, ok := strategies[c.Strategy]; !ok {
+		p.Addf("strategy", "must be one of in_order, round_robin, random, got %q", c.Strategy)
+	}
+	switch method, ok := compressions[c.Compression]; {
+	case !ok:
+		p.Addf("compression", "must be one of none, lz4, lz4hc, zstd, gzip, deflate, br, got %q", c.Compression)
+	case c.Protocol == "native" && method != ch.CompressionNone && method != ch.CompressionLZ4 &&
+		method != ch.CompressionLZ4HC && method != ch.CompressionZSTD:
+		p.Addf("compression", "%s is only supported by http protocol", c.Compression)
+	}
+	if c.Password != "" && c.Username == "" {
+		p.Add("username", "required when password is set")
+	}
+	p.NonNegative("dial_timeout", c.DialTimeout)
+	p.NonNegative("read_timeout", c.ReadTimeout)
+	p.NonNegative("conn_max_lifetime", c.ConnMaxLifetime)
+	if c.MaxOpen < 0 {
+		p.Addf("max_open", "must not be negative, got %d", c.MaxOpen)
+	}
+	if c.MaxIdle < 0 {
+		p.Addf("max_idle", "must not be negative, got %d", c.MaxIdle)
+	} else if c.MaxIdle > c.MaxOpen {
+		p.Addf("max_idle", "must not be greater than max_open %d, got %d", c.MaxOpen, c.MaxIdle)
+	}
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.Check("retry", c.Retry)
+}
+
+/*
+生成 clickhouse-go 的配置
+*/
+func (c *dbConn) options() (*ch.Options, error) {
+	opts := &ch.Options{
+		Addr:     c.Hosts,
+		Protocol: protocols[c.Protocol],
+		Auth: ch.Auth{
+			Database: c.Database,
+			Username: c.Username,
+			Password: c.Password,
+		},
+		ConnOpenStrategy: strategies[c.Strategy],
+		Compression: &ch.Compression{
+			Method: compressions[c.Compression],
+			Level:  c.CompressionLevel,
+		},
+		Settings:        ch.Settings(c.Settings),
+		DialTimeout:     c.DialTimeout.Std(),
+		ReadTimeout:     c.ReadTimeout.Std(),
+		MaxOpenConns:    c.MaxOpen,
+		MaxIdleConns:    c.MaxIdle,
+		ConnMaxLifetime: c.ConnMaxLifetime.Std(),
+	}
+	if c.TLS || c.TLSCA != "" {
+		opts.TLS = &tls.Config{}
+		if c.TLSCA != "" {
+			pem, err := ioutil.ReadFile(c.TLSCA)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("tls_ca: no certificate found in " + c.TLSCA)
+			}
+			opts.TLS.RootCAs = pool
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,107 @@
+package clickhouse
+
+import (
+	"context"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"strings"
+	"time"
+)
+
+const backend = "clickhouse"
+
+/*
+包装 clickhouse-go 的连接，统计每条语句的耗时，开启追踪时为每条语句创建 span
+批量插入在 Send 时统计为 INSERT
+*/
+type instrumentedConn struct {
+	driver.Conn
+	name string
+}
+
+func (c *instrumentedConn) observe(ctx context.Context, query string) (context.Context, func(error)) {
+	begin := time.Now()
+	cmd := command(query)
+	statement := ""
+	if tracing.Enabled() {
+		statement = tracing.SanitizeSQL(query)
+	}
+	ctx, span := tracing.Start(ctx, backend, c.name, cmd, statement)
+	return ctx, func(err error) {
+		metrics.Observe(backend, c.name, cmd, begin, err)
+		tracing.End(span, err)
+	}
+}
+
+func (c *instrumentedConn) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, end := c.observe(ctx, query)
+	err := c.Conn.Select(ctx, dest, query, args...)
+	end(err)
+	return err
+}
+
+func (c *instrumentedConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	ctx, end := c.observe(ctx, query)
+	rows, err := c.Conn.Query(ctx, query, args...)
+	end(err)
+	return rows, err
+}
+
+func (c *instrumentedConn) QueryRow(ctx context.Context, query string, args ...interface{}) driver.Row {
+	ctx, end := c.observe(ctx, query)
+	row := c.Conn.QueryRow(ctx, query, args...)
+	end(row.Err())
+	return row
+}
+
+func (c *instrumentedConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	ctx, end := c.observe(ctx, query)
+	err := c.Conn.Exec(ctx, query, args...)
+	end(err)
+	return err
+}
+
+func (c *instrumentedConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	begin := time.Now()
+	batch, err := c.Conn.PrepareBatch(ctx, query, opts...)
+	if err != nil {
+		metrics.Observe(backend, c.name, "PREPARE", begin, err)
+		return nil, err
+	}
+	return &instrumentedBatch{Batch: batch, conn: c, ctx: ctx, query: query}, nil
+}
+
+type instrumentedBatch struct {
+	driver.Batch
+	conn  *instrumentedConn
+	ctx   context.Context //PrepareBatch 的 ctx，发送的 span 挂在它下面
+	query string
+}
+
+func (b *instrumentedBatch) Send() error {
+	_, end := b.conn.observe(b.ctx, b.query)
+	err := b.Batch.Send()
+	end(err)
+	return err
+}
+
+/*
+取语句的第一个关键字作为命令名称，避免把整条 SQL 作为指标标签
+*/
+func command(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n(;")
+	if end > 0 {
+		query = query[:end]
+	}
+	if query == "" || len(query) > 16 {
+		return "OTHER"
+	}
+	for _, r := range query {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return "OTHER"
+		}
+	}
+	return strings.ToUpper(query)
+}
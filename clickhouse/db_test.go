@@ -0,0 +1,58 @@
+package clickhouse_test
+
+import (
+	"errors"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/chu108/cmany_db/clickhouse"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"strings"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/clickhouse"
+
+/*
+没有内嵌的 clickhouse，etcd 中写入延迟连接的配置，只验证读取配置和创建连接
+*/
+func check(conn driver.Conn, err error) error {
+	if err != nil {
+		return err
+	}
+	return clickhouse.Close(conn)
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	hosts := []string{cmanydbtest.ClosedAddr(t)}
+	if err := env.Put(dbKey, map[string]interface{}{"hosts": hosts, "username": "default", "password": "secret", "lazy": true}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return check(clickhouse.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return check(clickhouse.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return check(clickhouse.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	_, err := clickhouse.ConnByStr("clickhouse://default:secret@" + cmanydbtest.ClosedAddr(t) + "/default?dial_timeout=300ms")
+	if !errors.Is(err, dberr.ErrUnreachable) {
+		t.Fatalf("closed port = %v, want ErrUnreachable", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error contains the password: %v", err)
+	}
+
+	_, err = clickhouse.ConnByStr("clickhouse://%zz")
+	if !errors.Is(err, dberr.ErrInvalidConfig) {
+		t.Fatalf("invalid dsn = %v, want ErrInvalidConfig", err)
+	}
+}
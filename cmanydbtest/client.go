@@ -0,0 +1,31 @@
+package cmanydbtest
+
+import (
+	"database/sql"
+	"github.com/chu108/cmany_db/mysql"
+	"github.com/chu108/cmany_db/redigo"
+	"github.com/chu108/cmany_db/redis"
+	redigoredis "github.com/garyburd/redigo/redis"
+	goredis "github.com/go-redis/redis"
+)
+
+/*
+用 RedisKey 的配置通过 redis.ConnByEtcd 连接 miniredis
+*/
+func (e *Env) RedisClient() (*goredis.Client, error) {
+	return redis.ConnByEtcd(RedisKey, e.Endpoints...)
+}
+
+/*
+用 RedisKey 的配置通过 redigo.ConnByEtcd 连接 miniredis
+*/
+func (e *Env) RedigoConn() (redigoredis.Conn, error) {
+	return redigo.ConnByEtcd(RedisKey, e.Endpoints...)
+}
+
+/*
+用 MySQLKey 的配置通过 mysql.ConnByEtcd 连接内存 MySQL，主从是同一个库
+*/
+func (e *Env) MySQL() (masterDB, slaveDB *sql.DB, err error) {
+	return mysql.ConnByEtcd(MySQLKey, e.Endpoints...)
+}
@@ -0,0 +1,61 @@
+package cmanydbtest
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"testing"
+)
+
+/*
+ConnByEnv 测试中保存 etcd 地址的环境变量
+*/
+const EtcdEnv = "CMANYDBTEST_ETCD_ADDR"
+
+/*
+各数据库包读取 etcd 配置的构造函数
+每个函数连接后检查连接可用、关闭连接，返回遇到的错误
+*/
+type Constructors struct {
+	Key      string //etcd 中已经写入配置的 key
+	Etcd     func(key string, endpoints ...string) error
+	EtcdAuth func(key, user, password string, endpoints ...string) error
+	Env      func(env, key string) error
+}
+
+/*
+依次测试 ConnByEtcd、ConnByEnv、ConnByEtcdAuth：
+配置存在时连接成功，key 或环境变量不存在时返回 dberr.ErrConfigNotFound，etcd 密码错误时返回认证错误
+ConnByEtcdAuth 会开启 etcd 认证，放在最后
+*/
+func (e *Env) TestConstructors(t *testing.T, c Constructors) {
+	t.Run("ConnByEtcd", func(t *testing.T) {
+		if err := c.Etcd(c.Key, e.Endpoints...); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if err := c.Etcd("/cmanydbtest/missing", e.Endpoints...); !errors.Is(err, dberr.ErrConfigNotFound) {
+			t.Fatalf("missing key = %v, want ErrConfigNotFound", err)
+		}
+	})
+	t.Run("ConnByEnv", func(t *testing.T) {
+		if err := e.Setenv(EtcdEnv); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Env(EtcdEnv, c.Key); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if err := c.Env("CMANYDBTEST_UNSET", c.Key); !errors.Is(err, dberr.ErrConfigNotFound) {
+			t.Fatalf("unset env = %v, want ErrConfigNotFound", err)
+		}
+	})
+	t.Run("ConnByEtcdAuth", func(t *testing.T) {
+		if err := e.EnableAuth("secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.EtcdAuth(c.Key, RootUser, "secret", e.Endpoints...); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if err := c.EtcdAuth(c.Key, RootUser, "wrong", e.Endpoints...); !dberr.IsAuth(err) {
+			t.Fatalf("wrong password = %v, want auth error", err)
+		}
+	})
+}
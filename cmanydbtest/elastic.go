@@ -0,0 +1,50 @@
+package cmanydbtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/*
+fake elasticsearch 返回的版本号
+*/
+const ElasticVersion = "6.8.23"
+
+/*
+只响应根路径和节点信息的 elasticsearch，足够客户端创建时的 ping、嗅探和健康检查
+测试结束时自动关闭，返回 http://host:port
+*/
+func NewElastic(tb testing.TB) string {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.URL.Path {
+		case "/":
+			body = map[string]interface{}{
+				"name":         "cmanydbtest",
+				"cluster_name": "cmanydbtest",
+				"version":      map[string]interface{}{"number": ElasticVersion},
+				"tagline":      "You Know, for Search",
+			}
+		case "/_nodes/http":
+			body = map[string]interface{}{
+				"cluster_name": "cmanydbtest",
+				"nodes": map[string]interface{}{
+					"cmanydbtest": map[string]interface{}{
+						"name": "cmanydbtest",
+						"http": map[string]interface{}{"publish_address": r.Host},
+					},
+				},
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	tb.Cleanup(srv.Close)
+	return srv.URL
+}
@@ -0,0 +1,189 @@
+package cmanydbtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+启动后写入 etcd 的连接配置的 key，直接传给各包的 ConnByEtcd
+*/
+const (
+	RedisKey = "/cmanydbtest/redis"
+	MySQLKey = "/cmanydbtest/mysql"
+)
+
+/*
+内存 MySQL 中默认创建的库名
+*/
+const MySQLDatabase = "test"
+
+const startTimeout = time.Second * 30
+
+/*
+进程内的测试环境，不依赖外部服务：
+内嵌的 etcd、miniredis 实现的 Redis、go-mysql-server 实现的内存 MySQL
+启动后把 Redis 和 MySQL 的连接配置写入 etcd 的 RedisKey、MySQLKey
+*/
+type Env struct {
+	Endpoints []string             //etcd 客户端地址，传给 ConnByEtcd
+	Etcd      *clientv3.Client     //已连接的 etcd 客户端，启用认证后使用认证后的客户端
+	Redis     *miniredis.Miniredis //可以直接读写数据、快进时间等
+	MySQLAddr string               //内存 MySQL 的地址 host:port，用户 root，无密码
+
+	etcd    *embed.Etcd
+	etcdDir string
+	mysql   *mysqlServer
+	envs    []string //Setenv 设置的环境变量，Close 时清除
+}
+
+/*
+启动测试环境，用完需要调用 Close
+ctx 控制启动的时间
+*/
+func Start(ctx context.Context) (env *Env, err error) {
+	env = new(Env)
+	defer func() {
+		if err != nil {
+			env.Close()
+			env = nil
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	if err = env.startEtcd(ctx); err != nil {
+		return
+	}
+	if env.Redis, err = miniredis.Run(); err != nil {
+		return
+	}
+	if env.mysql, err = startMySQL(MySQLDatabase); err != nil {
+		return
+	}
+	env.MySQLAddr = env.mysql.addr
+
+	host, port := env.redisHostPort()
+	if err = env.PutCtx(ctx, RedisKey, map[string]interface{}{"host": host, "port": port}); err != nil {
+		return
+	}
+	host, port = env.mysqlHostPort()
+	conn := map[string]interface{}{"host": host, "port": port, "user": "root", "database": MySQLDatabase}
+	err = env.PutCtx(ctx, MySQLKey, map[string]interface{}{"master": conn, "slave": conn})
+	return
+}
+
+/*
+在测试中启动测试环境，启动失败时结束测试，测试结束时自动关闭
+*/
+func New(tb testing.TB) *Env {
+	tb.Helper()
+	env, err := Start(context.Background())
+	if err != nil {
+		tb.Fatalf("cmanydbtest: %v", err)
+	}
+	tb.Cleanup(env.Close)
+	return env
+}
+
+/*
+关闭所有服务，可以重复调用
+*/
+func (e *Env) Close() {
+	for _, name := range e.envs {
+		os.Unsetenv(name)
+	}
+	e.envs = nil
+	if e.mysql != nil {
+		e.mysql.close()
+		e.mysql = nil
+	}
+	if e.Redis != nil {
+		e.Redis.Close()
+		e.Redis = nil
+	}
+	e.closeEtcd()
+}
+
+/*
+写入 etcd，value 为 string 或 []byte 时原样写入，其他类型编码为 JSON
+*/
+func (e *Env) Put(key string, value interface{}) error {
+	return e.PutCtx(context.Background(), key, value)
+}
+
+func (e *Env) PutCtx(ctx context.Context, key string, value interface{}) error {
+	var data string
+	switch v := value.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	_, err := e.Etcd.Put(ctx, key, data)
+	return err
+}
+
+/*
+删除 etcd 中的 key
+*/
+func (e *Env) Delete(key string) error {
+	_, err := e.Etcd.Delete(context.Background(), key)
+	return err
+}
+
+/*
+把 etcd 地址写入环境变量 name，用于测试 ConnByEnv，Close 时清除
+*/
+func (e *Env) Setenv(name string) error {
+	if name == "" {
+		return errors.New("cmanydbtest: empty env name")
+	}
+	if err := os.Setenv(name, strings.Join(e.Endpoints, ",")); err != nil {
+		return err
+	}
+	e.envs = append(e.envs, name)
+	return nil
+}
+
+/*
+本机一个没有服务监听的地址 host:port，用于测试没有内嵌服务的数据库连接失败时的错误
+*/
+func ClosedAddr(tb testing.TB) string {
+	tb.Helper()
+	u, err := freeURL()
+	if err != nil {
+		tb.Fatalf("cmanydbtest: %v", err)
+	}
+	return u.Host
+}
+
+func (e *Env) redisHostPort() (string, int) {
+	return splitHostPort(e.Redis.Addr())
+}
+
+func (e *Env) mysqlHostPort() (string, int) {
+	return splitHostPort(e.MySQLAddr)
+}
+
+func splitHostPort(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
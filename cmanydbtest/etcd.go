@@ -0,0 +1,124 @@
+package cmanydbtest
+
+import (
+	"context"
+	"errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+)
+
+/*
+etcd 认证的用户名，EnableAuth 后用 ConnByEtcdAuth(key, RootUser, password, env.Endpoints...) 连接
+*/
+const RootUser = "root"
+
+/*
+启动单节点的内嵌 etcd，数据放在临时目录，Close 时删除
+*/
+func (e *Env) startEtcd(ctx context.Context) error {
+	dir, err := ioutil.TempDir("", "cmanydbtest-etcd")
+	if err != nil {
+		return err
+	}
+	e.etcdDir = dir
+
+	clientURL, err := freeURL()
+	if err != nil {
+		return err
+	}
+	peerURL, err := freeURL()
+	if err != nil {
+		return err
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.LogOutputs = []string{"stderr"}
+	cfg.LogLevel = "error"
+
+	if e.etcd, err = embed.StartEtcd(cfg); err != nil {
+		return err
+	}
+	select {
+	case <-e.etcd.Server.ReadyNotify():
+	case err = <-e.etcd.Err():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	e.Endpoints = []string{clientURL.Host}
+	e.Etcd, err = clientv3.New(clientv3.Config{Endpoints: e.Endpoints, DialTimeout: startTimeout})
+	return err
+}
+
+func (e *Env) closeEtcd() {
+	if e.Etcd != nil {
+		e.Etcd.Close()
+		e.Etcd = nil
+	}
+	if e.etcd != nil {
+		e.etcd.Close()
+		e.etcd = nil
+	}
+	if e.etcdDir != "" {
+		os.RemoveAll(e.etcdDir)
+		e.etcdDir = ""
+	}
+}
+
+/*
+开启 etcd 认证，创建 RootUser 用户，之后 Env.Etcd 使用认证后的客户端
+用于测试 ConnByEtcdAuth，开启后不能关闭，需要未认证的环境时重新创建 Env
+*/
+func (e *Env) EnableAuth(password string) error {
+	if password == "" {
+		return errors.New("cmanydbtest: empty etcd password")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	if _, err := e.Etcd.RoleAdd(ctx, RootUser); err != nil {
+		return err
+	}
+	if _, err := e.Etcd.UserAdd(ctx, RootUser, password); err != nil {
+		return err
+	}
+	if _, err := e.Etcd.UserGrantRole(ctx, RootUser, RootUser); err != nil {
+		return err
+	}
+	if _, err := e.Etcd.AuthEnable(ctx); err != nil {
+		return err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   e.Endpoints,
+		DialTimeout: startTimeout,
+		Username:    RootUser,
+		Password:    password,
+	})
+	if err != nil {
+		return err
+	}
+	e.Etcd.Close()
+	e.Etcd = cli
+	return nil
+}
+
+/*
+取一个本机空闲端口，etcd 的节点地址要写入集群配置，不能用 0 端口
+*/
+func freeURL() (*url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return &url.URL{Scheme: "http", Host: addr}, nil
+}
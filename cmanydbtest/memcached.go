@@ -0,0 +1,118 @@
+package cmanydbtest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/chu108/cmany_db/fake"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+只支持 version、get、gets、set、delete 的 memcached 文本协议服务端，数据存在 fake.Memcached 中
+*/
+type Memcached struct {
+	*fake.Memcached
+	ln net.Listener
+}
+
+/*
+启动 memcached 服务端，测试结束时自动关闭
+*/
+func NewMemcached(tb testing.TB) *Memcached {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("cmanydbtest: %v", err)
+	}
+	m := &Memcached{Memcached: fake.NewMemcached(), ln: ln}
+	go m.serve()
+	tb.Cleanup(m.Close)
+	return m
+}
+
+/*
+服务端监听的地址 host:port
+*/
+func (m *Memcached) Addr() string {
+	return m.ln.Addr().String()
+}
+
+func (m *Memcached) Close() {
+	m.ln.Close()
+}
+
+func (m *Memcached) serve() {
+	for {
+		c, err := m.ln.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(c)
+	}
+}
+
+func (m *Memcached) handle(c net.Conn) {
+	defer c.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := m.command(rw, fields); err != nil {
+			return
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (m *Memcached) command(rw *bufio.ReadWriter, fields []string) error {
+	switch fields[0] {
+	case "version":
+		fmt.Fprint(rw, "VERSION 1.6.0-cmanydbtest\r\n")
+	case "get", "gets":
+		for _, key := range fields[1:] {
+			item, err := m.Get(key)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(rw, "VALUE %s %d %d 0\r\n%s\r\n", key, item.Flags, len(item.Value), item.Value)
+		}
+		fmt.Fprint(rw, "END\r\n")
+	case "set":
+		//set <key> <flags> <exptime> <bytes>
+		if len(fields) < 5 {
+			fmt.Fprint(rw, "ERROR\r\n")
+			return nil
+		}
+		flags, _ := strconv.ParseUint(fields[2], 10, 32)
+		exp, _ := strconv.ParseInt(fields[3], 10, 32)
+		size, _ := strconv.Atoi(fields[4])
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(rw, value); err != nil {
+			return err
+		}
+		m.Set(&memcache.Item{Key: fields[1], Value: value[:size], Flags: uint32(flags), Expiration: int32(exp)})
+		fmt.Fprint(rw, "STORED\r\n")
+	case "delete":
+		if len(fields) < 2 || m.Delete(fields[1]) != nil {
+			fmt.Fprint(rw, "NOT_FOUND\r\n")
+		} else {
+			fmt.Fprint(rw, "DELETED\r\n")
+		}
+	default:
+		fmt.Fprint(rw, "ERROR\r\n")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package cmanydbtest
+
+import (
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	"github.com/dolthub/go-mysql-server/sql"
+	"net"
+)
+
+/*
+go-mysql-server 实现的内存 MySQL，数据只在进程内，不支持主从复制
+用户 root，无密码
+*/
+type mysqlServer struct {
+	srv  *server.Server
+	addr string
+}
+
+func startMySQL(database string) (*mysqlServer, error) {
+	provider := memory.NewDBProvider(memory.NewDatabase(database))
+	engine := sqle.NewDefault(provider)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv, err := server.NewServer(server.Config{Protocol: "tcp", Listener: l}, engine, sql.NewContext, memory.NewSessionBuilder(provider), nil)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	go srv.Start()
+	return &mysqlServer{srv: srv, addr: l.Addr().String()}, nil
+}
+
+func (s *mysqlServer) close() {
+	s.srv.Close()
+}
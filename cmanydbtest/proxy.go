@@ -0,0 +1,110 @@
+package cmanydbtest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+/*
+转发到 target 的 TCP 代理，用于模拟数据库宕机和恢复
+SetDown(true) 后断开已有连接并拒绝新连接，SetDown(false) 后恢复转发
+*/
+type Proxy struct {
+	target string
+	ln     net.Listener
+
+	mu    sync.Mutex
+	down  bool
+	conns map[net.Conn]struct{}
+}
+
+/*
+启动代理，测试结束时自动关闭
+*/
+func NewProxy(tb testing.TB, target string) *Proxy {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("cmanydbtest: %v", err)
+	}
+	p := &Proxy{target: target, ln: ln, conns: make(map[net.Conn]struct{})}
+	go p.serve()
+	tb.Cleanup(p.Close)
+	return p
+}
+
+/*
+代理监听的地址 host:port
+*/
+func (p *Proxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *Proxy) SetDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+	if down {
+		for c := range p.conns {
+			c.Close()
+		}
+	}
+}
+
+func (p *Proxy) Close() {
+	p.ln.Close()
+	p.SetDown(true)
+}
+
+func (p *Proxy) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(c)
+	}
+}
+
+func (p *Proxy) forward(c net.Conn) {
+	backend, err := net.Dial("tcp", p.target)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if !p.track(c, backend) {
+		return
+	}
+	defer p.untrack(c, backend)
+	go io.Copy(backend, c)
+	io.Copy(c, backend)
+}
+
+/*
+记录连接，宕机时一起断开，已经宕机时直接关闭并返回 false
+*/
+func (p *Proxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		for _, c := range conns {
+			c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		p.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (p *Proxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+		delete(p.conns, c)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/chu108/cmany_db/clickhouse"
+	"github.com/chu108/cmany_db/elasticsearch"
+	"github.com/chu108/cmany_db/memcached"
+	"github.com/chu108/cmany_db/mgo"
+	"github.com/chu108/cmany_db/mongodb"
+	"github.com/chu108/cmany_db/mysql"
+	"github.com/chu108/cmany_db/postgres"
+	"github.com/chu108/cmany_db/redigo"
+	"github.com/chu108/cmany_db/redis"
+	"github.com/chu108/cmany_db/sqlite"
+	"sort"
+	"strings"
+)
+
+/*
+各包配置的校验函数，key 为 -type 的取值
+*/
+var validators = map[string]func(dbKey string, data []byte) error{
+	"mysql":         mysql.Validate,
+	"mysql-sharded": mysql.ValidateSharded,
+	"postgres":      postgres.Validate,
+	"sqlite":        sqlite.Validate,
+	"redis":         redis.Validate,
+	"redigo":        redigo.Validate,
+	"mongodb":       mongodb.Validate,
+	"mgo":           mgo.Validate,
+	"elasticsearch": elasticsearch.Validate,
+	"memcached":     memcached.Validate,
+	"clickhouse":    clickhouse.Validate,
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(validators))
+	for name := range validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validator(backend string) (func(string, []byte) error, error) {
+	fn, ok := validators[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q, must be one of %s", backend, strings.Join(backendNames(), ", "))
+	}
+	return fn, nil
+}
+
+/*
+配置能通过哪些包的校验，如 redis 和 redigo 的配置格式相同，会同时返回
+*/
+func detect(key string, data []byte) []string {
+	var types []string
+	for _, name := range backendNames() {
+		if validators[name](key, data) == nil {
+			types = append(types, name)
+		}
+	}
+	return types
+}
+
+/*
+backend 不为空时按该包校验，为空时识别类型，没有任何包能通过校验时返回错误
+返回通过校验的类型
+*/
+func validate(backend, key string, data []byte) ([]string, error) {
+	if backend != "" {
+		fn, err := validator(backend)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(key, data); err != nil {
+			return nil, err
+		}
+		return []string{backend}, nil
+	}
+	types := detect(key, data)
+	if len(types) == 0 {
+		return nil, fmt.Errorf("%s: does not match any type, use -type to see the problems", key)
+	}
+	return types, nil
+}
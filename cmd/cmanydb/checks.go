@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/chu108/cmany_db/elasticsearch"
+	"github.com/chu108/cmany_db/mgo"
+	"github.com/chu108/cmany_db/mongodb"
+	"github.com/chu108/cmany_db/mysql"
+	"github.com/chu108/cmany_db/redigo"
+	"github.com/chu108/cmany_db/redis"
+	redigoredis "github.com/garyburd/redigo/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mgov2 "gopkg.in/mgo.v2"
+	mgobson "gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+mongo 没有开启副本集时 replSetGetStatus 的错误码
+*/
+const mongoNoReplicationEnabled = 76
+
+func checkMySQL(ctx context.Context, key string, data []byte) []*report {
+	master, slave, err := mysql.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r := new(report)
+		r.fail(err)
+		return []*report{r}
+	}
+	defer mysql.Close(master, slave)
+	reports := []*report{checkSQL(ctx, &report{Role: "master"}, master, false)}
+	//没有配置从库时 slave 就是 master，不再单独检查
+	if slave != master {
+		reports = append(reports, checkSQL(ctx, &report{Role: "slave"}, slave, true))
+	}
+	return reports
+}
+
+func checkSQL(ctx context.Context, r *report, db *sql.DB, replica bool) *report {
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		r.fail(err)
+		return r
+	}
+	r.ok(time.Since(start))
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&r.Version); err != nil {
+		r.warn("version: %v", err)
+	}
+
+	//连接池不能超过服务端的最大连接数
+	var maxConn int
+	if err := db.QueryRowContext(ctx, "SELECT @@max_connections").Scan(&maxConn); err != nil {
+		r.warn("max_connections: %v", err)
+	}
+	switch open := db.Stats().MaxOpenConnections; {
+	case open == 0:
+		r.warn("max_open is unlimited")
+	case maxConn > 0 && open > maxConn:
+		r.warn("max_open %d exceeds server max_connections %d", open, maxConn)
+	}
+
+	if replica {
+		lag, ok, err := mysqlLag(ctx, db)
+		switch {
+		case err != nil:
+			r.warn("replica status: %v", err)
+		case !ok:
+			r.warn("not a replica or replication is stopped")
+		default:
+			r.setLag(lag)
+		}
+	}
+	return r
+}
+
+/*
+从库延迟，MySQL 8.0.22 之前没有 SHOW REPLICA STATUS，改用 SHOW SLAVE STATUS
+不是从库或复制停止时 ok 为 false
+*/
+func mysqlLag(ctx context.Context, db *sql.DB) (lag float64, ok bool, err error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		if rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return 0, false, err
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, false, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, false, nil
+		}
+		lag, err := strconv.ParseFloat(values[i].String, 64)
+		return lag, err == nil, err
+	}
+	return 0, false, nil
+}
+
+func checkRedis(ctx context.Context, key string, data []byte) []*report {
+	r := new(report)
+	client, err := redis.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	defer redis.Close(client)
+	start := time.Now()
+	if err := client.Ping().Err(); err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	r.ok(time.Since(start))
+	info, err := client.Info().Result()
+	if err != nil {
+		r.warn("info: %v", err)
+		return []*report{r}
+	}
+	var maxClients int
+	if res, err := client.ConfigGet("maxclients").Result(); err == nil && len(res) == 2 {
+		maxClients, _ = strconv.Atoi(redisString(res[1]))
+	}
+	checkRedisInfo(r, parseInfo(info), client.Options().PoolSize, maxClients)
+	return []*report{r}
+}
+
+func checkRedigo(ctx context.Context, key string, data []byte) []*report {
+	r := new(report)
+	conn, err := redigo.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	defer redigo.Close(conn)
+	start := time.Now()
+	if _, err := conn.Do("PING"); err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	r.ok(time.Since(start))
+	info, err := redigoredis.String(conn.Do("INFO"))
+	if err != nil {
+		r.warn("info: %v", err)
+		return []*report{r}
+	}
+	var maxClients int
+	if res, err := redigoredis.Strings(conn.Do("CONFIG", "GET", "maxclients")); err == nil && len(res) == 2 {
+		maxClients, _ = strconv.Atoi(res[1])
+	}
+	var cfg struct {
+		MaxActive int `json:"max_active"`
+	}
+	json.Unmarshal(data, &cfg)
+	checkRedisInfo(r, parseInfo(info), cfg.MaxActive, maxClients)
+	return []*report{r}
+}
+
+/*
+版本、主从角色和延迟、连接池大小
+*/
+func checkRedisInfo(r *report, info map[string]string, poolSize, maxClients int) {
+	r.Version = info["redis_version"]
+	r.Role = info["role"]
+	if r.Role == "slave" {
+		if status := info["master_link_status"]; status != "up" {
+			r.warn("master link is %s", status)
+		} else if secs, err := strconv.ParseFloat(info["master_last_io_seconds_ago"], 64); err == nil {
+			r.setLag(secs)
+		}
+	}
+	switch {
+	case poolSize == 0:
+		r.warn("pool size is unlimited")
+	case maxClients > 0 && poolSize > maxClients:
+		r.warn("pool size %d exceeds server maxclients %d", poolSize, maxClients)
+	}
+}
+
+/*
+解析 INFO 的输出，每行为 name:value
+*/
+func parseInfo(info string) map[string]string {
+	m := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			m[line[:i]] = line[i+1:]
+		}
+	}
+	return m
+}
+
+func redisString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+/*
+replSetGetStatus 中的成员，mongo-driver 和 mgo 的 bson 标签相同
+*/
+type replMember struct {
+	Name       string    `bson:"name"`
+	StateStr   string    `bson:"stateStr"`
+	OptimeDate time.Time `bson:"optimeDate"`
+	Self       bool      `bson:"self"`
+}
+
+/*
+当前连接的节点相对主节点的延迟，连接的是主节点时取所有从节点中最大的延迟
+*/
+func mongoLag(members []replMember) (float64, bool) {
+	var primary time.Time
+	var self *replMember
+	for i, m := range members {
+		if m.StateStr == "PRIMARY" {
+			primary = m.OptimeDate
+		}
+		if m.Self {
+			self = &members[i]
+		}
+	}
+	if primary.IsZero() || self == nil {
+		return 0, false
+	}
+	if self.StateStr != "PRIMARY" {
+		return primary.Sub(self.OptimeDate).Seconds(), true
+	}
+	var lag float64
+	for _, m := range members {
+		if m.StateStr == "SECONDARY" {
+			if d := primary.Sub(m.OptimeDate).Seconds(); d > lag {
+				lag = d
+			}
+		}
+	}
+	return lag, true
+}
+
+func checkMongo(ctx context.Context, key string, data []byte) []*report {
+	r := new(report)
+	db, err := mongodb.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	defer mongodb.Close(context.Background(), db)
+	start := time.Now()
+	if err := db.RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	r.ok(time.Since(start))
+	var build struct {
+		Version string `bson:"version"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+		r.warn("version: %v", err)
+	}
+	r.Version = build.Version
+
+	var status struct {
+		Members []replMember `bson:"members"`
+	}
+	err = db.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		var se mongo.ServerError
+		if !errors.As(err, &se) || !se.HasErrorCode(mongoNoReplicationEnabled) {
+			r.warn("replica status: %v", err)
+		}
+		return []*report{r}
+	}
+	if lag, ok := mongoLag(status.Members); ok {
+		r.setLag(lag)
+	}
+	return []*report{r}
+}
+
+func checkMgo(ctx context.Context, key string, data []byte) []*report {
+	r := new(report)
+	session, err := mgo.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	defer mgo.Close(session)
+	start := time.Now()
+	if err := session.Ping(); err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	r.ok(time.Since(start))
+	if info, err := session.BuildInfo(); err != nil {
+		r.warn("version: %v", err)
+	} else {
+		r.Version = info.Version
+	}
+
+	var status struct {
+		Members []replMember `bson:"members"`
+	}
+	if err := session.Run(mgobson.D{{Name: "replSetGetStatus", Value: 1}}, &status); err != nil {
+		var queryErr *mgov2.QueryError
+		if !errors.As(err, &queryErr) || queryErr.Code != mongoNoReplicationEnabled {
+			r.warn("replica status: %v", err)
+		}
+		return []*report{r}
+	}
+	if lag, ok := mongoLag(status.Members); ok {
+		r.setLag(lag)
+	}
+	return []*report{r}
+}
+
+func checkElastic(ctx context.Context, key string, data []byte) []*report {
+	r := new(report)
+	client, err := elasticsearch.ConnByJSONCtx(ctx, key, data)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	defer elasticsearch.Close(client)
+	start := time.Now()
+	health, err := client.ClusterHealth().Do(ctx)
+	if err != nil {
+		r.fail(err)
+		return []*report{r}
+	}
+	r.ok(time.Since(start))
+	if health.Status != "green" {
+		r.warn("cluster status is %s, %d unassigned shards", health.Status, health.UnassignedShards)
+	}
+	var cfg struct {
+		HttpAddr string `json:"http_addr"`
+	}
+	json.Unmarshal(data, &cfg)
+	if info, _, err := client.Ping(cfg.HttpAddr).Do(ctx); err != nil {
+		r.warn("version: %v", err)
+	} else {
+		r.Version = info.Version.Number
+	}
+	return []*report{r}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/chu108/cmany_db/config"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func runList(g *globals, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	backend := fs.String("type", "", "只列出能通过该类型校验的配置")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	if *backend != "" {
+		if _, err := validator(*backend); err != nil {
+			return err
+		}
+	}
+	g.secretKey() //有加密字段时需要密钥才能识别类型，没有密钥时显示为 -
+	kvs, err := g.list(prefixArg(fs.Args()))
+	if err != nil {
+		return err
+	}
+	values := make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		values[string(kv.Key)] = kv.Value
+	}
+	refs := bases(values)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tREVISION")
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		value := kv.Value
+		if merged, err := g.extend(value, values); err == nil {
+			value = merged
+		}
+		types := detect(key, value)
+		if *backend != "" && !contains(types, *backend) {
+			continue
+		}
+		typ := strings.Join(types, ",")
+		switch {
+		case typ == "" && refs[key]:
+			typ = "base"
+		case typ == "":
+			typ = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", key, typ, kv.ModRevision)
+	}
+	return w.Flush()
+}
+
+func runGet(g *globals, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	decrypt := fs.Bool("decrypt", false, "解密 enc: 开头的字段")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	kv, err := g.get(fs.Arg(0), 0)
+	if err != nil {
+		return err
+	}
+	value := kv.Value
+	if *decrypt {
+		key, err := g.secretKey()
+		if err != nil {
+			return err
+		}
+		if value, err = config.DecryptFields(key, value); err != nil {
+			return err
+		}
+	}
+	os.Stdout.Write(pretty(value))
+	return nil
+}
+
+func runPut(g *globals, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	backend := fs.String("type", "", "按该类型校验，为空时只要能通过任一类型的校验")
+	force := fs.Bool("force", false, "不校验直接写入")
+	encrypt := fs.Bool("encrypt", false, "写入前加密 "+strings.Join(config.SecretFields, "、")+" 字段")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	value, err := readInput(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	value = bytes.TrimSpace(value)
+	if *encrypt {
+		secret, err := g.secretKey()
+		if err != nil {
+			return err
+		}
+		if value, err = config.EncryptFields(secret, value, config.SecretFields); err != nil {
+			return err
+		}
+	}
+	if !*force {
+		if err := g.checkSecret(value); err != nil {
+			return err
+		}
+		merged, err := g.extend(value, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := validate(*backend, key, merged); err != nil {
+			return err
+		}
+	}
+	return g.put(key, value)
+}
+
+func runDelete(g *globals, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	prefix := fs.Bool("prefix", false, "删除以 key 开头的所有配置")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	cli, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.ctx()
+	defer cancel()
+	key := fs.Arg(0)
+	if *prefix {
+		if key == "" || key == "/" {
+			return fmt.Errorf("refusing to delete every key under %q", key)
+		}
+		res, err := cli.Delete(ctx, key, clientv3.WithPrefix())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d keys\n", res.Deleted)
+		return nil
+	}
+	res, err := cli.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if res.Deleted == 0 {
+		return fmt.Errorf("%s: not found", key)
+	}
+	return nil
+}
+
+func runValidate(g *globals, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	backend := fs.String("type", "", "按该类型校验，为空时识别类型")
+	file := fs.String("file", "", "校验本地文件，- 表示标准输入")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	var key string
+	var value []byte
+	switch {
+	case *file != "" && fs.NArg() == 0:
+		data, err := readInput(*file)
+		if err != nil {
+			return err
+		}
+		key, value = *file, data
+	case *file == "" && fs.NArg() == 1:
+		kv, err := g.get(fs.Arg(0), 0)
+		if err != nil {
+			return err
+		}
+		key, value = fs.Arg(0), kv.Value
+	default:
+		return errUsage
+	}
+	if err := g.checkSecret(value); err != nil {
+		return err
+	}
+	merged, err := g.extend(value, nil)
+	if err != nil {
+		return err
+	}
+	types, err := validate(*backend, key, merged)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: ok (%s)\n", key, strings.Join(types, ","))
+	return nil
+}
+
+/*
+格式化 JSON，不是 JSON 时原样返回，结尾带换行
+*/
+func pretty(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(data), "", "  "); err != nil {
+		buf.Reset()
+		buf.Write(data)
+	}
+	if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+对比 etcd 中的配置和本地文件，没有文件时对比当前值和 -rev 指定的历史版本（默认上一个版本）
+有差异时退出码为 1，与 diff 命令相同
+*/
+func runDiff(g *globals, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	rev := fs.Int64("rev", 0, "对比的历史版本（etcd revision），默认上一个版本")
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	cur, err := g.get(key, 0)
+	if err != nil {
+		return err
+	}
+	var oldName, newName string
+	var oldValue, newValue []byte
+	if fs.NArg() == 2 {
+		if *rev != 0 {
+			return errUsage
+		}
+		file, err := readInput(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		oldName, oldValue = fmt.Sprintf("%s@%d", key, cur.ModRevision), cur.Value
+		newName, newValue = fs.Arg(1), file
+	} else {
+		at := *rev
+		if at == 0 {
+			at = cur.ModRevision - 1
+		}
+		old, err := g.get(key, at)
+		if err != nil {
+			return err
+		}
+		oldName, oldValue = fmt.Sprintf("%s@%d", key, old.ModRevision), old.Value
+		newName, newValue = fmt.Sprintf("%s@%d", key, cur.ModRevision), cur.Value
+	}
+	if !writeDiff(os.Stdout, oldName, newName, pretty(oldValue), pretty(newValue)) {
+		return nil
+	}
+	return errors.New("differences found")
+}
+
+/*
+从当前版本往前打印修改历史，已被 compact 的版本无法读取
+*/
+func runHistory(g *globals, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	n := fs.Int("n", 10, "最多打印的版本数")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	var rev int64
+	for i := 0; i < *n; i++ {
+		kv, err := g.get(key, rev)
+		if err != nil {
+			if i > 0 && errors.Is(err, rpctypes.ErrCompacted) {
+				fmt.Println("(older revisions compacted)")
+				return nil
+			}
+			if i > 0 && errors.Is(err, errNotFound) {
+				return nil
+			}
+			return err
+		}
+		fmt.Printf("revision %d (version %d)\n", kv.ModRevision, kv.Version)
+		os.Stdout.Write(pretty(kv.Value))
+		fmt.Println()
+		if kv.Version <= 1 {
+			return nil
+		}
+		rev = kv.ModRevision - 1
+	}
+	return nil
+}
+
+/*
+按行对比，输出 unified 格式，每处差异带 3 行上下文，有差异时返回 true
+*/
+func writeDiff(w io.Writer, oldName, newName string, oldText, newText []byte) bool {
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	const context = 3
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		//一段差异及前后的上下文
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > context*2 {
+				break
+			}
+			end = next
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		aStart, aLen, bStart, bLen := hunkRange(ops, start, stop)
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[start:stop] {
+			line := op.line
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			fmt.Fprintf(w, "%c%s", op.kind, line)
+		}
+		i = stop
+	}
+	return true
+}
+
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type diffOp struct {
+	kind byte // ' '、'-'、'+'
+	line string
+	a, b int //在旧文本和新文本中的行号，从 1 开始
+}
+
+/*
+最长公共子序列，配置文件只有几十行，O(n*m) 足够
+*/
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i + 1, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j + 1})
+			j++
+		}
+	}
+	return ops
+}
+
+func hunkRange(ops []diffOp, start, stop int) (aStart, aLen, bStart, bLen int) {
+	for _, op := range ops[start:stop] {
+		if op.kind != '+' {
+			if aLen == 0 {
+				aStart = op.a
+			}
+			aLen++
+		}
+		if op.kind != '-' {
+			if bLen == 0 {
+				bStart = op.b
+			}
+			bLen++
+		}
+	}
+	if aLen == 0 {
+		aStart = ops[start].a
+	}
+	if bLen == 0 {
+		bStart = ops[start].b
+	}
+	return
+}
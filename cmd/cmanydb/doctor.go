@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/chu108/cmany_db/dberr"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+检查结果的状态，有 fail 时退出码为 1
+*/
+const (
+	statusOK   = "ok"
+	statusWarn = "warn"
+	statusFail = "fail"
+	statusSkip = "skip"
+)
+
+/*
+一个实例的检查结果，mysql 的主库和从库分别是一条
+*/
+type report struct {
+	Key        string   `json:"key"`
+	Type       string   `json:"type"`
+	Role       string   `json:"role,omitempty"` //master、slave 等
+	Status     string   `json:"status"`
+	Reachable  bool     `json:"reachable"`
+	AuthOK     bool     `json:"auth_ok"`
+	Version    string   `json:"version,omitempty"`
+	LatencyMS  float64  `json:"latency_ms"`
+	ReplicaLag *float64 `json:"replica_lag_seconds,omitempty"` //从库延迟，不是从库或无法获取时为空
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (r *report) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+/*
+记录连接或 ping 的错误，认证失败时 reachable 为 true
+*/
+func (r *report) fail(err error) {
+	r.Error = err.Error()
+	if dberr.IsAuth(err) {
+		r.Reachable = true
+		r.AuthOK = false
+		return
+	}
+	r.Reachable = false
+}
+
+func (r *report) ok(latency time.Duration) {
+	r.Reachable = true
+	r.AuthOK = true
+	r.LatencyMS = float64(latency.Microseconds()) / 1000
+}
+
+func (r *report) setLag(seconds float64) {
+	r.ReplicaLag = &seconds
+}
+
+func (r *report) finish() {
+	switch {
+	case r.Status != "":
+	case r.Error != "":
+		r.Status = statusFail
+	case len(r.Warnings) > 0:
+		r.Status = statusWarn
+	default:
+		r.Status = statusOK
+	}
+}
+
+/*
+检查一种类型的配置，返回每个实例的结果
+*/
+type checker func(ctx context.Context, key string, data []byte) []*report
+
+/*
+按顺序选择第一个能通过校验的类型，如 redis 和 redigo 的配置相同时按 redis 检查
+*/
+var checkers = []struct {
+	backend string
+	check   checker
+}{
+	{"mysql", checkMySQL},
+	{"redis", checkRedis},
+	{"redigo", checkRedigo},
+	{"mongodb", checkMongo},
+	{"mgo", checkMgo},
+	{"elasticsearch", checkElastic},
+}
+
+/*
+读取 etcd 中 prefix 下（或 -file 指定的 export 文件中）的所有配置，用本库的连接函数逐个连接并报告
+*/
+func runDoctor(g *globals, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	file := fs.String("file", "", "读取 export 导出的文件，不读取 etcd")
+	jsonOut := fs.Bool("json", false, "以 JSON 输出")
+	timeout := fs.Duration("timeout", time.Second*10, "每个配置的检查时间")
+	maxLag := fs.Duration("max-lag", time.Second*30, "从库延迟超过该值时警告")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	configs, err := loadConfigs(g, *file, prefixArg(fs.Args()))
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string][]byte, len(configs))
+	for _, c := range configs {
+		local[c.key] = c.value
+	}
+	refs := bases(local)
+	var reports []*report
+	for _, c := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		reports = append(reports, doctor(ctx, g, c.key, c.value, local, refs, maxLag.Seconds())...)
+		cancel()
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		printReports(reports)
+	}
+	failed := 0
+	for _, r := range reports {
+		if r.Status == statusFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(reports))
+	}
+	return nil
+}
+
+type configEntry struct {
+	key   string
+	value []byte
+}
+
+func loadConfigs(g *globals, file, prefix string) ([]configEntry, error) {
+	if file == "" {
+		kvs, err := g.list(prefix)
+		if err != nil {
+			return nil, err
+		}
+		configs := make([]configEntry, len(kvs))
+		for i, kv := range kvs {
+			configs[i] = configEntry{string(kv.Key), kv.Value}
+		}
+		return configs, nil
+	}
+	data, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
+	keys, values, err := decodeExport(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	configs := make([]configEntry, len(keys))
+	for i, key := range keys {
+		configs[i] = configEntry{key, values[key]}
+	}
+	return configs, nil
+}
+
+/*
+local 为同时检查的所有配置，extends 引用的基础配置在其中时不再读取 etcd
+refs 中的基础配置不能单独连接时跳过
+*/
+func doctor(ctx context.Context, g *globals, key string, data []byte, local map[string][]byte, refs map[string]bool, maxLag float64) []*report {
+	data, err := g.extend(data, local)
+	if err == nil {
+		err = g.checkSecret(data)
+	}
+	if err != nil {
+		r := &report{Key: key, Type: "-", Error: err.Error()}
+		r.finish()
+		return []*report{r}
+	}
+	types := detect(key, data)
+	if len(types) == 0 && refs[key] {
+		return []*report{{Key: key, Type: "base", Status: statusSkip}}
+	}
+	if len(types) == 0 {
+		r := &report{Key: key, Type: "-", Error: "does not match any type, run validate -type to see the problems"}
+		r.finish()
+		return []*report{r}
+	}
+	for _, c := range checkers {
+		if !contains(types, c.backend) {
+			continue
+		}
+		reports := c.check(ctx, key, data)
+		for _, r := range reports {
+			r.Key, r.Type = key, c.backend
+			if r.ReplicaLag != nil && *r.ReplicaLag > maxLag {
+				r.warn("replica lag %.0fs exceeds %.0fs", *r.ReplicaLag, maxLag)
+			}
+			r.finish()
+		}
+		return reports
+	}
+	return []*report{{Key: key, Type: strings.Join(types, ","), Status: statusSkip, Warnings: []string{"doctor does not support this type"}}}
+}
+
+func printReports(reports []*report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tROLE\tSTATUS\tVERSION\tLATENCY\tLAG\tNOTES")
+	for _, r := range reports {
+		latency, lag := "-", "-"
+		if r.Reachable && r.AuthOK {
+			latency = fmt.Sprintf("%.1fms", r.LatencyMS)
+		}
+		if r.ReplicaLag != nil {
+			lag = fmt.Sprintf("%.0fs", *r.ReplicaLag)
+		}
+		notes := r.Warnings
+		if r.Error != "" {
+			prefix := "unreachable: "
+			switch {
+			case r.Type == "-":
+				prefix = ""
+			case r.Reachable && !r.AuthOK:
+				prefix = "auth failed: "
+			}
+			notes = append([]string{prefix + r.Error}, notes...)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Key, r.Type, dash(r.Role), r.Status, dash(r.Version), latency, lag, strings.Join(notes, "; "))
+	}
+	w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/retry"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+/*
+所有子命令共用的参数
+*/
+type globals struct {
+	endpoints     string
+	user          string
+	password      string
+	secretKeyFile string
+	timeout       time.Duration
+
+	cli *clientv3.Client
+}
+
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.endpoints, "endpoints", os.Getenv("ETCD_ADDR"), "etcd 地址，多个用逗号分隔，默认读取环境变量 ETCD_ADDR")
+	fs.StringVar(&g.user, "user", "", "etcd 用户名")
+	fs.StringVar(&g.password, "password", "", "etcd 密码")
+	fs.StringVar(&g.secretKeyFile, "secret-key-file", "", "加密密钥文件，内容为 base64 编码的密钥，默认读取环境变量 "+config.SecretKeyEnv)
+	fs.DurationVar(&g.timeout, "timeout", time.Second*10, "连接和每次请求的超时时间")
+}
+
+/*
+etcd 客户端，第一次使用时连接
+*/
+func (g *globals) client() (*clientv3.Client, error) {
+	if g.cli != nil {
+		return g.cli, nil
+	}
+	endpoints := splitList(g.endpoints)
+	if len(endpoints) == 0 {
+		return nil, errors.New("etcd endpoints required: use -endpoints or ETCD_ADDR")
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		Username:    g.user,
+		Password:    g.password,
+		DialTimeout: g.timeout,
+		DialOptions: []grpc.DialOption{grpc.WithBlock()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.cli = cli
+	return cli, nil
+}
+
+func (g *globals) close() {
+	if g.cli != nil {
+		g.cli.Close()
+	}
+}
+
+func (g *globals) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), g.timeout)
+}
+
+/*
+-secret-key-file 指定的密钥，没有指定时读取环境变量
+指定后同时设置给 config 包，校验加密的配置时使用
+*/
+func (g *globals) secretKey() ([]byte, error) {
+	if g.secretKeyFile == "" {
+		return config.SecretKey()
+	}
+	data, err := ioutil.ReadFile(g.secretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := config.ParseSecretKey(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := config.SetSecretKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+/*
+配置中有加密字段时检查密钥，校验时 config.Decode 需要先解密
+*/
+func (g *globals) checkSecret(value []byte) error {
+	if !bytes.Contains(value, []byte(config.SecretPrefix)) {
+		return nil
+	}
+	_, err := g.secretKey()
+	return err
+}
+
+/*
+配置中有 extends 时读取基础配置并合并，校验和检查用合并后的内容，写入 etcd 的仍是原始内容
+基础配置在 local 中时（如同一个 import 文件中）优先使用，否则按各包读取配置的方式从 etcd 读取
+*/
+func (g *globals) extend(data []byte, local map[string][]byte) ([]byte, error) {
+	var chain [][]byte
+	for {
+		base, err := config.Extends(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config.ExtendsField, err)
+		}
+		if base == "" {
+			break
+		}
+		if len(chain) == 8 {
+			return nil, fmt.Errorf("%s: too many levels", config.ExtendsField)
+		}
+		chain = append(chain, data)
+		var ok bool
+		if data, ok = local[base]; ok {
+			continue
+		}
+		ctx, cancel := g.ctx()
+		data, err = etcd.Conn(splitList(g.endpoints)...).Auth(g.user, g.password).Timeout(g.timeout, g.timeout).
+			Retry(&retry.Policy{MaxAttempts: 1}).GetCtx(ctx, base)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		break //从 etcd 读取的已经合并过
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		merged, err := config.Merge(data, chain[i])
+		if err != nil {
+			return nil, err
+		}
+		data = merged
+	}
+	return data, nil
+}
+
+/*
+被其它配置的 extends 引用的 key，基础配置通常只有部分字段，单独校验不通过时不算错误
+*/
+func bases(values map[string][]byte) map[string]bool {
+	refs := make(map[string]bool)
+	for _, value := range values {
+		if base, _ := config.Extends(value); base != "" {
+			refs[base] = true
+		}
+	}
+	return refs
+}
+
+/*
+读取 key 的值，rev 大于 0 时读取该版本时的值，不存在时返回错误
+*/
+func (g *globals) get(key string, rev int64) (*mvccpb.KeyValue, error) {
+	res, err := g.getOp(key, clientv3.WithRev(rev))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, fmt.Errorf("%s: %w", key, errNotFound)
+	}
+	return res.Kvs[0], nil
+}
+
+/*
+prefix 下所有的 key，按 key 排序
+*/
+func (g *globals) list(prefix string) ([]*mvccpb.KeyValue, error) {
+	res, err := g.getOp(prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	return res.Kvs, nil
+}
+
+func (g *globals) getOp(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	cli, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.ctx()
+	defer cancel()
+	return cli.Get(ctx, key, opts...)
+}
+
+func (g *globals) put(key string, value []byte) error {
+	cli, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.ctx()
+	defer cancel()
+	_, err = cli.Put(ctx, key, string(value))
+	return err
+}
+
+/*
+只在 key 的版本仍为 modRev 时写入，避免覆盖别人在读取之后的修改
+*/
+func (g *globals) putIfUnchanged(key string, value []byte, modRev int64) error {
+	cli, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.ctx()
+	defer cancel()
+	res, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRev)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return errors.New(key + ": modified concurrently, try again")
+	}
+	return nil
+}
+
+/*
+读取文件，- 表示标准输入
+*/
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+/*
+etcd 的 key 前缀，空时为 /
+*/
+func prefixArg(args []string) string {
+	if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+		return "/"
+	}
+	return args[0]
+}
@@ -0,0 +1,128 @@
+/*
+cmanydb 管理 etcd 中的数据库连接配置
+
+	cmanydb list [-type mysql] [prefix]        列出 prefix 下的配置及识别出的类型
+	cmanydb get [-decrypt] key                 打印配置
+	cmanydb put [-type mysql] [-force] [-encrypt] key file|-
+	                                           校验后写入配置
+	cmanydb delete [-prefix] key               删除配置
+	cmanydb validate [-type mysql] key|-file path
+	                                           按各包的 Validate 校验配置
+	cmanydb encrypt [-fields password,dsn] key|-file path|-value s
+	                                           加密密码等字段，值为 enc: 开头的 AES-GCM 密文
+	cmanydb keygen                             生成 base64 编码的 AES-256 密钥，指定 -secret-key-file 时写入该文件
+	cmanydb diff [-rev n] key [file]           对比 etcd 中的配置和文件，或和历史版本
+	cmanydb history [-n 10] key                打印配置的修改历史
+	cmanydb export [-o file] prefix            导出 prefix 下所有配置
+	cmanydb import [-force] [-dry-run] file    导入 export 导出的文件
+	cmanydb doctor [-json] [-file export.json] [-timeout 10s] [-max-lag 30s] [prefix]
+	                                           连接每个配置，检查连通性、认证、版本、延迟、连接池和从库延迟
+
+etcd 地址默认读取环境变量 ETCD_ADDR，密钥读取环境变量 CMANYDB_SECRET_KEY
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(g *globals, args []string) error
+}
+
+var commands = map[string]command{
+	"list":     {"list [-type mysql] [prefix]", runList},
+	"get":      {"get [-decrypt] key", runGet},
+	"put":      {"put [-type mysql] [-force] [-encrypt] key file|-", runPut},
+	"delete":   {"delete [-prefix] key", runDelete},
+	"validate": {"validate [-type mysql] key|-file path", runValidate},
+	"encrypt":  {"encrypt [-fields password,dsn] key|-file path|-value s", runEncrypt},
+	"keygen":   {"keygen", runKeygen},
+	"diff":     {"diff [-rev n] key [file]", runDiff},
+	"history":  {"history [-n 10] key", runHistory},
+	"export":   {"export [-o file] prefix", runExport},
+	"import":   {"import [-force] [-dry-run] file", runImport},
+	"doctor":   {"doctor [-json] [-file export.json] [-timeout 10s] [-max-lag 30s] [prefix]", runDoctor},
+}
+
+/*
+命令行参数错误，退出码为 2
+*/
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	g := new(globals)
+	fs := flag.NewFlagSet("cmanydb", flag.ContinueOnError)
+	g.register(fs)
+	fs.Usage = usage
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cmanydb: unknown command %q\n", fs.Arg(0))
+		usage()
+		return 2
+	}
+	defer g.close()
+	if err := cmd.run(g, fs.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: cmanydb %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "cmanydb %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: cmanydb [-endpoints host:port,...] [-user name -password pass] [-secret-key-file path] command [args]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+/*
+子命令的参数，解析失败时返回 errUsage
+*/
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return errUsage
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/chu108/cmany_db/config"
+	"os"
+	"strings"
+)
+
+/*
+加密 etcd 中的配置并写回，-file 加密本地文件输出到标准输出，-value 加密单个值
+*/
+func runEncrypt(g *globals, args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fields := fs.String("fields", strings.Join(config.SecretFields, ","), "要加密的字段名，多个用逗号分隔")
+	file := fs.String("file", "", "加密本地文件，结果输出到标准输出，- 表示标准输入")
+	value := fs.String("value", "", "加密单个值，结果输出到标准输出")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	sources := fs.NArg()
+	if *file != "" {
+		sources++
+	}
+	if *value != "" {
+		sources++
+	}
+	if sources != 1 {
+		return errUsage
+	}
+	secret, err := g.secretKey()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *value != "":
+		enc, err := config.Encrypt(secret, *value)
+		if err != nil {
+			return err
+		}
+		fmt.Println(enc)
+		return nil
+	case *file != "":
+		data, err := readInput(*file)
+		if err != nil {
+			return err
+		}
+		out, err := config.EncryptFields(secret, data, splitList(*fields))
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(pretty(out))
+		return nil
+	}
+	key := fs.Arg(0)
+	kv, err := g.get(key, 0)
+	if err != nil {
+		return err
+	}
+	out, err := config.EncryptFields(secret, kv.Value, splitList(*fields))
+	if err != nil {
+		return err
+	}
+	return g.putIfUnchanged(key, out, kv.ModRevision)
+}
+
+/*
+生成 AES-256 密钥，指定了 -secret-key-file 时写入该文件，权限为 0600，文件已存在时报错，避免覆盖正在使用的密钥
+没有指定时输出到标准输出，由使用者设置到 CMANYDB_SECRET_KEY
+*/
+func runKeygen(g *globals, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	if g.secretKeyFile == "" {
+		fmt.Println(encoded)
+		return nil
+	}
+	f, err := os.OpenFile(g.secretKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(encoded + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("secret key written to %s\n", g.secretKeyFile)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+导出 prefix 下的所有配置为一个 JSON 对象，key 为 etcd 的 key，值为配置内容
+值是 JSON 时原样嵌入，否则为字符串；加密字段保持加密
+*/
+func runExport(g *globals, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "-", "输出文件，- 表示标准输出")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	kvs, err := g.list(prefixArg(fs.Args()))
+	if err != nil {
+		return err
+	}
+	doc := make(map[string]json.RawMessage, len(kvs))
+	for _, kv := range kvs {
+		value := bytes.TrimSpace(kv.Value)
+		if !json.Valid(value) {
+			if value, err = json.Marshal(string(kv.Value)); err != nil {
+				return err
+			}
+		}
+		doc[string(kv.Key)] = value
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(*out, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d keys to %s\n", len(doc), *out)
+	return nil
+}
+
+/*
+导入 export 导出的文件，先校验全部配置，都通过后再写入
+*/
+func runImport(g *globals, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	force := fs.Bool("force", false, "不校验直接写入")
+	dryRun := fs.Bool("dry-run", false, "只校验并打印要写入的 key，不写入")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	keys, values, err := decodeExport(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	failed := 0
+	refs := bases(values)
+	for _, key := range keys {
+		types := []string{"-"}
+		if !*force {
+			if err := g.checkSecret(values[key]); err != nil {
+				return err
+			}
+			merged, err := g.extend(values[key], values)
+			if err == nil {
+				types, err = validate("", key, merged)
+			}
+			if err != nil && refs[key] {
+				types = []string{"base"}
+			} else if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed++
+				continue
+			}
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%s\n", key, strings.Join(types, ","))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d configs are invalid, nothing imported", failed, len(keys))
+	}
+	if *dryRun {
+		return nil
+	}
+	for _, key := range keys {
+		if err := g.put(key, values[key]); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imported %d keys\n", len(keys))
+	return nil
+}
+
+/*
+解析 export 导出的文件，返回排序后的 key 和每个 key 的值
+*/
+func decodeExport(data []byte) ([]string, map[string][]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(doc))
+	values := make(map[string][]byte, len(doc))
+	for key, raw := range doc {
+		var s string
+		value := []byte(raw)
+		if json.Unmarshal(raw, &s) == nil {
+			value = []byte(s) //导出时不是 JSON 的值
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	sort.Strings(keys)
+	return keys, values, nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+/*
+配置中引用基础配置的字段，值为基础配置的 key，如 "extends": "/common/mysql-pool"
+*/
+const ExtendsField = "extends"
+
+/*
+读取配置中 extends 引用的 key，不是 JSON 对象或没有 extends 时返回空
+*/
+func Extends(data []byte) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return "", nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", nil //不是合法的 JSON 时留给 Decode 报错
+	}
+	raw, ok := doc[ExtendsField]
+	if !ok {
+		return "", nil
+	}
+	var base string
+	if err := json.Unmarshal(raw, &base); err != nil || base == "" {
+		return "", errors.New("must be a non-empty string")
+	}
+	return base, nil
+}
+
+/*
+深度合并两个 JSON 对象，override 中的字段覆盖 base，两边都是对象的字段递归合并，数组和其它值整体替换
+结果中去掉 extends 字段
+*/
+func Merge(base, override []byte) ([]byte, error) {
+	var b, o map[string]interface{}
+	if err := unmarshalNumber(base, &b); err != nil {
+		return nil, err
+	}
+	if err := unmarshalNumber(override, &o); err != nil {
+		return nil, err
+	}
+	if b == nil || o == nil {
+		return nil, errors.New("extends can only be used between JSON objects")
+	}
+	merged := mergeObject(b, o)
+	delete(merged, ExtendsField)
+	return json.Marshal(merged)
+}
+
+func mergeObject(base, override map[string]interface{}) map[string]interface{} {
+	for name, value := range override {
+		sub, ok := value.(map[string]interface{})
+		if baseSub, baseOK := base[name].(map[string]interface{}); ok && baseOK {
+			base[name] = mergeObject(baseSub, sub)
+			continue
+		}
+		base[name] = value
+	}
+	return base
+}
+
+/*
+数字保持原样，避免大整数变成浮点数
+*/
+func unmarshalNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,94 @@
+package config_test
+
+import (
+	"github.com/chu108/cmany_db/config"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		want     string
+		err      bool
+	}{
+		{
+			name:     "override wins",
+			base:     `{"host":"a","port":3306}`,
+			override: `{"host":"b"}`,
+			want:     `{"host":"b","port":3306}`,
+		},
+		{
+			name:     "objects merge recursively",
+			base:     `{"pool":{"max_open":10,"max_idle":5},"breaker":{"cool_down":"30s"}}`,
+			override: `{"pool":{"max_open":20}}`,
+			want:     `{"breaker":{"cool_down":"30s"},"pool":{"max_idle":5,"max_open":20}}`,
+		},
+		{
+			name:     "arrays are replaced",
+			base:     `{"servers":["a","b"]}`,
+			override: `{"servers":["c"]}`,
+			want:     `{"servers":["c"]}`,
+		},
+		{
+			name:     "object replaces scalar",
+			base:     `{"retry":null}`,
+			override: `{"retry":{"max_attempts":3}}`,
+			want:     `{"retry":{"max_attempts":3}}`,
+		},
+		{
+			name:     "extends is dropped",
+			base:     `{"extends":"/root","port":1}`,
+			override: `{"extends":"/base","host":"a"}`,
+			want:     `{"host":"a","port":1}`,
+		},
+		{
+			name:     "large integers keep precision",
+			base:     `{"id":9007199254740993}`,
+			override: `{}`,
+			want:     `{"id":9007199254740993}`,
+		},
+		{name: "base is not an object", base: `[1]`, override: `{}`, err: true},
+		{name: "override is null", base: `{}`, override: `null`, err: true},
+		{name: "invalid JSON", base: `{`, override: `{}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Merge([]byte(tt.base), []byte(tt.override))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Merge = %s, want error", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("Merge = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtends(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{name: "extends", data: `{"extends":"/common/mysql","host":"a"}`, want: "/common/mysql"},
+		{name: "no extends", data: `{"host":"a"}`},
+		{name: "not an object", data: `"dsn"`},
+		{name: "invalid JSON is left to Decode", data: `{"extends":`},
+		{name: "empty", data: `{"extends":""}`, err: true},
+		{name: "not a string", data: `{"extends":1}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Extends([]byte(tt.data))
+			if (err != nil) != tt.err || got != tt.want {
+				t.Fatalf("Extends = %q, %v, want %q, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+加密字段的前缀，值为 enc: 加 base64(nonce + AES-GCM 密文)
+*/
+const SecretPrefix = "enc:"
+
+/*
+密钥的环境变量，值为 base64 编码的 16、24 或 32 字节密钥，对应 AES-128、AES-192、AES-256
+*/
+const SecretKeyEnv = "CMANYDB_SECRET_KEY"
+
+/*
+cmanydb encrypt 默认加密的字段，按字段名匹配，不区分大小写
+*/
+var SecretFields = []string{"password", "dsn"}
+
+var (
+	secretMu  sync.RWMutex
+	secretKey []byte
+)
+
+/*
+设置解密用的密钥，不设置时从环境变量 CMANYDB_SECRET_KEY 读取
+*/
+func SetSecretKey(key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	secretMu.Lock()
+	secretKey = append([]byte(nil), key...)
+	secretMu.Unlock()
+	return nil
+}
+
+/*
+SetSecretKey 设置的密钥，没有设置时从环境变量读取
+*/
+func SecretKey() ([]byte, error) {
+	secretMu.RLock()
+	key := secretKey
+	secretMu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	value, ok := os.LookupEnv(SecretKeyEnv)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("config has encrypted fields but %s is not set", SecretKeyEnv)
+	}
+	return ParseSecretKey(value)
+}
+
+/*
+解析 base64 编码的密钥
+*/
+func ParseSecretKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("secret key is not base64: %w", err)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+/*
+加密 plaintext，返回带 enc: 前缀的字符串
+*/
+func Encrypt(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return SecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/*
+解密 Encrypt 的结果，没有 enc: 前缀时原样返回
+*/
+func Decrypt(key []byte, s string) (string, error) {
+	if !strings.HasPrefix(s, SecretPrefix) {
+		return s, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, SecretPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt failed: wrong key or corrupted ciphertext")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+加密 JSON 配置中名称在 fields 中的字符串字段，已加密的字段不重复加密
+*/
+func EncryptFields(key []byte, data []byte, fields []string) ([]byte, error) {
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[strings.ToLower(f)] = true
+	}
+	return rewriteStrings(data, func(field, s string) (string, error) {
+		if !names[strings.ToLower(field)] || s == "" || strings.HasPrefix(s, SecretPrefix) {
+			return s, nil
+		}
+		return Encrypt(key, s)
+	})
+}
+
+/*
+解密 JSON 配置中所有 enc: 开头的字符串，没有加密字段时原样返回
+*/
+func DecryptFields(key []byte, data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(SecretPrefix)) {
+		return data, nil
+	}
+	return rewriteStrings(data, func(field, s string) (string, error) {
+		if !strings.HasPrefix(s, SecretPrefix) {
+			return s, nil
+		}
+		plain, err := Decrypt(key, s)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return plain, nil
+	})
+}
+
+/*
+Decode 之前解密配置，密钥在有加密字段时才读取
+*/
+func decryptConfig(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(SecretPrefix)) {
+		return data, nil
+	}
+	key, err := SecretKey()
+	if err != nil {
+		return nil, err
+	}
+	return DecryptFields(key, data)
+}
+
+/*
+遍历 JSON 中对象的字符串字段，用 fn 的返回值替换，数字保持原样
+*/
+func rewriteStrings(data []byte, fn func(field, s string) (string, error)) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	doc, err := rewrite(doc, "", fn)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func rewrite(v interface{}, field string, fn func(field, s string) (string, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			out, err := rewrite(item, k, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = out
+		}
+	case []interface{}:
+		for i, item := range v {
+			out, err := rewrite(item, field, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = out
+		}
+	case string:
+		return fn(field, v)
+	}
+	return v, nil
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/dberr"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+配置填充默认值，在校验之前调用
+*/
+type Defaulter interface {
+	SetDefaults()
+}
+
+/*
+配置校验，把发现的问题都加入 p
+*/
+type Validator interface {
+	Validate(p *Problems)
+}
+
+/*
+收集配置中的问题，一次返回全部
+*/
+type Problems struct {
+	prefix string
+	list   *[]dberr.FieldError
+}
+
+func NewProblems() *Problems {
+	return &Problems{list: new([]dberr.FieldError)}
+}
+
+/*
+嵌套字段的问题，字段名前加上 prefix，如 master.dsn
+*/
+func (p *Problems) Sub(prefix string) *Problems {
+	return &Problems{prefix: p.field(prefix), list: p.list}
+}
+
+/*
+数组元素的问题，字段名前加上下标，如 shards[0].master.dsn
+*/
+func (p *Problems) Index(i int) *Problems {
+	return &Problems{prefix: fmt.Sprintf("%s[%d]", p.prefix, i), list: p.list}
+}
+
+func (p *Problems) field(name string) string {
+	if p.prefix == "" {
+		return name
+	}
+	if name == "" {
+		return p.prefix
+	}
+	return p.prefix + "." + name
+}
+
+func (p *Problems) Add(field, problem string) {
+	*p.list = append(*p.list, dberr.FieldError{Field: p.field(field), Problem: problem})
+}
+
+func (p *Problems) Addf(field, format string, args ...interface{}) {
+	p.Add(field, fmt.Sprintf(format, args...))
+}
+
+/*
+必填的字符串字段
+*/
+func (p *Problems) Required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		p.Add(field, "required")
+	}
+}
+
+/*
+整数字段的范围，包含 min 和 max
+*/
+func (p *Problems) Range(field string, value, min, max int) {
+	if value < min || value > max {
+		p.Addf(field, "must be between %d and %d, got %d", min, max, value)
+	}
+}
+
+/*
+时间长度不能为负数
+*/
+func (p *Problems) NonNegative(field string, d Duration) {
+	if d < 0 {
+		p.Addf(field, "must not be negative, got %s", d)
+	}
+}
+
+/*
+嵌套的配置，为 nil 时跳过
+*/
+func (p *Problems) Check(field string, v Validator) {
+	if v == nil {
+		return
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return
+	}
+	v.Validate(p.Sub(field))
+}
+
+func (p *Problems) Len() int {
+	return len(*p.list)
+}
+
+/*
+没有问题时返回 nil，否则返回带 key 的 *dberr.ConfigError
+*/
+func (p *Problems) Err(key string) error {
+	if p.Len() == 0 {
+		return nil
+	}
+	return &dberr.ConfigError{Key: key, Fields: append([]dberr.FieldError(nil), *p.list...)}
+}
+
+/*
+解析 JSON 配置：解密 enc: 开头的字段，拒绝未知字段，填充默认值并校验，所有问题一次返回
+key 配置的 key，出现在错误信息中
+v 指向配置结构体的指针，实现了 Defaulter、Validator 时会被调用
+*/
+func Decode(key string, data []byte, v interface{}) error {
+	data, err := decryptConfig(data)
+	if err != nil {
+		return dberr.Config(key, err)
+	}
+	var raw interface{}
+	if !json.Valid(data) {
+		return dberr.Config(key, json.Unmarshal(data, &raw))
+	}
+	//数字保持原样，去掉无法解析的字段后重新编码时不丢失精度
+	if err := unmarshalNumber(data, &raw); err != nil {
+		return dberr.Config(key, err)
+	}
+	p := NewProblems()
+	checkFields(p, raw, reflect.TypeOf(v))
+	if data, err = json.Marshal(raw); err != nil {
+		return dberr.Config(key, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return dberr.Config(key, err)
+		}
+		//类型错误时 json 会继续解析其它字段，继续检查剩下的问题
+		p.Addf(typeErr.Field, "cannot use %s as %s", typeErr.Value, typeErr.Type)
+	}
+
+	if d, ok := v.(Defaulter); ok {
+		d.SetDefaults()
+	}
+	if c, ok := v.(Validator); ok {
+		c.Validate(p)
+	}
+	return p.Err(key)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+/*
+对照结构体的 json 字段找出配置中多余的字段，字段名和 encoding/json 一样不区分大小写
+自定义解析的字段（如 Duration）先单独解析，出错时记录问题并返回 false，由调用方去掉该值，
+否则 encoding/json 遇到这类错误会停止解析，后面的问题就检查不到了
+*/
+func checkFields(p *Problems, raw interface{}, t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		b, err := json.Marshal(raw)
+		if err == nil {
+			err = reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(b)
+		}
+		if err != nil {
+			p.Add("", err.Error())
+			return false
+		}
+		return true
+	}
+	if list, ok := raw.([]interface{}); ok && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		for i, item := range list {
+			if !checkFields(p.Index(i), item, t.Elem()) {
+				list[i] = nil
+			}
+		}
+		return true
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok || t.Kind() != reflect.Struct {
+		return true
+	}
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ft, ok := fields[strings.ToLower(k)]
+		if !ok {
+			p.Add(k, "unknown field")
+			continue
+		}
+		if !checkFields(p.Sub(k), obj[k], ft) {
+			delete(obj, k)
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package config_test
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"reflect"
+	"testing"
+)
+
+type nested struct {
+	Timeout config.Duration `json:"timeout"`
+}
+
+type testConfig struct {
+	Name    string          `json:"name"`
+	Port    int             `json:"port"`
+	ID      int64           `json:"id"`
+	Timeout config.Duration `json:"timeout"`
+	Nested  *nested         `json:"nested"`
+	List    []nested        `json:"list"`
+}
+
+func (c *testConfig) SetDefaults() {
+	if c.Port == 0 {
+		c.Port = 80
+	}
+}
+
+func (c *testConfig) Validate(p *config.Problems) {
+	p.Required("name", c.Name)
+	p.NonNegative("timeout", c.Timeout)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   testConfig
+		fields []string
+	}{
+		{
+			name: "valid",
+			data: `{"name":"a","timeout":"2s","id":9007199254740993,"nested":{"timeout":1.5}}`,
+			want: testConfig{Name: "a", Port: 80, ID: 9007199254740993, Timeout: config.Duration(2e9), Nested: &nested{Timeout: config.Duration(1.5e9)}},
+		},
+		{
+			name:   "unknown and missing fields",
+			data:   `{"nmae":"a","nested":{"timeuot":"1s"}}`,
+			fields: []string{"nested.timeuot", "nmae", "name"},
+		},
+		{
+			name:   "type error keeps checking",
+			data:   `{"name":"a","port":"x","extra":1}`,
+			fields: []string{"extra", "port"},
+		},
+		{
+			name:   "invalid durations keep checking",
+			data:   `{"timeout":"abc","nested":{"timeout":true},"list":[{"timeout":"1s"},{"timeout":"x"}],"extra":1}`,
+			fields: []string{"extra", "list[1].timeout", "nested.timeout", "timeout", "name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got testConfig
+			err := config.Decode("key", []byte(tt.data), &got)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Decode = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("Decode = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			var cfgErr *dberr.ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("Decode = %v, want *dberr.ConfigError", err)
+			}
+			var fields []string
+			for _, f := range cfgErr.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("fields = %q, want %q (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}
+
+func TestDecodeSyntaxError(t *testing.T) {
+	var got testConfig
+	err := config.Decode("key", []byte(`{"name":`), &got)
+	if !errors.Is(err, dberr.ErrInvalidConfig) {
+		t.Fatalf("Decode = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,139 @@
+package dberr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+/*
+驱动错误的分类，每个字段判断一类错误，为空时表示该驱动没有这类错误
+各数据库包在 init 中调用 RegisterClassifier 注册，dberr 本身不依赖任何驱动
+*/
+type Classifier struct {
+	Timeout      func(err error) bool
+	DuplicateKey func(err error) bool
+	NotFound     func(err error) bool
+	Auth         func(err error) bool
+	Retryable    func(err error) bool
+}
+
+var (
+	classifierMu sync.RWMutex
+	classifiers  = map[string]Classifier{}
+)
+
+/*
+注册驱动的错误分类，name 为数据库包的名称，如 mysql，同名时覆盖
+*/
+func RegisterClassifier(name string, c Classifier) {
+	classifierMu.Lock()
+	classifiers[name] = c
+	classifierMu.Unlock()
+}
+
+/*
+任何一个已注册的分类判断 err 属于 field 取出的那一类
+*/
+func classify(err error, field func(c Classifier) func(err error) bool) bool {
+	classifierMu.RLock()
+	defer classifierMu.RUnlock()
+	for _, c := range classifiers {
+		if fn := field(c); fn != nil && fn(err) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+是否是连接类的错误（网络错误、连接断开、超时），服务端返回的业务错误不算
+*/
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+/*
+是否超时：ctx 超时、网络超时，以及已注册的驱动识别的超时，如 mysql 锁等待超时、elasticsearch 408
+*/
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return classify(err, func(c Classifier) func(error) bool { return c.Timeout })
+}
+
+/*
+是否是唯一键冲突，由已注册的驱动识别，如 mysql 1062、postgres 23505、mongodb E11000
+*/
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	return classify(err, func(c Classifier) func(error) bool { return c.DuplicateKey })
+}
+
+/*
+是否是数据不存在：sql.ErrNoRows、配置 key 不存在，以及已注册的驱动识别的未命中，如 redis nil、mongodb 无文档
+*/
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrConfigNotFound) {
+		return true
+	}
+	return classify(err, func(c Classifier) func(error) bool { return c.NotFound })
+}
+
+/*
+是否是认证失败或没有权限
+*/
+func IsAuth(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrAuth) {
+		return true
+	}
+	return classify(err, func(c Classifier) func(error) bool { return c.Auth })
+}
+
+/*
+是否值得重试：连接类错误、超时，以及已注册的驱动识别的可重试错误，如死锁、主从切换
+调用方取消、配置错误、认证失败、唯一键冲突不重试
+*/
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrInvalidConfig) || errors.Is(err, ErrConfigNotFound) || IsAuth(err) || IsDuplicateKey(err) {
+		return false
+	}
+	if errors.Is(err, ErrUnreachable) || IsConnError(err) || IsTimeout(err) {
+		return true
+	}
+	return classify(err, func(c Classifier) func(error) bool { return c.Retryable })
+}
@@ -0,0 +1,152 @@
+package dberr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+各包返回的错误都可以用 errors.Is 判断是否属于下面几类，原始错误可以用 errors.Unwrap 或 errors.As 取出
+*/
+var (
+	ErrConfigNotFound = errors.New("config not found")      //etcd 地址或配置 key 不存在
+	ErrInvalidConfig  = errors.New("invalid config")        //配置格式或字段错误
+	ErrUnreachable    = errors.New("database unreachable")  //启动时连接不上数据库或 etcd
+	ErrLockHeld       = errors.New("lock held by another")  //etcd 锁已被占用
+	ErrAuth           = errors.New("authentication failed") //用户名、密码错误或没有权限
+)
+
+/*
+带实例名称和原始错误的分类错误
+Kind 错误分类，为上面的哨兵错误之一
+Key 实例名称或配置的 key
+Err 原始错误，可以为空
+*/
+type Error struct {
+	Kind error
+	Key  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Key != "" {
+		msg = e.Key + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+/*
+把 err 包装为 kind 类错误，err 为空时也返回错误
+*/
+func Wrap(kind error, key string, err error) error {
+	return &Error{Kind: kind, Key: key, Err: err}
+}
+
+/*
+配置中的一个字段错误
+*/
+type FieldError struct {
+	Field   string
+	Problem string
+}
+
+func (f FieldError) String() string {
+	if f.Field == "" {
+		return f.Problem
+	}
+	return f.Field + ": " + f.Problem
+}
+
+/*
+配置错误，errors.Is(err, ErrInvalidConfig) 为 true
+Fields 出错的字段，Err 为解析时的原始错误
+*/
+type ConfigError struct {
+	Key    string
+	Fields []FieldError
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	msg := ErrInvalidConfig.Error()
+	if e.Key != "" {
+		msg = e.Key + ": " + msg
+	}
+	problems := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		problems = append(problems, f.String())
+	}
+	if len(problems) > 0 {
+		return msg + ": " + strings.Join(problems, "; ")
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+/*
+把解析配置 JSON 时的错误转换为 ConfigError，尽量取出出错的字段
+*/
+func Config(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	cfgErr := &ConfigError{Key: key, Err: err}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		cfgErr.Fields = []FieldError{{
+			Field:   typeErr.Field,
+			Problem: fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type),
+		}}
+	case errors.As(err, &syntaxErr):
+		cfgErr.Fields = []FieldError{{Problem: fmt.Sprintf("%s at offset %d", syntaxErr, syntaxErr.Offset)}}
+	}
+	return cfgErr
+}
+
+/*
+启动时连接失败的错误分类：认证失败包装为 ErrAuth，连接类错误包装为 ErrUnreachable，其它错误原样返回
+*/
+func Connect(key string, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var e *Error
+	var cfgErr *ConfigError
+	if errors.As(err, &e) || errors.As(err, &cfgErr) {
+		return err
+	}
+	if IsAuth(err) {
+		return Wrap(ErrAuth, key, err)
+	}
+	if IsRetryable(err) {
+		return Wrap(ErrUnreachable, key, err)
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package elasticsearch
+
+import (
+	"github.com/chu108/cmany_db/config"
+	"net/url"
+)
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("http_addr", c.HttpAddr)
+	if c.HttpAddr != "" {
+		if u, err := url.Parse(c.HttpAddr); err != nil {
+			p.Add("http_addr", err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			p.Add("http_addr", "must start with http:// or https://")
+		}
+	}
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.Check("retry", c.Retry)
+	p.Check("breaker", c.Breaker)
+	if c.Breaker != nil && c.Breaker.Fallback != "" {
+		p.Addf("breaker.fallback", "%s is not supported by elasticsearch", c.Breaker.Fallback)
+	}
+}
@@ -2,37 +2,220 @@ package elasticsearch
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/logger"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
 	"github.com/olivere/elastic"
+	"net/http"
+	"sync"
 )
 
+/*
+conn 返回的客户端对应的实例名称，Close 时按名称取消注册健康检查和熔断器
+*/
+var instances sync.Map
+
+type dbConn struct {
+	HttpAddr    string          `json:"http_addr"`
+	PingTimeout config.Duration `json:"ping_timeout"` //启动时每次 ping 的超时时间，为 0 时只受 ctx 控制
+	Retry       *retry.Policy   `json:"retry"`        //启动时 ping 的重试策略，为空时使用全局默认策略
+	Lazy        bool            `json:"lazy"`         //延迟连接，创建时不 ping，并关闭启动时的嗅探和健康检查
+	Breaker     *breaker.Config `json:"breaker"`      //熔断配置，为空时不熔断
+}
+
+/*
+通过ETCD方式连接数据库
+dbKey etcd存储的数据库连接字符串的key
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcd(dbKey string, endpoints ...string) (*elastic.Client, error) {
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*elastic.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ETCD 授权方式连接数据库
+dbKey etcd存储的数据库连接字符串的key
+etcdName etcd用户名
+etcdPass etcd密码
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*elastic.Client, error) {
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*elastic.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ENV 变量方式连接数据库
+env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
+dbKey etcd存储的数据库连接字符串的key
+*/
+func ConnByEnv(env, dbKey string) (*elastic.Client, error) {
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*elastic.Client, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
 /*
 以字符串的方式连接数据库
 httpAddr api地址
 */
 func ConnByStr(httpAddr string) (*elastic.Client, error) {
-	return conn(httpAddr)
+	return ConnByStrCtx(context.Background(), httpAddr)
 }
 
-func conn(httpAddr string) (*elastic.Client, error) {
-	ctx := context.Background()
+/*
+以字符串的方式连接数据库，ctx 控制启动时 ping 的时间
+*/
+func ConnByStrCtx(ctx context.Context, httpAddr string) (*elastic.Client, error) {
+	cfg := new(dbConn)
+	cfg.HttpAddr = httpAddr
+	return conn(ctx, httpAddr, cfg)
+}
 
-	client, err := elastic.NewClient()
-	if err != nil {
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (*elastic.Client, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (*elastic.Client, error) {
+	return connByConnByte(ctx, name, data)
+}
+
+func connByConnByte(ctx context.Context, name string, connByte []byte) (*elastic.Client, error) {
+	cfg := new(dbConn)
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, err
 	}
+	return conn(ctx, name, cfg)
+}
 
-	info, code, err := client.Ping(httpAddr).Do(ctx)
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (*elastic.Client, error) {
+	httpAddr := cfg.HttpAddr
+	rt := newTransport(name)
+	if cfg.Breaker != nil {
+		rt.breaker = breaker.New(name, *cfg.Breaker, isFailure)
+	}
+	options := []elastic.ClientOptionFunc{
+		elastic.SetURL(httpAddr),
+		elastic.SetHttpClient(&http.Client{Transport: rt}),
+	}
+	if cfg.Lazy {
+		options = append(options, elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		client, err := elastic.NewClient(options...)
+		if err != nil {
+			if rt.breaker != nil {
+				breaker.Unregister(name)
+			}
+			return nil, err
+		}
+		register(name, httpAddr, client)
+		return client, nil
+	}
+
+	var client *elastic.Client
+	err := retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) (err error) {
+		if cfg.PingTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.PingTimeout.Std())
+			defer cancel()
+		}
+		client, err = ping(ctx, name, httpAddr, options)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		metrics.ConnectError(backend, name)
+		if rt.breaker != nil {
+			breaker.Unregister(name)
+		}
+		return nil, dberr.Connect(name, err)
 	}
-	fmt.Printf("Elasticsearch returned with code %d and version %s\n", code, info.Version.Number)
+	register(name, httpAddr, client)
+	return client, nil
+}
+
+func register(name, httpAddr string, client *elastic.Client) {
+	health.Register(backend, name, func(ctx context.Context) error {
+		_, _, err := client.Ping(httpAddr).Do(ctx)
+		return err
+	})
+	instances.Store(client, name)
+}
 
-	esversion, err := client.ElasticsearchVersion(httpAddr)
+/*
+停止 conn 返回的客户端的后台嗅探和健康检查，同时取消注册健康检查和熔断器
+*/
+func Close(client *elastic.Client) {
+	if name, ok := instances.LoadAndDelete(client); ok {
+		health.Unregister(name.(string))
+		breaker.Unregister(name.(string))
+	}
+	client.Stop()
+}
+
+/*
+transport 只把网络错误和 5xx 交给熔断器，调用方取消的请求不计入失败
+*/
+func isFailure(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+func ping(ctx context.Context, name, httpAddr string, options []elastic.ClientOptionFunc) (*elastic.Client, error) {
+	client, err := elastic.NewClient(options...)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("Elasticsearch version %s\n", esversion)
 
+	info, code, err := client.Ping(httpAddr).Do(ctx)
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+	logger.For(name).Info("elasticsearch ping", logger.F("code", code), logger.F("version", info.Version.Number))
 	return client, nil
 }
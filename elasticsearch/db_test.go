@@ -0,0 +1,88 @@
+package elasticsearch_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/elasticsearch"
+	"github.com/olivere/elastic"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/elasticsearch"
+
+/*
+通过客户端读到服务端的版本号，然后关闭客户端
+*/
+func version(url string, client *elastic.Client, err error) error {
+	if err != nil {
+		return err
+	}
+	defer elasticsearch.Close(client)
+	got, err := client.ElasticsearchVersion(url)
+	if err != nil {
+		return err
+	}
+	if got != cmanydbtest.ElasticVersion {
+		return fmt.Errorf("version = %q, want %q", got, cmanydbtest.ElasticVersion)
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	url := cmanydbtest.NewElastic(t)
+	if err := env.Put(dbKey, map[string]interface{}{"http_addr": url}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			client, err := elasticsearch.ConnByEtcd(key, endpoints...)
+			return version(url, client, err)
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			client, err := elasticsearch.ConnByEtcdAuth(key, user, password, endpoints...)
+			return version(url, client, err)
+		},
+		Env: func(env, key string) error {
+			client, err := elasticsearch.ConnByEnv(env, key)
+			return version(url, client, err)
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	url := cmanydbtest.NewElastic(t)
+	client, err := elasticsearch.ConnByStr(url)
+	if err := version(url, client, err); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = elasticsearch.ConnByStr("http://" + cmanydbtest.ClosedAddr(t))
+	if !errors.Is(err, dberr.ErrUnreachable) {
+		t.Fatalf("closed port = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestConnByJSONLazy(t *testing.T) {
+	//elasticsearch 不可用时延迟连接也能创建
+	data := []byte(`{"http_addr":"http://` + cmanydbtest.ClosedAddr(t) + `","lazy":true}`)
+	client, err := elasticsearch.ConnByJSON("lazy", data)
+	if err != nil {
+		t.Fatalf("lazy connect: %v", err)
+	}
+	elasticsearch.Close(client)
+}
+
+func TestConnByJSONUnregistersBreaker(t *testing.T) {
+	data := []byte(`{"http_addr":"http://` + cmanydbtest.ClosedAddr(t) + `","breaker":{}}`)
+	if _, err := elasticsearch.ConnByJSON("breaker", data); err == nil {
+		t.Fatal("connect to closed port succeeded")
+	}
+	if breaker.Get("breaker") != nil {
+		t.Fatal("breaker still registered after failed connect")
+	}
+}
@@ -0,0 +1,44 @@
+package elasticsearch
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/olivere/elastic"
+	"net/http"
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		Timeout: func(err error) bool {
+			return errStatus(err) == http.StatusRequestTimeout
+		},
+		DuplicateKey: func(err error) bool {
+			return errStatus(err) == http.StatusConflict
+		},
+		NotFound: func(err error) bool {
+			return errStatus(err) == http.StatusNotFound
+		},
+		Auth: func(err error) bool {
+			status := errStatus(err)
+			return status == http.StatusUnauthorized || status == http.StatusForbidden
+		},
+		Retryable: func(err error) bool {
+			if elastic.IsConnErr(err) {
+				return true
+			}
+			switch errStatus(err) {
+			case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				return true
+			}
+			return false
+		},
+	})
+}
+
+func errStatus(err error) int {
+	var esErr *elastic.Error
+	if errors.As(err, &esErr) {
+		return esErr.Status
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const backend = "elasticsearch"
+
+/*
+包装 http.RoundTripper，统计每个请求的耗时，开启追踪时为每个请求创建 span
+配置了熔断时，熔断期间请求直接返回 breaker.ErrOpen
+*/
+type transport struct {
+	next    http.RoundTripper
+	name    string
+	breaker *breaker.Breaker
+}
+
+func newTransport(name string) *transport {
+	return &transport{next: http.DefaultTransport, name: name}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.breaker != nil {
+		if err := t.breaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
+	op := operation(req)
+	ctx, span := tracing.Start(req.Context(), backend, t.name, op, req.Method+" "+req.URL.Path)
+	if span != nil {
+		req = req.WithContext(ctx)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	failed := err
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		failed = fmt.Errorf("elasticsearch: %s", resp.Status)
+	}
+	metrics.Observe(backend, t.name, op, start, failed)
+	if t.breaker != nil {
+		t.breaker.ReportResult(failed)
+	}
+	tracing.End(span, failed)
+	return resp, err
+}
+
+/*
+以请求方法和路径中的 _ 开头的接口名作为命令名称，如 POST _search
+*/
+func operation(req *http.Request) string {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if strings.HasPrefix(parts[i], "_") {
+			return req.Method + " " + parts[i]
+		}
+	}
+	return req.Method
+}
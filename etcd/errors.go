@@ -0,0 +1,16 @@
+package etcd
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+func init() {
+	dberr.RegisterClassifier("etcd", dberr.Classifier{
+		Auth: func(err error) bool {
+			return errors.Is(err, rpctypes.ErrAuthFailed) || errors.Is(err, rpctypes.ErrPermissionDenied) ||
+				errors.Is(err, rpctypes.ErrGRPCAuthFailed) || errors.Is(err, rpctypes.ErrGRPCPermissionDenied)
+		},
+	})
+}
@@ -3,9 +3,13 @@ package etcd
 import (
 	"context"
 	"fmt"
-	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/logger"
+	"github.com/chu108/cmany_db/retry"
 	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
 	"os"
 	"strings"
 	"time"
@@ -16,9 +20,21 @@ type etcd struct {
 	userName  string
 	passWord  string
 	cli       *clientv3.Client
+	log       logger.Logger
+	retry     *retry.Policy
+	dial      time.Duration //建立连接的超时时间，默认 10s
+	request   time.Duration //单次读取的超时时间，默认 5s
+	env       string        //命名空间，见 Namespace
+	service   string        //服务名，见 Namespace
+	nsSet     bool          //调用过 Namespace，不再读取环境变量
 	err       error
 }
 
+const (
+	defaultDialTimeout    = time.Second * 10
+	defaultRequestTimeout = time.Second * 5
+)
+
 func Conn(endpoints ...string) *etcd {
 	etcd := new(etcd)
 	etcd.endpoints = endpoints
@@ -31,6 +47,37 @@ func (e *etcd) Auth(un, up string) *etcd {
 	return e
 }
 
+/*
+设置超时时间，为 0 时使用默认值
+dial 建立连接的超时时间，默认 10s
+request 单次读取的超时时间，默认 5s
+*/
+func (e *etcd) Timeout(dial, request time.Duration) *etcd {
+	e.dial = dial
+	e.request = request
+	return e
+}
+
+/*
+设置读取配置的重试策略，不设置时使用 retry 包的全局默认策略
+*/
+func (e *etcd) Retry(p *retry.Policy) *etcd {
+	e.retry = p
+	return e
+}
+
+/*
+为当前实例单独设置日志，不设置时使用 logger 包的全局日志
+*/
+func (e *etcd) Logger(l logger.Logger) *etcd {
+	e.log = l
+	return e
+}
+
+func (e *etcd) entry(key string) *logger.Entry {
+	return logger.For(key).Use(e.log)
+}
+
 /*
 获取ETCD地址列表
 格式：ETCD_ADDR=192.168.1.1:1000,192.168.1.1:1000,192.168.1.1:1000
@@ -39,7 +86,7 @@ func ConnByEnv(env string) *etcd {
 	etcd := new(etcd)
 	addr, ok := os.LookupEnv(env)
 	if !ok {
-		etcd.err = fmt.Errorf("%w", errors.New("ETCD_ADDR not found"))
+		etcd.err = dberr.Wrap(dberr.ErrConfigNotFound, env, errors.New("etcd 地址的环境变量不存在"))
 		return etcd
 	}
 	etcd.endpoints = strings.Split(strings.TrimSpace(addr), ",")
@@ -50,24 +97,38 @@ func ConnByEnv(env string) *etcd {
 获取ETCD客户端
 */
 func (e *etcd) etcdClient() *clientv3.Client {
-	if len(e.endpoints) == 0 || e.endpoints[0] == "" {
-		e.err = fmt.Errorf("%w", errors.New("ETCD_ADDR not found"))
+	cli, err := e.newClient(nil)
+	if err != nil {
+		e.err = fmt.Errorf("%w", err)
 		return nil
 	}
-	//获取客户端对象
-	cli, err := clientv3.New(clientv3.Config{
+	return cli
+}
+
+/*
+ctx 不为空时作为客户端的生命周期，ctx 结束时客户端随之关闭
+*/
+func (e *etcd) newClient(ctx context.Context) (*clientv3.Client, error) {
+	if len(e.endpoints) == 0 || e.endpoints[0] == "" {
+		return nil, retry.Permanent(&dberr.ConfigError{
+			Key:    "etcd",
+			Fields: []dberr.FieldError{{Field: "endpoints", Problem: "empty"}},
+		})
+	}
+	dial := e.dial
+	if dial <= 0 {
+		dial = defaultDialTimeout
+	}
+	//获取客户端对象，WithBlock 使 etcd 不可达时在 dial 超时后返回错误，而不是等到第一次读取
+	return clientv3.New(clientv3.Config{
 		Endpoints:        e.endpoints,
 		AutoSyncInterval: time.Hour,
-		DialTimeout:      time.Second * 10,
+		DialTimeout:      dial,
+		DialOptions:      []grpc.DialOption{grpc.WithBlock()},
 		Username:         e.userName,
 		Password:         e.passWord,
+		Context:          ctx,
 	})
-	if err != nil {
-		e.err = fmt.Errorf("%w", err)
-		return nil
-	}
-
-	return cli
 }
 
 /**
@@ -92,33 +153,86 @@ func EtcdWatch(cli *clientv3.Client, kv string) {
 	watchRespChan := watcher.Watch(context.Background(), kv)
 	for watchResp := range watchRespChan {
 		for _, event := range watchResp.Events {
+			log := logger.For(string(event.Kv.Key))
 			switch event.Type {
 			case mvccpb.PUT:
-				fmt.Println("修改为:", string(event.Kv.Value), "Revision:", event.Kv.CreateRevision, event.Kv.ModRevision)
+				log.Info("etcd key modified",
+					logger.F("value", event.Kv.Value),
+					logger.F("create_revision", event.Kv.CreateRevision),
+					logger.F("mod_revision", event.Kv.ModRevision),
+				)
 			case mvccpb.DELETE:
-				fmt.Println("删除了", "Revision:", event.Kv.ModRevision)
+				log.Info("etcd key deleted", logger.F("mod_revision", event.Kv.ModRevision))
 			}
 		}
 	}
 }
 
+/*
+读取 key 的值，etcd 连接失败时按重试策略重试，key 不存在时不重试
+key 不以 / 开头且设置了命名空间时按 Keys 的顺序查找，返回第一个存在的值
+值中有 extends 时读取引用的基础配置并合并，见 config.Merge
+*/
 func (e *etcd) Get(key string) ([]byte, error) {
-	cli := e.etcdClient()
+	return e.GetCtx(context.Background(), key)
+}
+
+/*
+读取 key 的值，ctx 控制连接、读取和重试的整体时间
+*/
+func (e *etcd) GetCtx(ctx context.Context, key string) ([]byte, error) {
 	if e.err != nil {
 		return nil, e.err
 	}
-	ctx, cencel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cencel()
+	var res *loaded
+	err := retry.Do(ctx, key, e.retry, func(ctx context.Context) (err error) {
+		res, err = e.get(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, dberr.Connect(key, err)
+	}
 
-	res, err := cli.KV.Get(ctx, key)
+	e.entry(key).Debug("etcd get", logger.F("keys", res.sources), logger.F("value", res.value))
+	return res.value, nil
+}
+
+func (e *etcd) get(ctx context.Context, key string) (*loaded, error) {
+	cli, err := e.newClient(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer cli.Close()
+	return e.load(ctx, cli, key)
+}
 
-	if len(res.Kvs) == 0 {
-		return nil, errors.New("key 对应的值为空")
+/*
+按顺序读取 keys，返回第一个存在的 key 和值，rev 为读取时 etcd 的 revision
+每次读取单独计算超时时间
+*/
+func (e *etcd) find(ctx context.Context, cli *clientv3.Client, keys []string) (string, []byte, int64, error) {
+	var rev int64
+	for _, key := range keys {
+		res, err := e.getKey(ctx, cli, key)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if rev == 0 {
+			rev = res.Header.Revision
+		}
+		if len(res.Kvs) > 0 {
+			return key, res.Kvs[0].Value, rev, nil
+		}
 	}
+	return "", nil, 0, retry.Permanent(dberr.Wrap(dberr.ErrConfigNotFound, strings.Join(keys, ", "), errors.New("key 对应的值为空")))
+}
 
-	fmt.Println(string(res.Kvs[0].Value))
-	return res.Kvs[0].Value, nil
+func (e *etcd) getKey(ctx context.Context, cli *clientv3.Client, key string) (*clientv3.GetResponse, error) {
+	request := e.request
+	if request <= 0 {
+		request = defaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, request)
+	defer cancel()
+	return cli.KV.Get(ctx, key)
 }
@@ -0,0 +1,181 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/logger"
+	"github.com/chu108/cmany_db/retry"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strings"
+)
+
+/*
+extends 最多嵌套的层数
+*/
+const maxExtends = 8
+
+/*
+合并后的配置
+sources 参与合并的 key，包括命名空间中排在前面但不存在的 key，其中任何一个修改都可能改变结果
+rev 读取时 etcd 的 revision，监听从下一个 revision 开始
+*/
+type loaded struct {
+	value   []byte
+	sources []string
+	rev     int64
+}
+
+/*
+读取 name 及其 extends 链上的配置，从最底层的基础配置开始依次用上层的配置覆盖
+没有 extends 时原样返回读取到的值，读取失败时返回的 sources 为已经查找过的 key
+*/
+func (e *etcd) load(ctx context.Context, cli *clientv3.Client, name string) (*loaded, error) {
+	res := new(loaded)
+	var chain [][]byte
+	var found []string
+	for name != "" {
+		keys := e.Keys(name)
+		key, value, rev, err := e.find(ctx, cli, keys)
+		for _, k := range keys {
+			res.sources = append(res.sources, k)
+			if k == key {
+				break
+			}
+		}
+		if err != nil {
+			return res, err
+		}
+		if res.rev == 0 {
+			res.rev = rev
+		}
+		for _, k := range found {
+			if k == key {
+				return res, extendsError(found[0], "cycle: "+strings.Join(append(found, key), " -> "))
+			}
+		}
+		if len(found) == maxExtends {
+			return res, extendsError(found[0], "too many levels: "+strings.Join(append(found, key), " -> "))
+		}
+		found = append(found, key)
+		chain = append(chain, value)
+		if name, err = config.Extends(value); err != nil {
+			return res, extendsError(key, err.Error())
+		}
+	}
+
+	res.value = chain[len(chain)-1]
+	for i := len(chain) - 2; i >= 0; i-- {
+		merged, err := config.Merge(res.value, chain[i])
+		if err != nil {
+			return res, extendsError(found[i], err.Error())
+		}
+		res.value = merged
+	}
+	return res, nil
+}
+
+func extendsError(key, problem string) error {
+	return retry.Permanent(&dberr.ConfigError{
+		Key:    key,
+		Fields: []dberr.FieldError{{Field: config.ExtendsField, Problem: problem}},
+	})
+}
+
+/*
+监听 key 的配置，读取后先回调一次，之后 key 或 extends 链上的任何一个 key 修改时重新读取合并
+合并后的值有变化时回调，读取失败时回调 err，extends 修改后按新的链监听
+回调在同一个 goroutine 中顺序执行，ctx 结束时返回 ctx 的错误，首次读取失败时直接返回错误
+*/
+func (e *etcd) Watch(ctx context.Context, key string, onChange func(value []byte, err error)) error {
+	if e.err != nil {
+		return e.err
+	}
+	cli, err := e.newClient(ctx)
+	if err != nil {
+		return dberr.Connect(key, err)
+	}
+	defer cli.Close()
+	res, err := e.load(ctx, cli, key)
+	if err != nil {
+		return dberr.Connect(key, err)
+	}
+	onChange(res.value, nil)
+
+	last, sources, rev := res.value, res.sources, res.rev
+	for {
+		next, err := watchKeys(ctx, cli, sources, rev)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			e.entry(key).Warn("etcd watch", logger.F("error", err.Error()))
+		}
+		res, err := e.load(ctx, cli, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			//继续监听已经读取的 key 和不存在的 key，它们修改或创建后重试
+			if res != nil {
+				sources = union(sources, res.sources)
+			}
+			last, rev = nil, next
+			onChange(nil, dberr.Connect(key, err))
+			continue
+		}
+		sources, rev = res.sources, res.rev
+		if bytes.Equal(res.value, last) {
+			continue
+		}
+		last = res.value
+		e.entry(key).Info("etcd config reloaded", logger.F("keys", sources))
+		onChange(res.value, nil)
+	}
+}
+
+func union(a, b []string) []string {
+	for _, s := range b {
+		found := false
+		for _, t := range a {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+/*
+从 rev 之后开始监听 keys，任何一个有修改时返回修改时的 revision
+*/
+func watchKeys(ctx context.Context, cli *clientv3.Client, keys []string, rev int64) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan clientv3.WatchResponse, len(keys))
+	for _, key := range keys {
+		go func(ch clientv3.WatchChan) {
+			for res := range ch {
+				if len(res.Events) == 0 && res.Err() == nil {
+					continue
+				}
+				select {
+				case events <- res:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}(cli.Watch(ctx, key, clientv3.WithRev(rev+1)))
+	}
+	select {
+	case res := <-events:
+		return res.Header.Revision, res.Err()
+	case <-ctx.Done():
+		return rev, ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+package etcd
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+/*
+应用代码用到的 etcd 读写操作，单元测试中可以用 fake.NewEtcd() 代替
+Get 的 key 不存在时返回 dberr.ErrConfigNotFound
+*/
+type KV interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, key string) error
+}
+
+/*
+etcd 锁，ttl 小于等于 0 时回调结束后删除锁（同 Lock），大于 0 时锁在 ttl 秒后过期（同 LockTtl）
+锁已被占用时返回 dberr.ErrLockHeld
+*/
+type Locker interface {
+	Lock(key string, ttl int64, callBack func() error) error
+}
+
+/*
+用 etcd 客户端实现 KV 和 Locker
+*/
+func NewKV(client *clientv3.Client) *ClientKV {
+	return &ClientKV{client: client}
+}
+
+type ClientKV struct {
+	client *clientv3.Client
+}
+
+func (c *ClientKV) Get(ctx context.Context, key string) ([]byte, error) {
+	res, err := c.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, dberr.Wrap(dberr.ErrConfigNotFound, key, errors.New("key 对应的值为空"))
+	}
+	return res.Kvs[0].Value, nil
+}
+
+func (c *ClientKV) Put(ctx context.Context, key, value string) error {
+	_, err := c.client.Put(ctx, key, value)
+	return err
+}
+
+func (c *ClientKV) Delete(ctx context.Context, key string) error {
+	_, err := c.client.Delete(ctx, key)
+	return err
+}
+
+func (c *ClientKV) Lock(key string, ttl int64, callBack func() error) error {
+	if ttl <= 0 {
+		return Lock(c.client, key, callBack)
+	}
+	return LockTtl(c.client, key, ttl, callBack)
+}
@@ -2,17 +2,22 @@ package etcd
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"github.com/coreos/etcd/clientv3"
-	"os"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"time"
 )
 
 var (
-	CreateKvErr = errors.New("Failed to acquire lock")
+	CreateKvErr = dberr.ErrLockHeld //抢锁失败，与 dberr.ErrLockHeld 相同，保留旧名称兼容
 )
 
+/*
+释放锁（删除 key、撤销租约）时等待 etcd 的最长时间，etcd 不可用时解锁不会一直阻塞
+*/
+const unlockTimeout = time.Second * 5
+
 func Lock(client *clientv3.Client, lockKey string, callBack func() error) (err error) {
 	//mux.Lock()
 	//defer mux.Unlock()
@@ -38,7 +43,9 @@ func Lock(client *clientv3.Client, lockKey string, callBack func() error) (err e
 	}
 	if txnRes.Succeeded { //抢锁成功
 		defer func() {
-			kv.Delete(context.TODO(), lockKey)
+			ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+			defer cancel()
+			kv.Delete(ctx, lockKey)
 		}()
 		return callBack()
 	} else { //抢锁失败
@@ -141,12 +148,14 @@ func LockKeepAliveFunc(client *clientv3.Client, lockKey string, ttl int64, callB
 		//抢锁和占用期间，需要不停的续租，续租方法返回一个只读的channel
 		keepAlive, err := lease.KeepAlive(ctx, leaseID)
 		if err != nil {
+			cancel()
+			revoke(lease, leaseID)
 			return err
 		}
 		defer func() {
-			//两个defer用于释放锁
+			//停止续租后撤销租约释放锁，ctx 已经取消，撤销要用新的 ctx
 			cancel()
-			lease.Revoke(ctx, leaseID)
+			revoke(lease, leaseID)
 		}()
 		//续租
 		go func() {
@@ -154,10 +163,10 @@ func LockKeepAliveFunc(client *clientv3.Client, lockKey string, ttl int64, callB
 				select {
 				case leaseKeepAliveResponse := <-keepAlive:
 					if leaseKeepAliveResponse == nil {
-						fmt.Fprintf(os.Stderr, "lease fail \n")
+						logger.For(lockKey).Warn("lock lease keep alive failed", logger.F("lease", leaseID))
 						return
 					} else {
-						fmt.Fprintf(os.Stderr, "get leaseRes \n")
+						logger.For(lockKey).Debug("lock lease renewed", logger.F("lease", leaseID), logger.F("ttl", leaseKeepAliveResponse.TTL))
 					}
 				case <-ctx.Done():
 					return
@@ -209,12 +218,14 @@ func LockKeepAlive(client *clientv3.Client, lockKey string, ttl int64, callBack
 		//抢锁和占用期间，需要不停的续租，续租方法返回一个只读的channel
 		keepAlive, err := lease.KeepAlive(ctx, leaseID)
 		if err != nil {
+			cancel()
+			revoke(lease, leaseID)
 			return err
 		}
 		defer func() {
-			//两个defer用于释放锁
+			//停止续租后撤销租约释放锁，ctx 已经取消，撤销要用新的 ctx
 			cancel()
-			lease.Revoke(ctx, leaseID)
+			revoke(lease, leaseID)
 		}()
 		//续租
 		go func() {
@@ -222,10 +233,10 @@ func LockKeepAlive(client *clientv3.Client, lockKey string, ttl int64, callBack
 				select {
 				case leaseKeepAliveResponse := <-keepAlive:
 					if leaseKeepAliveResponse == nil {
-						fmt.Fprintf(os.Stderr, "lease fail \n")
+						logger.For(lockKey).Warn("lock lease keep alive failed", logger.F("lease", leaseID))
 						return
 					} else {
-						fmt.Fprintf(os.Stderr, "get leaseRes \n")
+						logger.For(lockKey).Debug("lock lease renewed", logger.F("lease", leaseID), logger.F("ttl", leaseKeepAliveResponse.TTL))
 					}
 				case <-ctx.Done():
 					return
@@ -240,3 +251,12 @@ func LockKeepAlive(client *clientv3.Client, lockKey string, ttl int64, callBack
 		return CreateKvErr
 	}
 }
+
+/*
+撤销租约释放锁，最多等待 unlockTimeout
+*/
+func revoke(lease clientv3.Lease, leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	lease.Revoke(ctx, leaseID)
+}
@@ -0,0 +1,142 @@
+package etcd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"testing"
+	"time"
+)
+
+func noop() error {
+	return nil
+}
+
+func TestLock(t *testing.T) {
+	env := cmanydbtest.New(t)
+	const key = "/cmanydbtest/lock"
+
+	var inner error
+	err := etcd.Lock(env.Etcd, key, func() error {
+		inner = etcd.Lock(env.Etcd, key, noop)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if !errors.Is(inner, dberr.ErrLockHeld) {
+		t.Fatalf("Lock while held = %v, want ErrLockHeld", inner)
+	}
+
+	//返回后锁已释放，回调的错误原样返回
+	want := errors.New("callback failed")
+	if err := etcd.Lock(env.Etcd, key, func() error { return want }); err != want {
+		t.Fatalf("Lock after release = %v, want %v", err, want)
+	}
+}
+
+func TestLockTtl(t *testing.T) {
+	env := cmanydbtest.New(t)
+	const key = "/cmanydbtest/lock-ttl"
+
+	if err := etcd.LockTtl(env.Etcd, key, 2, noop); err != nil {
+		t.Fatalf("LockTtl: %v", err)
+	}
+	//回调返回后锁保留到租约过期
+	if err := etcd.LockTtl(env.Etcd, key, 2, noop); !errors.Is(err, dberr.ErrLockHeld) {
+		t.Fatalf("LockTtl before expiry = %v, want ErrLockHeld", err)
+	}
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		err := etcd.LockTtl(env.Etcd, key, 2, noop)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, dberr.ErrLockHeld) || time.Now().After(deadline) {
+			t.Fatalf("LockTtl after expiry = %v", err)
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	env := cmanydbtest.New(t)
+	const key = "/cmanydbtest/lock-keepalive"
+
+	var inner error
+	err := etcd.LockKeepAlive(env.Etcd, key, 2, func() error {
+		//超过租约时间后仍然持有锁
+		time.Sleep(time.Second * 3)
+		inner = etcd.LockKeepAlive(env.Etcd, key, 2, noop)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("LockKeepAlive: %v", err)
+	}
+	if !errors.Is(inner, dberr.ErrLockHeld) {
+		t.Fatalf("LockKeepAlive while held = %v, want ErrLockHeld", inner)
+	}
+
+	//返回时撤销租约，锁立即释放
+	res, err := env.Etcd.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Fatalf("lock key still exists after LockKeepAlive returned")
+	}
+	want := errors.New("callback failed")
+	if err := etcd.LockKeepAlive(env.Etcd, key, 2, func() error { return want }); err != want {
+		t.Fatalf("LockKeepAlive after release = %v, want %v", err, want)
+	}
+}
+
+func TestLockTtlFunc(t *testing.T) {
+	env := cmanydbtest.New(t)
+	const key = "/cmanydbtest/lock-ttl-func"
+
+	called := 0
+	if err := etcd.LockTtlFunc(env.Etcd, key, 2, func() { called++ }); err != nil {
+		t.Fatalf("LockTtlFunc: %v", err)
+	}
+	//租约过期前抢锁失败，不执行回调
+	if err := etcd.LockTtlFunc(env.Etcd, key, 2, func() { called++ }); !errors.Is(err, dberr.ErrLockHeld) {
+		t.Fatalf("LockTtlFunc before expiry = %v, want ErrLockHeld", err)
+	}
+	if called != 1 {
+		t.Fatalf("callback called %d times, want 1", called)
+	}
+}
+
+func TestLockKeepAliveFunc(t *testing.T) {
+	env := cmanydbtest.New(t)
+	const key = "/cmanydbtest/lock-keepalive-func"
+
+	var inner error
+	err := etcd.LockKeepAliveFunc(env.Etcd, key, 2, func() {
+		//超过租约时间后仍然持有锁
+		time.Sleep(time.Second * 3)
+		inner = etcd.LockKeepAliveFunc(env.Etcd, key, 2, func() {})
+	})
+	if err != nil {
+		t.Fatalf("LockKeepAliveFunc: %v", err)
+	}
+	if !errors.Is(inner, dberr.ErrLockHeld) {
+		t.Fatalf("LockKeepAliveFunc while held = %v, want ErrLockHeld", inner)
+	}
+
+	//回调 panic 时返回错误，锁同样释放
+	err = etcd.LockKeepAliveFunc(env.Etcd, key, 2, func() { panic("callback failed") })
+	if err == nil || err.Error() != "callback failed" {
+		t.Fatalf("LockKeepAliveFunc with panic = %v, want callback failed", err)
+	}
+	res, err := env.Etcd.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Fatalf("lock key still exists after LockKeepAliveFunc returned")
+	}
+}
@@ -0,0 +1,53 @@
+package etcd
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+命名空间的环境变量，没有调用 Namespace 时读取
+CMANYDB_ENV 环境名，如 dev、staging、prod
+CMANYDB_SERVICE 服务名，如 order
+*/
+const (
+	EnvNamespace = "CMANYDB_ENV"
+	EnvService   = "CMANYDB_SERVICE"
+)
+
+/*
+公共配置所在的服务名，服务自己的目录下找不到时读取 /{env}/common/{name}
+*/
+const CommonService = "common"
+
+/*
+设置命名空间，覆盖 CMANYDB_ENV 和 CMANYDB_SERVICE 环境变量
+env 为空时不使用命名空间，key 按原样读取
+*/
+func (e *etcd) Namespace(env, service string) *etcd {
+	e.env = env
+	e.service = service
+	e.nsSet = true
+	return e
+}
+
+/*
+按顺序返回 name 要查找的 key，以 / 开头的 key 是完整路径，不加命名空间
+设置了 env 时依次为 /{env}/{service}/{name}、/{env}/common/{name}，没有 service 时只查找公共配置
+*/
+func (e *etcd) Keys(name string) []string {
+	env, service := e.env, e.service
+	if !e.nsSet {
+		env, service = os.Getenv(EnvNamespace), os.Getenv(EnvService)
+	}
+	env, service = strings.Trim(env, "/"), strings.Trim(service, "/")
+	if env == "" || strings.HasPrefix(name, "/") {
+		return []string{name}
+	}
+	var keys []string
+	if service != "" && service != CommonService {
+		keys = append(keys, "/"+path.Join(env, service, name))
+	}
+	return append(keys, "/"+path.Join(env, CommonService, name))
+}
@@ -0,0 +1,72 @@
+package etcd_test
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		env, service string
+		key          string
+		want         []string
+	}{
+		{"no namespace", "", "order", "mysql", []string{"mysql"}},
+		{"service then common", "prod", "order", "mysql", []string{"/prod/order/mysql", "/prod/common/mysql"}},
+		{"no service", "prod", "", "mysql", []string{"/prod/common/mysql"}},
+		{"common service", "prod", "common", "mysql", []string{"/prod/common/mysql"}},
+		{"slashes are trimmed", "/prod/", "/order/", "db/mysql", []string{"/prod/order/db/mysql", "/prod/common/db/mysql"}},
+		{"absolute key", "prod", "order", "/other/mysql", []string{"/other/mysql"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etcd.Conn().Namespace(tt.env, tt.service).Keys(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Keys(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysFromEnv(t *testing.T) {
+	t.Setenv(etcd.EnvNamespace, "staging")
+	t.Setenv(etcd.EnvService, "order")
+	want := []string{"/staging/order/mysql", "/staging/common/mysql"}
+	if got := etcd.Conn().Keys("mysql"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys = %q, want %q", got, want)
+	}
+	//Namespace 覆盖环境变量
+	if got := etcd.Conn().Namespace("", "").Keys("mysql"); !reflect.DeepEqual(got, []string{"mysql"}) {
+		t.Fatalf("Keys with empty Namespace = %q, want [mysql]", got)
+	}
+}
+
+func TestGetNamespace(t *testing.T) {
+	env := cmanydbtest.New(t)
+	if err := env.Put("/prod/common/mysql", map[string]interface{}{"host": "common"}); err != nil {
+		t.Fatal(err)
+	}
+	cli := etcd.Conn(env.Endpoints...).Namespace("prod", "order")
+
+	//服务目录下没有时读取公共配置
+	if got, err := cli.Get("mysql"); err != nil || string(got) != `{"host":"common"}` {
+		t.Fatalf("Get = %s, %v, want common config", got, err)
+	}
+	//服务目录下的配置优先，extends 可以引用公共配置
+	if err := env.Put("/prod/order/mysql", map[string]interface{}{"extends": "/prod/common/mysql", "port": 3306}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cli.Get("mysql"); err != nil || string(got) != `{"host":"common","port":3306}` {
+		t.Fatalf("Get = %s, %v, want merged service config", got, err)
+	}
+	//都不存在时错误中包含查找过的 key
+	_, err := cli.Get("redis")
+	if !errors.Is(err, dberr.ErrConfigNotFound) || !strings.Contains(err.Error(), "/prod/order/redis, /prod/common/redis") {
+		t.Fatalf("missing key = %v, want ErrConfigNotFound with both keys", err)
+	}
+}
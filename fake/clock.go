@@ -0,0 +1,43 @@
+package fake
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+带偏移量的时钟，Advance 让过期时间提前到达，测试中不需要真的等待
+*/
+type clock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+/*
+时间前进 d，之前设置的过期时间按前进后的时间判断
+*/
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.offset += d
+	c.mu.Unlock()
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+/*
+过期时间，为零值时不过期
+*/
+func (c *clock) deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+func (c *clock) expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !c.now().Before(deadline)
+}
@@ -0,0 +1,109 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+内存中的 etcd，实现 etcd.KV 和 etcd.Locker
+锁与真实 etcd 相同，占用时 key 存在，可以用 Get 看到
+*/
+type Etcd struct {
+	clock
+	mu   sync.Mutex
+	data map[string]etcdValue
+}
+
+type etcdValue struct {
+	value    string
+	deadline time.Time //租约到期时间，为零值时不过期
+}
+
+func NewEtcd() *Etcd {
+	return &Etcd{data: make(map[string]etcdValue)}
+}
+
+func (e *Etcd) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.lookup(key)
+	if !ok {
+		return nil, dberr.Wrap(dberr.ErrConfigNotFound, key, errors.New("key 对应的值为空"))
+	}
+	return []byte(v.value), nil
+}
+
+func (e *Etcd) Put(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.data[key] = etcdValue{value: value}
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Etcd) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	delete(e.data, key)
+	e.mu.Unlock()
+	return nil
+}
+
+/*
+与 etcd.Lock/LockTtl 相同：key 已存在时返回 dberr.ErrLockHeld
+ttl 小于等于 0 时回调结束后删除锁，大于 0 时锁在 ttl 秒后过期，可以用 Advance 快进
+*/
+func (e *Etcd) Lock(key string, ttl int64, callBack func() error) error {
+	e.mu.Lock()
+	if _, ok := e.lookup(key); ok {
+		e.mu.Unlock()
+		return dberr.ErrLockHeld
+	}
+	e.data[key] = etcdValue{deadline: e.deadline(time.Duration(ttl) * time.Second)}
+	e.mu.Unlock()
+	if ttl <= 0 {
+		defer e.Delete(context.Background(), key)
+	}
+	return callBack()
+}
+
+/*
+以 prefix 开头的所有 key，按字典序排列
+*/
+func (e *Etcd) Keys(prefix string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var keys []string
+	for key := range e.data {
+		if _, ok := e.lookup(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+调用方持有 e.mu，过期的 key 在读取时删除
+*/
+func (e *Etcd) lookup(key string) (etcdValue, bool) {
+	v, ok := e.data[key]
+	if ok && e.expired(v.deadline) {
+		delete(e.data, key)
+		return etcdValue{}, false
+	}
+	return v, ok
+}
@@ -0,0 +1,93 @@
+package fake
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+	"sync"
+	"time"
+)
+
+/*
+memcached 的 Expiration 超过 30 天时按 unix 时间戳处理
+*/
+const maxRelativeExpiration = 60 * 60 * 24 * 30
+
+/*
+内存中的 memcached，实现 memcached.Cache
+*/
+type Memcached struct {
+	clock
+	mu    sync.Mutex
+	items map[string]memcachedItem
+}
+
+type memcachedItem struct {
+	value    []byte
+	flags    uint32
+	deadline time.Time //过期时间，为零值时不过期
+}
+
+func NewMemcached() *Memcached {
+	return &Memcached{items: make(map[string]memcachedItem)}
+}
+
+/*
+未命中时返回 memcache.ErrCacheMiss，返回的 Item 是副本
+*/
+func (m *Memcached) Get(key string) (*memcache.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, ok := m.lookup(key)
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return &memcache.Item{Key: key, Value: append([]byte(nil), it.value...), Flags: it.flags}, nil
+}
+
+func (m *Memcached) Set(item *memcache.Item) error {
+	if len(item.Key) > 250 {
+		return memcache.ErrMalformedKey
+	}
+	m.mu.Lock()
+	m.items[item.Key] = memcachedItem{
+		value:    append([]byte(nil), item.Value...),
+		flags:    item.Flags,
+		deadline: m.itemDeadline(item.Expiration),
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+/*
+不存在时返回 memcache.ErrCacheMiss
+*/
+func (m *Memcached) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookup(key); !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(m.items, key)
+	return nil
+}
+
+func (m *Memcached) itemDeadline(expiration int32) time.Time {
+	switch {
+	case expiration <= 0:
+		return time.Time{}
+	case expiration > maxRelativeExpiration:
+		return time.Unix(int64(expiration), 0)
+	}
+	return m.deadline(time.Duration(expiration) * time.Second)
+}
+
+/*
+调用方持有 m.mu，过期的 key 在读取时删除
+*/
+func (m *Memcached) lookup(key string) (memcachedItem, bool) {
+	it, ok := m.items[key]
+	if ok && m.expired(it.deadline) {
+		delete(m.items, key)
+		return memcachedItem{}, false
+	}
+	return it, ok
+}
@@ -0,0 +1,264 @@
+package fake
+
+import (
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+内存中的 Redis，同时实现 redis.Cache（go-redis）和 redigo.Doer
+只支持字符串类型，需要完整命令集时用 cmanydbtest 中的 miniredis
+*/
+type Redis struct {
+	clock
+	mu   sync.Mutex
+	data map[string]redisValue
+}
+
+type redisValue struct {
+	value    string
+	deadline time.Time //过期时间，为零值时不过期
+}
+
+func NewRedis() *Redis {
+	return &Redis{data: make(map[string]redisValue)}
+}
+
+func (r *Redis) Get(key string) *goredis.StringCmd {
+	value, ok := r.get(key)
+	if !ok {
+		return goredis.NewStringResult("", goredis.Nil)
+	}
+	return goredis.NewStringResult(value, nil)
+}
+
+func (r *Redis) Set(key string, value interface{}, expiration time.Duration) *goredis.StatusCmd {
+	r.set(key, toString(value), expiration)
+	return goredis.NewStatusResult("OK", nil)
+}
+
+func (r *Redis) Del(keys ...string) *goredis.IntCmd {
+	return goredis.NewIntResult(r.del(keys...), nil)
+}
+
+/*
+redigo 的命令执行，支持 GET、SET（EX、PX、NX、XX）、DEL、EXISTS、EXPIRE、TTL、INCR、INCRBY
+回复的类型与 redigo 相同：字符串为 []byte，状态为 string，整数为 int64，不存在为 nil
+*/
+func (r *Redis) Do(commandName string, args ...interface{}) (interface{}, error) {
+	cmd := strings.ToUpper(commandName)
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = toString(arg)
+	}
+	switch cmd {
+	case "GET":
+		if len(keys) != 1 {
+			return nil, wrongArgs(cmd)
+		}
+		if value, ok := r.get(keys[0]); ok {
+			return []byte(value), nil
+		}
+		return nil, nil
+	case "SET":
+		return r.doSet(keys)
+	case "DEL":
+		if len(keys) == 0 {
+			return nil, wrongArgs(cmd)
+		}
+		return r.del(keys...), nil
+	case "EXISTS":
+		if len(keys) == 0 {
+			return nil, wrongArgs(cmd)
+		}
+		var n int64
+		for _, key := range keys {
+			if _, ok := r.get(key); ok {
+				n++
+			}
+		}
+		return n, nil
+	case "EXPIRE":
+		if len(keys) != 2 {
+			return nil, wrongArgs(cmd)
+		}
+		secs, err := strconv.ParseInt(keys[1], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		return r.expire(keys[0], time.Duration(secs)*time.Second), nil
+	case "TTL":
+		if len(keys) != 1 {
+			return nil, wrongArgs(cmd)
+		}
+		return r.ttl(keys[0]), nil
+	case "INCR", "INCRBY":
+		by := int64(1)
+		switch {
+		case cmd == "INCR" && len(keys) == 1:
+		case cmd == "INCRBY" && len(keys) == 2:
+			n, err := strconv.ParseInt(keys[1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			by = n
+		default:
+			return nil, wrongArgs(cmd)
+		}
+		return r.incr(keys[0], by)
+	}
+	return nil, fmt.Errorf("ERR unknown command '%s'", commandName)
+}
+
+func (r *Redis) doSet(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, wrongArgs("SET")
+	}
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return nil, errors.New("ERR syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errors.New("ERR invalid expire time in set")
+			}
+			if opt == "EX" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+		default:
+			return nil, errors.New("ERR syntax error")
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, exists := r.lookup(args[0])
+	if (nx && exists) || (xx && !exists) {
+		return nil, nil
+	}
+	r.data[args[0]] = redisValue{value: args[1], deadline: r.deadline(ttl)}
+	return "OK", nil
+}
+
+func (r *Redis) get(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.lookup(key)
+	return v.value, ok
+}
+
+func (r *Redis) set(key, value string, ttl time.Duration) {
+	r.mu.Lock()
+	r.data[key] = redisValue{value: value, deadline: r.deadline(ttl)}
+	r.mu.Unlock()
+}
+
+func (r *Redis) del(keys ...string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if _, ok := r.lookup(key); ok {
+			delete(r.data, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Redis) expire(key string, ttl time.Duration) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.lookup(key)
+	if !ok {
+		return 0
+	}
+	if ttl <= 0 {
+		delete(r.data, key)
+		return 1
+	}
+	v.deadline = r.deadline(ttl)
+	r.data[key] = v
+	return 1
+}
+
+/*
+与 Redis 相同：不存在返回 -2，不过期返回 -1
+*/
+func (r *Redis) ttl(key string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.lookup(key)
+	switch {
+	case !ok:
+		return -2
+	case v.deadline.IsZero():
+		return -1
+	}
+	return int64(v.deadline.Sub(r.now()).Round(time.Second) / time.Second)
+}
+
+func (r *Redis) incr(key string, by int64) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, _ := r.lookup(key)
+	var n int64
+	if v.value != "" {
+		var err error
+		if n, err = strconv.ParseInt(v.value, 10, 64); err != nil {
+			return nil, errNotInteger
+		}
+	}
+	n += by
+	v.value = strconv.FormatInt(n, 10)
+	r.data[key] = v
+	return n, nil
+}
+
+/*
+调用方持有 r.mu，过期的 key 在读取时删除
+*/
+func (r *Redis) lookup(key string) (redisValue, bool) {
+	v, ok := r.data[key]
+	if ok && r.expired(v.deadline) {
+		delete(r.data, key)
+		return redisValue{}, false
+	}
+	return v, ok
+}
+
+var errNotInteger = errors.New("ERR value is not an integer or out of range")
+
+func wrongArgs(cmd string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+/*
+与 redis 客户端写入参数的方式相同，[]byte 原样写入，其他类型按 fmt 格式化
+*/
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,306 @@
+package fake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+/*
+内存中的 SQL 数据库，NewSQL 返回的 *sql.DB 满足 mysql.Querier，也可以传给 mysql.QueryStructs、WithTx 等
+不解析 SQL：用 OnQuery、OnExec、OnError 按语句设置返回值，执行过的语句用 Statements 查看
+语句按去掉多余空白后的全文匹配，同一语句设置多次时后设置的生效，没有设置的语句返回错误
+*/
+type SQL struct {
+	mu         sync.Mutex
+	rules      []sqlRule
+	statements []Statement
+}
+
+/*
+执行过的语句，事务的开始、提交、回滚记录为 BEGIN、COMMIT、ROLLBACK
+*/
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+type sqlRule struct {
+	query   string
+	exec    bool
+	columns []string
+	rows    [][]driver.Value
+	result  driver.Result
+	err     error
+}
+
+func NewSQL() (*sql.DB, *SQL) {
+	s := new(SQL)
+	return sql.OpenDB(s), s
+}
+
+/*
+设置查询的返回值，rows 中每行的值与 columns 一一对应
+*/
+func (s *SQL) OnQuery(query string, columns []string, rows ...[]interface{}) {
+	rule := sqlRule{query: normalize(query), columns: columns}
+	rule.rows, rule.err = convertRows(columns, rows)
+	s.addRule(rule)
+}
+
+/*
+设置写语句的返回值
+*/
+func (s *SQL) OnExec(query string, lastInsertID, rowsAffected int64) {
+	s.addRule(sqlRule{query: normalize(query), exec: true, result: sqlResult{lastInsertID, rowsAffected}})
+}
+
+/*
+语句返回 err，查询和写语句都适用
+*/
+func (s *SQL) OnError(query string, err error) {
+	s.addRule(sqlRule{query: normalize(query), err: err})
+}
+
+/*
+按执行顺序返回执行过的语句
+*/
+func (s *SQL) Statements() []Statement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Statement(nil), s.statements...)
+}
+
+/*
+清除设置的返回值和执行记录
+*/
+func (s *SQL) Reset() {
+	s.mu.Lock()
+	s.rules = nil
+	s.statements = nil
+	s.mu.Unlock()
+}
+
+func (s *SQL) addRule(rule sqlRule) {
+	s.mu.Lock()
+	s.rules = append(s.rules, rule)
+	s.mu.Unlock()
+}
+
+/*
+记录语句并查找设置的返回值
+*/
+func (s *SQL) match(query string, args []driver.NamedValue, exec bool) (sqlRule, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements = append(s.statements, Statement{Query: query, Args: values})
+	query = normalize(query)
+	for i := len(s.rules) - 1; i >= 0; i-- {
+		rule := s.rules[i]
+		if rule.query != query {
+			continue
+		}
+		if rule.err != nil {
+			return rule, rule.err
+		}
+		if rule.exec != exec {
+			if exec {
+				return rule, fmt.Errorf("fake: %q is set by OnQuery, not OnExec", query)
+			}
+			return rule, fmt.Errorf("fake: %q is set by OnExec, not OnQuery", query)
+		}
+		return rule, nil
+	}
+	return sqlRule{}, fmt.Errorf("fake: unexpected statement %q", query)
+}
+
+func (s *SQL) record(query string) {
+	s.mu.Lock()
+	s.statements = append(s.statements, Statement{Query: query})
+	s.mu.Unlock()
+}
+
+/*
+driver.Connector，每次都返回新的连接，所有连接共享设置
+*/
+func (s *SQL) Connect(ctx context.Context) (driver.Conn, error) {
+	return &sqlConn{s: s}, nil
+}
+
+func (s *SQL) Driver() driver.Driver {
+	return sqlDriver{s}
+}
+
+type sqlDriver struct {
+	s *SQL
+}
+
+func (d sqlDriver) Open(name string) (driver.Conn, error) {
+	return &sqlConn{s: d.s}, nil
+}
+
+type sqlConn struct {
+	s *SQL
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlStmt{conn: c, query: query}, nil
+}
+
+func (c *sqlConn) Close() error {
+	return nil
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.s.record("BEGIN")
+	return sqlTx{c.s}, nil
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rule, err := c.s.match(query, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRows{columns: rule.columns, rows: rule.rows}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rule, err := c.s.match(query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return rule.result, nil
+}
+
+type sqlStmt struct {
+	conn  *sqlConn
+	query string
+}
+
+func (s *sqlStmt) Close() error {
+	return nil
+}
+
+/*
+不检查参数个数
+*/
+func (s *sqlStmt) NumInput() int {
+	return -1
+}
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type sqlTx struct {
+	s *SQL
+}
+
+func (t sqlTx) Commit() error {
+	t.s.record("COMMIT")
+	return nil
+}
+
+func (t sqlTx) Rollback() error {
+	t.s.record("ROLLBACK")
+	return nil
+}
+
+type sqlRows struct {
+	columns []string
+	rows    [][]driver.Value
+	pos     int
+}
+
+func (r *sqlRows) Columns() []string {
+	return r.columns
+}
+
+func (r *sqlRows) Close() error {
+	return nil
+}
+
+func (r *sqlRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+type sqlResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r sqlResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r sqlResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+func convertRows(columns []string, rows [][]interface{}) ([][]driver.Value, error) {
+	out := make([][]driver.Value, len(rows))
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("fake: row %d has %d values, want %d", i, len(row), len(columns))
+		}
+		out[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			value, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("fake: row %d column %s: %w", i, columns[j], err)
+			}
+			out[i][j] = value
+		}
+	}
+	return out, nil
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+/*
+合并连续空白并去掉首尾空白和结尾的分号，多行书写的语句也能匹配
+*/
+func normalize(query string) string {
+	return strings.TrimRight(strings.Join(strings.Fields(query), " "), ";")
+}
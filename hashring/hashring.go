@@ -0,0 +1,118 @@
+package hashring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultReplicas = 160
+
+/*
+一致性哈希环，每个节点放 replicas 个虚拟节点，增删节点时只有相邻区间的 key 会迁移
+*/
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int
+	hashes   []uint32
+	nodes    map[uint32]string
+	members  map[string]bool
+}
+
+/*
+replicas 每个节点的虚拟节点数，小于等于 0 时为 160
+*/
+func New(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	r := &Ring{
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+		members:  make(map[string]bool),
+	}
+	r.Add(nodes...)
+	return r
+}
+
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if r.members[node] {
+			continue
+		}
+		r.members[node] = true
+		for i := 0; i < r.replicas; i++ {
+			h := Hash(strconv.Itoa(i) + "#" + node)
+			//哈希冲突时保留先加入的节点
+			if _, ok := r.nodes[h]; ok {
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *Ring) Remove(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		delete(r.members, node)
+	}
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.members[r.nodes[h]] {
+			hashes = append(hashes, h)
+		} else {
+			delete(r.nodes, h)
+		}
+	}
+	r.hashes = hashes
+}
+
+/*
+key 所在的节点，环为空时返回空字符串
+*/
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := Hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+/*
+所有节点，顺序不固定
+*/
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]string, 0, len(r.members))
+	for node := range r.members {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.members)
+}
+
+/*
+环上使用的哈希函数，取模分片也用它计算字符串 key
+*/
+func Hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
@@ -0,0 +1,85 @@
+package hashring_test
+
+import (
+	"github.com/chu108/cmany_db/hashring"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+	}{
+		{"empty", nil},
+		{"one node", []string{"a"}},
+		{"three nodes", []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := hashring.New(0, tt.nodes...)
+			counts := make(map[string]int)
+			for i := 0; i < 3000; i++ {
+				key := strconv.Itoa(i)
+				node := r.Get(key)
+				if node != r.Get(key) {
+					t.Fatalf("Get(%q) is not stable", key)
+				}
+				counts[node]++
+			}
+			if len(tt.nodes) == 0 {
+				if counts[""] != 3000 {
+					t.Fatalf("empty ring returned nodes: %v", counts)
+				}
+				return
+			}
+			//每个节点都分到 key，且不会过于集中
+			for _, node := range tt.nodes {
+				if counts[node] < 3000/len(tt.nodes)/2 {
+					t.Fatalf("node %s got %d of 3000 keys: %v", node, counts[node], counts)
+				}
+			}
+		})
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	r := hashring.New(0, "a", "b", "c")
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		before[key] = r.Get(key)
+	}
+
+	//新增节点时只有迁移到新节点的 key 改变位置
+	r.Add("d", "a")
+	if r.Len() != 4 {
+		t.Fatalf("Len = %d, want 4", r.Len())
+	}
+	moved := 0
+	for key, node := range before {
+		if got := r.Get(key); got != node {
+			if got != "d" {
+				t.Fatalf("key %s moved from %s to %s, want d", key, node, got)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("%d of 1000 keys moved to the new node", moved)
+	}
+
+	//删除后恢复原来的分布
+	r.Remove("d")
+	for key, node := range before {
+		if got := r.Get(key); got != node {
+			t.Fatalf("key %s = %s after Remove, want %s", key, got, node)
+		}
+	}
+	nodes := r.Nodes()
+	sort.Strings(nodes)
+	if len(nodes) != 3 || nodes[0] != "a" || nodes[1] != "b" || nodes[2] != "c" {
+		t.Fatalf("Nodes = %v, want [a b c]", nodes)
+	}
+}
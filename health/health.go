@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chu108/cmany_db/breaker"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+健康检查函数，返回 nil 表示健康
+*/
+type Checker func(ctx context.Context) error
+
+type Status struct {
+	Name    string        `json:"name"`
+	Backend string        `json:"backend"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Breaker string        `json:"breaker,omitempty"` //熔断器状态，没有配置熔断时为空
+}
+
+type check struct {
+	backend string
+	fn      Checker
+}
+
+var (
+	mu     sync.RWMutex
+	checks = make(map[string]check)
+)
+
+/*
+注册健康检查，本库创建的连接会自动注册，实例名称通常是 etcd 中的 key
+*/
+func Register(backend, name string, fn Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = check{backend: backend, fn: fn}
+}
+
+/*
+取消注册，连接关闭后调用
+*/
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(checks, name)
+}
+
+/*
+并发执行所有健康检查，按名称排序返回
+熔断器打开时直接判定为不健康，不再访问数据库
+*/
+func Check(ctx context.Context) []Status {
+	mu.RLock()
+	all := make(map[string]check, len(checks))
+	for name, c := range checks {
+		all[name] = c
+	}
+	mu.RUnlock()
+
+	var wg sync.WaitGroup
+	statuses := make([]Status, 0, len(all))
+	var smu sync.Mutex
+	for name, c := range all {
+		wg.Add(1)
+		go func(name string, c check) {
+			defer wg.Done()
+			s := run(ctx, name, c)
+			smu.Lock()
+			statuses = append(statuses, s)
+			smu.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func run(ctx context.Context, name string, c check) Status {
+	s := Status{Name: name, Backend: c.backend}
+	if b := breaker.Get(name); b != nil {
+		s.Breaker = b.State().String()
+		if b.State() == breaker.StateOpen {
+			s.Error = breaker.ErrOpen.Error()
+			return s
+		}
+	}
+	start := time.Now()
+	err := c.fn(ctx)
+	s.Latency = time.Since(start)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.Healthy = true
+	return s
+}
+
+/*
+HTTP 健康检查接口，全部健康返回 200，否则返回 503，内容为 JSON
+*/
+func Handler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		statuses := Check(ctx)
+		code := http.StatusOK
+		for _, s := range statuses {
+			if !s.Healthy {
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+/*
+结构化字段
+*/
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+/*
+日志接口，适配 slog、zap 等日志库只需实现 Log
+传入的字段已经脱敏
+*/
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+var (
+	mu        sync.RWMutex
+	std       Logger = NewText(os.Stderr)
+	minLevel         = LevelInfo
+	instances        = make(map[string]Logger)
+)
+
+/*
+设置全局日志，nil 表示丢弃所有日志
+*/
+func SetDefault(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l == nil {
+		l = Discard
+	}
+	std = l
+}
+
+/*
+为某个实例单独设置日志，实例名称通常是 etcd 中的 key
+l 为 nil 时恢复使用全局日志
+*/
+func SetInstance(name string, l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l == nil {
+		delete(instances, name)
+		return
+	}
+	instances[name] = l
+}
+
+/*
+设置最低输出级别，默认 LevelInfo
+*/
+func SetLevel(level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+}
+
+/*
+获取实例的日志，没有单独设置时使用全局日志
+*/
+func For(name string) *Entry {
+	mu.RLock()
+	defer mu.RUnlock()
+	l, ok := instances[name]
+	if !ok {
+		l = std
+	}
+	e := &Entry{l: l, level: minLevel}
+	if name != "" {
+		e.fields = []Field{F("instance", name)}
+	}
+	return e
+}
+
+/*
+带固定字段的日志，输出前按级别过滤并脱敏
+*/
+type Entry struct {
+	l      Logger
+	level  Level
+	fields []Field
+}
+
+/*
+使用指定的 Logger，l 为 nil 时不变
+*/
+func (e *Entry) Use(l Logger) *Entry {
+	if l == nil {
+		return e
+	}
+	return &Entry{l: l, level: e.level, fields: e.fields}
+}
+
+func (e *Entry) With(fields ...Field) *Entry {
+	all := make([]Field, 0, len(e.fields)+len(fields))
+	all = append(append(all, e.fields...), fields...)
+	return &Entry{l: e.l, level: e.level, fields: all}
+}
+
+func (e *Entry) Enabled(level Level) bool {
+	return level >= e.level
+}
+
+func (e *Entry) Log(level Level, msg string, fields ...Field) {
+	if !e.Enabled(level) {
+		return
+	}
+	all := make([]Field, 0, len(e.fields)+len(fields))
+	for _, f := range append(append(all, e.fields...), fields...) {
+		all = append(all, Redact(f))
+	}
+	e.l.Log(level, msg, all...)
+}
+
+func (e *Entry) Debug(msg string, fields ...Field) { e.Log(LevelDebug, msg, fields...) }
+func (e *Entry) Info(msg string, fields ...Field)  { e.Log(LevelInfo, msg, fields...) }
+func (e *Entry) Warn(msg string, fields ...Field)  { e.Log(LevelWarn, msg, fields...) }
+func (e *Entry) Error(msg string, fields ...Field) { e.Log(LevelError, msg, fields...) }
+
+type discard struct{}
+
+func (discard) Log(Level, string, ...Field) {}
+
+/*
+丢弃所有日志
+*/
+var Discard Logger = discard{}
+
+/*
+文本日志，格式：时间 级别 消息 key=value...
+*/
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewText(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+func (t *textLogger) Log(level Level, msg string, fields ...Field) {
+	var b strings.Builder
+	b.WriteString(time.Now().Format("2006-01-02 15:04:05.000"))
+	b.WriteByte(' ')
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	b.WriteByte('\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, b.String())
+}
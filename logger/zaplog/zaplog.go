@@ -0,0 +1,34 @@
+package zaplog
+
+import (
+	"github.com/chu108/cmany_db/logger"
+	"go.uber.org/zap"
+)
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+/*
+适配 zap，单独成包，避免不用 zap 的项目引入依赖
+*/
+func New(l *zap.Logger) logger.Logger {
+	return &zapLogger{l: l}
+}
+
+func (z *zapLogger) Log(level logger.Level, msg string, fields ...logger.Field) {
+	zf := make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		zf = append(zf, zap.Any(f.Key, f.Value))
+	}
+	switch level {
+	case logger.LevelDebug:
+		z.l.Debug(msg, zf...)
+	case logger.LevelWarn:
+		z.l.Warn(msg, zf...)
+	case logger.LevelError:
+		z.l.Error(msg, zf...)
+	default:
+		z.l.Info(msg, zf...)
+	}
+}
@@ -0,0 +1,15 @@
+package memcached
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+/*
+应用代码用到的缓存读写，*memcache.Client 满足
+单元测试中可以用 fake.NewMemcached() 代替，未命中时返回 memcache.ErrCacheMiss
+*/
+type Cache interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Delete(key string) error
+}
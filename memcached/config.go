@@ -0,0 +1,74 @@
+package memcached
+
+import (
+	"github.com/chu108/cmany_db/config"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout      = time.Millisecond * 500
+	defaultMaxIdleConns = 10
+)
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+timeout 默认 500ms，max_idle_conns 默认 10
+*/
+func (c *dbConn) SetDefaults() {
+	if c.Timeout == 0 {
+		c.Timeout = config.Duration(defaultTimeout)
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	if len(c.Servers) == 0 {
+		p.Add("servers", "required")
+	}
+	seen := make(map[string]bool, len(c.Servers))
+	for i, server := range c.Servers {
+		sp := p.Sub("servers").Index(i)
+		switch {
+		case server == "":
+			sp.Add("", "must not be empty")
+		case seen[server]:
+			sp.Addf("", "duplicate server %s", server)
+		case !strings.Contains(server, "/"):
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				sp.Add("", err.Error())
+			}
+		}
+		seen[server] = true
+	}
+	if c.Replicas < 0 {
+		p.Addf("replicas", "must not be negative, got %d", c.Replicas)
+	}
+	p.NonNegative("timeout", c.Timeout)
+	p.NonNegative("connect_timeout", c.ConnectTimeout)
+	if c.MaxIdleConns < 0 {
+		p.Addf("max_idle_conns", "must not be negative, got %d", c.MaxIdleConns)
+	}
+	p.Check("retry", c.Retry)
+}
+
+func (c *dbConn) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout.Std()
+	}
+	if c.Timeout > 0 {
+		return c.Timeout.Std()
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,183 @@
+package memcached
+
+import (
+	"context"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backend = "memcached"
+
+/*
+conn 返回的客户端对应的实例名称，Close 时按名称取消注册健康检查
+*/
+var instances sync.Map
+
+/*
+{"servers":["10.0.0.1:11211","10.0.0.2:11211"],"timeout":"100ms","max_idle_conns":10}
+*/
+type dbConn struct {
+	Servers        []string        `json:"servers"`         //节点列表，按一致性哈希分配 key，含 / 时为 unix socket
+	Replicas       int             `json:"replicas"`        //每个节点的虚拟节点数，默认 160
+	Timeout        config.Duration `json:"timeout"`         //读写超时，默认 500ms
+	ConnectTimeout config.Duration `json:"connect_timeout"` //建立连接的超时时间，为 0 时与 timeout 相同
+	MaxIdleConns   int             `json:"max_idle_conns"`  //每个节点的最大空闲连接数，默认 10
+	Retry          *retry.Policy   `json:"retry"`           //启动时检查节点的重试策略，为空时使用全局默认策略
+	Lazy           bool            `json:"lazy"`            //延迟连接，创建时不检查节点
+}
+
+/*
+通过ETCD方式连接数据库
+dbKey etcd存储的数据库连接字符串的key
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcd(dbKey string, endpoints ...string) (*memcache.Client, error) {
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时检查节点的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*memcache.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ETCD 授权方式连接数据库
+dbKey etcd存储的数据库连接字符串的key
+etcdName etcd用户名
+etcdPass etcd密码
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*memcache.Client, error) {
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时检查节点的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*memcache.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ENV 变量方式连接数据库
+env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
+dbKey etcd存储的数据库连接字符串的key
+*/
+func ConnByEnv(env, dbKey string) (*memcache.Client, error) {
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时检查节点的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*memcache.Client, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+以字符串的方式连接数据库
+servers 节点地址，如 127.0.0.1:11211
+*/
+func ConnByStr(servers ...string) (*memcache.Client, error) {
+	return ConnByStrCtx(context.Background(), servers...)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时检查节点的时间
+*/
+func ConnByStrCtx(ctx context.Context, servers ...string) (*memcache.Client, error) {
+	cfg := new(dbConn)
+	cfg.Servers = servers
+	cfg.SetDefaults()
+	return conn(ctx, strings.Join(servers, ","), cfg)
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (*memcache.Client, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (*memcache.Client, error) {
+	return connByConnByte(ctx, name, data)
+}
+
+func connByConnByte(ctx context.Context, name string, connByte []byte) (*memcache.Client, error) {
+	cfg := new(dbConn)
+	if err := config.Decode(name, connByte, cfg); err != nil {
+		return nil, err
+	}
+	return conn(ctx, name, cfg)
+}
+
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (*memcache.Client, error) {
+	selector, err := newRingSelector(cfg.Replicas, cfg.Servers...)
+	if err != nil {
+		return nil, dberr.Connect(name, err)
+	}
+	client := memcache.NewFromSelector(selector)
+	client.Timeout = cfg.Timeout.Std()
+	client.MaxIdleConns = cfg.MaxIdleConns
+	dialer := &net.Dialer{Timeout: cfg.connectTimeout()}
+	client.DialContext = dialer.DialContext
+
+	//gomemcache 的 Ping 不支持 ctx，超时由 timeout 控制
+	ping := func(ctx context.Context) error {
+		start := time.Now()
+		err := client.Ping()
+		metrics.Observe(backend, name, "PING", start, err)
+		return err
+	}
+	if !cfg.Lazy {
+		if err := retry.Do(ctx, name, cfg.Retry, ping); err != nil {
+			metrics.ConnectError(backend, name)
+			client.Close()
+			return nil, dberr.Connect(name, err)
+		}
+	}
+	health.Register(backend, name, ping)
+	instances.Store(client, name)
+	return client, nil
+}
+
+/*
+关闭 conn 返回的客户端的空闲连接，同时取消注册健康检查
+*/
+func Close(client *memcache.Client) error {
+	if name, ok := instances.LoadAndDelete(client); ok {
+		health.Unregister(name.(string))
+	}
+	return client.Close()
+}
@@ -0,0 +1,73 @@
+package memcached_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/memcached"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/memcached"
+
+/*
+通过客户端写入后在服务端读到，再通过客户端读回，然后关闭客户端
+*/
+func set(server *cmanydbtest.Memcached, client *memcache.Client, err error) error {
+	if err != nil {
+		return err
+	}
+	defer memcached.Close(client)
+	if client.MaxIdleConns != 10 {
+		return fmt.Errorf("max idle conns = %d, want default 10", client.MaxIdleConns)
+	}
+	if err := client.Set(&memcache.Item{Key: "k", Value: []byte("v")}); err != nil {
+		return err
+	}
+	if item, err := server.Get("k"); err != nil || !bytes.Equal(item.Value, []byte("v")) {
+		return fmt.Errorf("server k = %v, %v", item, err)
+	}
+	if item, err := client.Get("k"); err != nil || !bytes.Equal(item.Value, []byte("v")) {
+		return fmt.Errorf("client k = %v, %v", item, err)
+	}
+	return server.Delete("k")
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	server := cmanydbtest.NewMemcached(t)
+	if err := env.Put(dbKey, map[string]interface{}{"servers": []string{server.Addr()}, "timeout": "300ms"}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			client, err := memcached.ConnByEtcd(key, endpoints...)
+			return set(server, client, err)
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			client, err := memcached.ConnByEtcdAuth(key, user, password, endpoints...)
+			return set(server, client, err)
+		},
+		Env: func(env, key string) error {
+			client, err := memcached.ConnByEnv(env, key)
+			return set(server, client, err)
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	server := cmanydbtest.NewMemcached(t)
+	client, err := memcached.ConnByStr(server.Addr())
+	if err := set(server, client, err); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = memcached.ConnByStr(cmanydbtest.ClosedAddr(t))
+	if !errors.Is(err, dberr.ErrUnreachable) {
+		t.Fatalf("closed port = %v, want ErrUnreachable", err)
+	}
+}
@@ -0,0 +1,15 @@
+package memcached
+
+import (
+	"errors"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/chu108/cmany_db/dberr"
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		NotFound: func(err error) bool {
+			return errors.Is(err, memcache.ErrCacheMiss)
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package memcached
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/chu108/cmany_db/hashring"
+	"net"
+	"strings"
+)
+
+/*
+一致性哈希的 ServerSelector，增删节点时只有相邻区间的 key 换节点，gomemcache 自带的 ServerList 是取模
+*/
+type ringSelector struct {
+	ring  *hashring.Ring
+	addrs map[string]net.Addr
+}
+
+/*
+servers 地址列表，含 / 时为 unix socket
+*/
+func newRingSelector(replicas int, servers ...string) (*ringSelector, error) {
+	s := &ringSelector{ring: hashring.New(replicas), addrs: make(map[string]net.Addr, len(servers))}
+	for _, server := range servers {
+		addr, err := resolve(server)
+		if err != nil {
+			return nil, err
+		}
+		s.addrs[server] = addr
+		s.ring.Add(server)
+	}
+	return s, nil
+}
+
+func resolve(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		return net.ResolveUnixAddr("unix", server)
+	}
+	return net.ResolveTCPAddr("tcp", server)
+}
+
+func (s *ringSelector) PickServer(key string) (net.Addr, error) {
+	server := s.ring.Get(key)
+	if server == "" {
+		return nil, memcache.ErrNoServers
+	}
+	return s.addrs[server], nil
+}
+
+func (s *ringSelector) Each(f func(net.Addr) error) error {
+	for _, addr := range s.addrs {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
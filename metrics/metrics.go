@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "cmany_db"
+
+var (
+	//命令耗时
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Latency of commands executed through cmany_db connections.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"backend", "instance", "command"})
+
+	//命令错误数
+	commandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_errors_total",
+		Help:      "Number of failed commands executed through cmany_db connections.",
+	}, []string{"backend", "instance", "command"})
+
+	//连接错误数
+	connectErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connect_errors_total",
+		Help:      "Number of failed attempts to create a cmany_db connection.",
+	}, []string{"backend", "instance"})
+
+	//熔断器状态
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, []string{"instance"})
+)
+
+func init() {
+	prometheus.MustRegister(commandDuration, commandErrors, connectErrors, breakerState, pools)
+}
+
+/*
+HTTP 暴露指标，等价于 promhttp.Handler()
+*/
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+/*
+记录一次命令的耗时和结果
+backend 数据库类型，如 mysql、redis
+instance 实例名称，通常是 etcd 中的 key
+command 命令名称，如 SELECT、GET
+*/
+func Observe(backend, instance, command string, start time.Time, err error) {
+	commandDuration.WithLabelValues(backend, instance, command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		commandErrors.WithLabelValues(backend, instance, command).Inc()
+	}
+}
+
+/*
+记录一次连接失败
+*/
+func ConnectError(backend, instance string) {
+	connectErrors.WithLabelValues(backend, instance).Inc()
+}
+
+/*
+记录熔断器状态，0 关闭，1 打开，2 半开
+*/
+func SetBreakerState(instance string, state int) {
+	breakerState.WithLabelValues(instance).Set(float64(state))
+}
+
+/*
+删除熔断器状态，熔断器取消注册后调用
+*/
+func DeleteBreakerState(instance string) {
+	breakerState.DeleteLabelValues(instance)
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+/*
+连接池统计，各数据库包负责把驱动自己的统计转换成该结构
+*/
+type PoolStats struct {
+	Open         int           //已打开的连接数
+	Idle         int           //空闲连接数
+	InUse        int           //使用中的连接数
+	WaitCount    int64         //等待连接的总次数
+	WaitDuration time.Duration //等待连接的总耗时
+}
+
+type poolKey struct {
+	backend  string
+	instance string
+}
+
+type poolCollector struct {
+	mu    sync.RWMutex
+	stats map[poolKey]func() PoolStats
+
+	open         *prometheus.Desc
+	idle         *prometheus.Desc
+	inUse        *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+var pools = newPoolCollector()
+
+func newPoolCollector() *poolCollector {
+	labels := []string{"backend", "instance"}
+	return &poolCollector{
+		stats:        make(map[poolKey]func() PoolStats),
+		open:         prometheus.NewDesc(namespace+"_pool_open_connections", "Number of open connections in the pool.", labels, nil),
+		idle:         prometheus.NewDesc(namespace+"_pool_idle_connections", "Number of idle connections in the pool.", labels, nil),
+		inUse:        prometheus.NewDesc(namespace+"_pool_in_use_connections", "Number of connections currently in use.", labels, nil),
+		waitCount:    prometheus.NewDesc(namespace+"_pool_wait_count_total", "Total number of times a connection was waited for.", labels, nil),
+		waitDuration: prometheus.NewDesc(namespace+"_pool_wait_duration_seconds_total", "Total time spent waiting for a connection.", labels, nil),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.open
+	ch <- p.idle
+	ch <- p.inUse
+	ch <- p.waitCount
+	ch <- p.waitDuration
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for key, fn := range p.stats {
+		s := fn()
+		ch <- prometheus.MustNewConstMetric(p.open, prometheus.GaugeValue, float64(s.Open), key.backend, key.instance)
+		ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(s.Idle), key.backend, key.instance)
+		ch <- prometheus.MustNewConstMetric(p.inUse, prometheus.GaugeValue, float64(s.InUse), key.backend, key.instance)
+		ch <- prometheus.MustNewConstMetric(p.waitCount, prometheus.CounterValue, float64(s.WaitCount), key.backend, key.instance)
+		ch <- prometheus.MustNewConstMetric(p.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), key.backend, key.instance)
+	}
+}
+
+/*
+注册连接池统计，同名实例重复注册时覆盖旧的统计函数
+*/
+func RegisterPool(backend, instance string, stats func() PoolStats) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	pools.stats[poolKey{backend, instance}] = stats
+}
+
+/*
+取消注册连接池统计，连接关闭后调用
+*/
+func UnregisterPool(backend, instance string) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	delete(pools.stats, poolKey{backend, instance})
+}
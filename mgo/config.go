@@ -0,0 +1,37 @@
+package mgo
+
+import (
+	"github.com/chu108/cmany_db/config"
+)
+
+/*
+与 mgo 自身的默认值相同，配置为 0 时 mgo 不限制连接数
+*/
+const defaultPoolLimit = 4096
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+PoolLimit 默认 4096
+*/
+func (c *dbConn) SetDefaults() {
+	if c.PoolLimit == 0 {
+		c.PoolLimit = defaultPoolLimit
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("Url", c.Url)
+	if c.PoolLimit < 0 {
+		p.Addf("PoolLimit", "must not be negative, got %d", c.PoolLimit)
+	}
+	p.NonNegative("dial_timeout", c.DialTimeout)
+	p.Check("retry", c.Retry)
+}
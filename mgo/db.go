@@ -1,15 +1,32 @@
 package mgo
 
 import (
-	"encoding/json"
+	"context"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
 	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
 	"gopkg.in/mgo.v2"
+	"strings"
+	"sync"
 	"time"
 )
 
+/*
+conn 返回的会话对应的实例名称，Close 时按名称取消注册健康检查
+*/
+var instances sync.Map
+
+/*
+mgo 创建会话时必须连接集群，不支持延迟连接
+*/
 type dbConn struct {
-	Url       string
-	PoolLimit int
+	Url         string
+	PoolLimit   int
+	DialTimeout config.Duration `json:"dial_timeout"` //每次连接集群的超时时间，默认 5s
+	Retry       *retry.Policy   `json:"retry"`        //启动时连接的重试策略，为空时使用全局默认策略
 }
 
 /*
@@ -18,11 +35,18 @@ dbKey etcd存储的数据库连接字符串的key
 endpoints etcd的ip节点列表
 */
 func ConnByEtcd(dbKey string, endpoints ...string) (*mgo.Session, error) {
-	connStr, err := etcd.Conn(endpoints...).Get(dbKey)
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*mgo.Session, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -33,11 +57,18 @@ etcdPass etcd密码
 endpoints etcd的ip节点列表
 */
 func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*mgo.Session, error) {
-	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).Get(dbKey)
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*mgo.Session, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -46,11 +77,18 @@ env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
 dbKey etcd存储的数据库连接字符串的key
 */
 func ConnByEnv(env, dbKey string) (*mgo.Session, error) {
-	connStr, err := etcd.ConnByEnv(env).Get(dbKey)
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*mgo.Session, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -59,25 +97,89 @@ url 数据库地址
 poolLimit 线程池数
 */
 func ConnByStr(url string, poolLimit int) (*mgo.Session, error) {
+	return ConnByStrCtx(context.Background(), url, poolLimit)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时重试的整体时间，mgo 的单次连接只受 dial_timeout 控制
+*/
+func ConnByStrCtx(ctx context.Context, url string, poolLimit int) (*mgo.Session, error) {
 	cfg := new(dbConn)
 	cfg.Url = url
 	cfg.PoolLimit = poolLimit
-	return conn(cfg)
+	return conn(ctx, urlName(url), cfg)
+}
+
+/*
+以 URL 中的地址和库名作为实例名称，不包含用户名和密码
+*/
+func urlName(url string) string {
+	info, err := mgo.ParseURL(url)
+	if err != nil {
+		return backend
+	}
+	return strings.Join(info.Addrs, ",") + "/" + info.Database
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (*mgo.Session, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (*mgo.Session, error) {
+	return connByConnByte(ctx, name, data)
 }
 
-func connByConnByte(connByte []byte) (*mgo.Session, error) {
+func connByConnByte(ctx context.Context, name string, connByte []byte) (*mgo.Session, error) {
 	cfg := new(dbConn)
-	if err := json.Unmarshal(connByte, cfg); err != nil {
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, err
 	}
-	return conn(cfg)
+	return conn(ctx, name, cfg)
 }
 
-func conn(cfg *dbConn) (*mgo.Session, error) {
-	db, err := mgo.DialWithTimeout(cfg.Url, time.Second*5)
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (*mgo.Session, error) {
+	timeout := cfg.DialTimeout.Std()
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+	var db *mgo.Session
+	err := retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) (err error) {
+		db, err = mgo.DialWithTimeout(cfg.Url, timeout)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		metrics.ConnectError(backend, name)
+		return nil, dberr.Connect(name, err)
 	}
 	db.SetPoolLimit(cfg.PoolLimit)
+	registerStats()
+	health.Register(backend, name, func(ctx context.Context) error {
+		s := db.Copy()
+		defer s.Close()
+		return s.Ping()
+	})
+	instances.Store(db, name)
 	return db, nil
 }
+
+/*
+关闭 conn 返回的会话，同时取消注册健康检查
+连接池统计是所有会话共用的，不取消注册
+*/
+func Close(db *mgo.Session) {
+	if name, ok := instances.LoadAndDelete(db); ok {
+		health.Unregister(name.(string))
+	}
+	db.Close()
+}
@@ -0,0 +1,57 @@
+package mgo_test
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/mgo"
+	mgov2 "gopkg.in/mgo.v2"
+	"strings"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/mgo"
+
+/*
+没有内嵌的 mongodb，mgo 创建会话时必须连接，etcd 中写入没有服务监听的地址
+读取配置成功后才会连接，连接失败说明配置已经读取，返回 nil；读取配置的错误原样返回
+*/
+func dialed(session *mgov2.Session, err error) error {
+	if err == nil {
+		mgo.Close(session)
+		return errors.New("connect to a closed port succeeded")
+	}
+	if errors.Is(err, dberr.ErrConfigNotFound) || errors.Is(err, dberr.ErrInvalidConfig) || dberr.IsAuth(err) {
+		return err
+	}
+	if strings.Contains(err.Error(), "secret") {
+		return errors.New("error contains the password: " + err.Error())
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	url := "mongodb://app:secret@" + cmanydbtest.ClosedAddr(t) + "/app"
+	if err := env.Put(dbKey, map[string]interface{}{"Url": url, "dial_timeout": "300ms"}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return dialed(mgo.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return dialed(mgo.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return dialed(mgo.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	if err := dialed(mgo.ConnByStr("mongodb://app:secret@"+cmanydbtest.ClosedAddr(t)+"/app", 10)); err != nil {
+		t.Fatal(err)
+	}
+}
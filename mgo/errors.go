@@ -0,0 +1,48 @@
+package mgo
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"gopkg.in/mgo.v2"
+)
+
+/*
+mongodb 错误码
+*/
+const (
+	errUnauthorized         = 13
+	errAuthenticationFailed = 18
+	errExceededTimeLimit    = 50
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		Timeout: func(err error) bool {
+			return errCode(err) == errExceededTimeLimit
+		},
+		DuplicateKey: func(err error) bool {
+			var lastErr *mgo.LastError
+			var queryErr *mgo.QueryError
+			return errors.As(err, &lastErr) && mgo.IsDup(lastErr) || errors.As(err, &queryErr) && mgo.IsDup(queryErr)
+		},
+		NotFound: func(err error) bool {
+			return errors.Is(err, mgo.ErrNotFound)
+		},
+		Auth: func(err error) bool {
+			code := errCode(err)
+			return code == errAuthenticationFailed || code == errUnauthorized
+		},
+	})
+}
+
+func errCode(err error) int {
+	var lastErr *mgo.LastError
+	if errors.As(err, &lastErr) {
+		return lastErr.Code
+	}
+	var queryErr *mgo.QueryError
+	if errors.As(err, &queryErr) {
+		return queryErr.Code
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package mgo
+
+import (
+	"github.com/chu108/cmany_db/metrics"
+	"gopkg.in/mgo.v2"
+	"sync"
+)
+
+const backend = "mgo"
+
+var statsOnce sync.Once
+
+/*
+mgo 只提供进程级别的统计，所有会话共用一个实例标签 all
+mgo 没有命令钩子，不统计命令耗时
+*/
+func registerStats() {
+	statsOnce.Do(func() {
+		mgo.SetStats(true)
+		metrics.RegisterPool(backend, "all", func() metrics.PoolStats {
+			s := mgo.GetStats()
+			return metrics.PoolStats{
+				Open:  s.SocketsAlive,
+				Idle:  s.SocketsAlive - s.SocketsInUse,
+				InUse: s.SocketsInUse,
+			}
+		})
+	})
+}
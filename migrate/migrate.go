@@ -0,0 +1,255 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+一个版本的迁移
+文件名格式：{版本号}_{名称}.up.{扩展名} 和 {版本号}_{名称}.down.{扩展名}，如 0001_create_users.up.sql
+*/
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string //up 内容的 sha256，已执行的迁移内容被修改时报错
+}
+
+/*
+数据库中已执行的迁移记录
+*/
+type Record struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+/*
+迁移的存储和执行，mysql 见 NewSQL，mongodb 见 NewMongo
+*/
+type Driver interface {
+	//扩展名，如 sql、json
+	Ext() string
+	//创建迁移记录表
+	Init(ctx context.Context) error
+	//已执行的迁移，记录表不存在时返回 ErrNoTable
+	Applied(ctx context.Context) (map[int64]Record, error)
+	//执行 up，并写入记录
+	Up(ctx context.Context, m Migration) error
+	//执行 down，并删除记录
+	Down(ctx context.Context, m Migration) error
+}
+
+var ErrChecksum = errors.New("migration checksum mismatch")
+
+/*
+记录表不存在时 Applied 返回的错误，DryRun 时按没有执行过迁移处理
+*/
+var ErrNoTable = errors.New("migration table does not exist")
+
+/*
+执行选项
+DryRun 只返回将要执行的迁移，不修改数据库
+Lock 不为空时用 etcd 锁保证只有一个实例执行，其它实例等待锁释放后再检查
+LockKey 锁的 key，默认 /cmany_db/migrate/lock
+LockTTL 锁的租约时间（秒），默认 30，执行期间自动续租
+*/
+type Options struct {
+	DryRun  bool
+	Lock    *clientv3.Client
+	LockKey string
+	LockTTL int64
+}
+
+const (
+	defaultLockKey = "/cmany_db/migrate/lock"
+	defaultLockTTL = 30
+	lockWait       = time.Second
+)
+
+/*
+从 fsys 的 dir 目录读取迁移文件，通常是 embed.FS
+ext 文件扩展名，如 sql、json，按版本号排序返回
+*/
+func Load(fsys fs.FS, dir, ext string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file := entry.Name()
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(file, ".up."+ext):
+			up, base = true, strings.TrimSuffix(file, ".up."+ext)
+		case strings.HasSuffix(file, ".down."+ext):
+			base = strings.TrimSuffix(file, ".down."+ext)
+		default:
+			continue
+		}
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version in %s: %w", file, err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			if len(parts) > 1 {
+				m.Name = parts[1]
+			}
+			byVersion[version] = m
+		}
+		if up {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+/*
+执行所有未执行的迁移，返回执行了（DryRun 时为将要执行）的迁移
+已执行的迁移校验 checksum，不一致时返回 ErrChecksum，不执行任何迁移
+*/
+func Up(ctx context.Context, d Driver, migrations []Migration, opts *Options) (done []Migration, err error) {
+	err = withLock(ctx, opts, func() error {
+		applied, err := prepare(ctx, d, migrations, opts)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if opts == nil || !opts.DryRun {
+				if err := d.Up(ctx, m); err != nil {
+					return fmt.Errorf("migrate: up %d_%s: %w", m.Version, m.Name, err)
+				}
+				logger.For("migrate").Info("migration applied", logger.F("version", m.Version), logger.F("name", m.Name))
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+/*
+按版本从大到小回滚 steps 个已执行的迁移，返回回滚了（DryRun 时为将要回滚）的迁移
+*/
+func Down(ctx context.Context, d Driver, migrations []Migration, steps int, opts *Options) (done []Migration, err error) {
+	err = withLock(ctx, opts, func() error {
+		applied, err := prepare(ctx, d, migrations, opts)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migrate: version %d has no down file", m.Version)
+			}
+			if opts == nil || !opts.DryRun {
+				if err := d.Down(ctx, m); err != nil {
+					return fmt.Errorf("migrate: down %d_%s: %w", m.Version, m.Name, err)
+				}
+				logger.For("migrate").Info("migration rolled back", logger.F("version", m.Version), logger.F("name", m.Name))
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func prepare(ctx context.Context, d Driver, migrations []Migration, opts *Options) (map[int64]Record, error) {
+	if opts == nil || !opts.DryRun {
+		if err := d.Init(ctx); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := d.Applied(ctx)
+	if err != nil {
+		if opts != nil && opts.DryRun && errors.Is(err, ErrNoTable) {
+			//DryRun 时不创建记录表，表不存在等同于没有执行过迁移
+			return map[int64]Record{}, nil
+		}
+		return nil, err
+	}
+	for _, m := range migrations {
+		if r, ok := applied[m.Version]; ok && r.Checksum != m.Checksum {
+			return nil, fmt.Errorf("%w: version %d_%s", ErrChecksum, m.Version, m.Name)
+		}
+	}
+	return applied, nil
+}
+
+/*
+抢到锁的实例执行 fn，其它实例等待锁释放后再执行，此时通常已经没有需要执行的迁移
+*/
+func withLock(ctx context.Context, opts *Options, fn func() error) error {
+	if opts == nil || opts.Lock == nil {
+		return fn()
+	}
+	key := opts.LockKey
+	if key == "" {
+		key = defaultLockKey
+	}
+	ttl := opts.LockTTL
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	for {
+		err := etcd.LockKeepAlive(opts.Lock, key, ttl, fn)
+		if !errors.Is(err, dberr.ErrLockHeld) {
+			return err
+		}
+		logger.For(key).Info("migration lock held by another instance, waiting")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockWait):
+		}
+	}
+}
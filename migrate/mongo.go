@@ -0,0 +1,108 @@
+package migrate
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+const mongoCollection = "schema_migrations"
+
+type mongoDriver struct {
+	db *mongo.Database
+}
+
+/*
+mongodb 迁移，记录写在 schema_migrations 集合中
+迁移文件为扩展 JSON 格式的数据库命令，可以是一个命令或命令数组，如
+[{"create":"users"},{"createIndexes":"users","indexes":[{"key":{"email":1},"name":"email_1","unique":true}]}]
+*/
+func NewMongo(db *mongo.Database) Driver {
+	return &mongoDriver{db: db}
+}
+
+func (d *mongoDriver) Ext() string {
+	return "json"
+}
+
+/*
+记录以 _id 为版本号，不需要额外建索引
+*/
+func (d *mongoDriver) Init(ctx context.Context) error {
+	return nil
+}
+
+func (d *mongoDriver) Applied(ctx context.Context) (map[int64]Record, error) {
+	cur, err := d.db.Collection(mongoCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	applied := make(map[int64]Record)
+	for cur.Next(ctx) {
+		var doc struct {
+			Version   int64     `bson:"_id"`
+			Name      string    `bson:"name"`
+			Checksum  string    `bson:"checksum"`
+			AppliedAt time.Time `bson:"applied_at"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		applied[doc.Version] = Record{Version: doc.Version, Name: doc.Name, Checksum: doc.Checksum, AppliedAt: doc.AppliedAt}
+	}
+	return applied, cur.Err()
+}
+
+func (d *mongoDriver) Up(ctx context.Context, m Migration) error {
+	if err := d.run(ctx, m.Up); err != nil {
+		return err
+	}
+	_, err := d.db.Collection(mongoCollection).ReplaceOne(ctx,
+		bson.M{"_id": m.Version},
+		bson.M{"_id": m.Version, "name": m.Name, "checksum": m.Checksum, "applied_at": time.Now().UTC()},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (d *mongoDriver) Down(ctx context.Context, m Migration) error {
+	if err := d.run(ctx, m.Down); err != nil {
+		return err
+	}
+	_, err := d.db.Collection(mongoCollection).DeleteOne(ctx, bson.M{"_id": m.Version})
+	return err
+}
+
+/*
+按顺序执行文件中的命令，mongodb 的 DDL 不支持事务
+*/
+func (d *mongoDriver) run(ctx context.Context, script string) error {
+	script = strings.TrimSpace(script)
+	var cmds []bson.Raw
+	if strings.HasPrefix(script, "[") {
+		//扩展 JSON 不支持顶层数组，包一层再解析
+		var wrapper struct {
+			Commands []bson.Raw `bson:"commands"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(`{"commands":`+script+`}`), false, &wrapper); err != nil {
+			return err
+		}
+		cmds = wrapper.Commands
+	} else {
+		var cmd bson.Raw
+		if err := bson.UnmarshalExtJSON([]byte(script), false, &cmd); err != nil {
+			return err
+		}
+		cmds = []bson.Raw{cmd}
+	}
+	for _, cmd := range cmds {
+		if err := d.db.RunCommand(ctx, cmd).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/mysql"
+	gomysql "github.com/go-sql-driver/mysql"
+	"strings"
+	"time"
+)
+
+const sqlTable = "schema_migrations"
+
+/*
+mysql 表不存在的错误码
+*/
+const mysqlNoSuchTable = 1146
+
+type sqlDriver struct {
+	db *sql.DB
+}
+
+/*
+mysql 迁移，db 为从库时自动换成对应的主库
+记录写在 schema_migrations 表中，一个文件可以包含多条以分号结尾的语句
+*/
+func NewSQL(db *sql.DB) Driver {
+	return &sqlDriver{db: mysql.Master(db)}
+}
+
+func (d *sqlDriver) Ext() string {
+	return "sql"
+}
+
+func (d *sqlDriver) Init(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+sqlTable+` (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	checksum CHAR(64) NOT NULL,
+	applied_at DATETIME NOT NULL
+)`)
+	return err
+}
+
+func (d *sqlDriver) Applied(ctx context.Context) (map[int64]Record, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+sqlTable)
+	if err != nil {
+		var mysqlErr *gomysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlNoSuchTable {
+			return nil, fmt.Errorf("%w: %v", ErrNoTable, err)
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]Record)
+	for rows.Next() {
+		var r Record
+		var appliedAt []byte
+		if err := rows.Scan(&r.Version, &r.Name, &r.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		//不依赖 DSN 中的 parseTime
+		r.AppliedAt, _ = time.Parse("2006-01-02 15:04:05", string(appliedAt))
+		applied[r.Version] = r
+	}
+	return applied, rows.Err()
+}
+
+/*
+mysql 的 DDL 会隐式提交，迁移中途失败时已执行的 DDL 不会回滚，记录只在全部语句成功后写入
+*/
+func (d *sqlDriver) Up(ctx context.Context, m Migration) error {
+	return mysql.WithTx(ctx, d.db, nil, func(ctx context.Context, tx *sql.Tx) error {
+		if err := execScript(ctx, tx, m.Up); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO "+sqlTable+" (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			m.Version, m.Name, m.Checksum, time.Now().UTC().Format("2006-01-02 15:04:05"))
+		return err
+	})
+}
+
+func (d *sqlDriver) Down(ctx context.Context, m Migration) error {
+	return mysql.WithTx(ctx, d.db, nil, func(ctx context.Context, tx *sql.Tx) error {
+		if err := execScript(ctx, tx, m.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+sqlTable+" WHERE version = ?", m.Version)
+		return err
+	})
+}
+
+func execScript(ctx context.Context, tx *sql.Tx, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+按分号拆分语句，忽略引号内和注释中的分号
+普通注释去掉，/*! 和 /*+ 开头的注释是 mysql 会执行的内容，保留在语句中
+*/
+func splitStatements(script string) []string {
+	var stmts []string
+	var cur strings.Builder
+	var quote byte
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			cur.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				cur.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(script[i:], "-- ") || c == '#':
+			//保留换行，避免注释前后的内容连在一起
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end - 1
+			}
+			continue
+		case c == '/' && (strings.HasPrefix(script[i:], "/*!") || strings.HasPrefix(script[i:], "/*+")):
+			//版本注释和优化器提示会被 mysql 执行，原样保留
+			end := strings.Index(script[i+3:], "*/")
+			if end < 0 {
+				end = len(script) - i
+			} else {
+				end += 5
+			}
+			cur.WriteString(script[i : i+end])
+			i += end - 1
+			continue
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			continue
+		case c == ';':
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"single", "CREATE TABLE t (id INT)", []string{"CREATE TABLE t (id INT)"}},
+		{"multiple", "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n", []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"}},
+		{"empty statements", " ; ;\n", nil},
+		{"semicolons in quotes", `INSERT INTO t VALUES ('a;b', "c;d", 'it\'s;');SELECT 1`, []string{`INSERT INTO t VALUES ('a;b', "c;d", 'it\'s;')`, "SELECT 1"}},
+		{"semicolon in backquotes", "CREATE TABLE `a;b` (id INT);SELECT 1", []string{"CREATE TABLE `a;b` (id INT)", "SELECT 1"}},
+		{"line comments", "-- first; comment\nSELECT 1; # second; comment\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"comment keeps the line break", "SELECT 1 -- comment\nFROM t", []string{"SELECT 1 \nFROM t"}},
+		{"block comment", "SELECT /* a; b */ 1;", []string{"SELECT  1"}},
+		{"executable comments are kept", "/*!40101 SET NAMES utf8mb4 */;SELECT /*+ MAX_EXECUTION_TIME(1000) */ 1", []string{"/*!40101 SET NAMES utf8mb4 */", "SELECT /*+ MAX_EXECUTION_TIME(1000) */ 1"}},
+		{"unterminated comment", "SELECT 1; /* ; SELECT 2", []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitStatements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package mongodb
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/breaker"
+	"net"
+)
+
+/*
+mongo-driver 没有命令拦截，熔断只作用在建立连接上
+熔断期间新连接直接失败，驱动会把服务器标记为不可用
+*/
+type dialer struct {
+	net.Dialer
+	breaker *breaker.Breaker
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := d.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	c, err := d.Dialer.DialContext(ctx, network, address)
+	d.breaker.ReportResult(err)
+	return c, err
+}
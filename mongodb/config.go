@@ -0,0 +1,31 @@
+package mongodb
+
+import (
+	"github.com/chu108/cmany_db/config"
+	"strings"
+)
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("Url", c.Url)
+	if c.Url != "" && !strings.HasPrefix(c.Url, "mongodb://") && !strings.HasPrefix(c.Url, "mongodb+srv://") {
+		p.Add("Url", "must start with mongodb:// or mongodb+srv://")
+	}
+	p.Required("DbName", c.DbName)
+	p.NonNegative("connect_timeout", c.ConnectTimeout)
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.NonNegative("server_selection_timeout", c.ServerSelectionTimeout)
+	p.Check("retry", c.Retry)
+	p.Check("breaker", c.Breaker)
+	if c.Breaker != nil && c.Breaker.Fallback != "" {
+		p.Addf("breaker.fallback", "%s is not supported by mongodb", c.Breaker.Fallback)
+	}
+}
@@ -2,17 +2,34 @@ package mongodb
 
 import (
 	"context"
-	"encoding/json"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
 	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"sync"
 	"time"
 )
 
+/*
+conn 返回的客户端对应的实例名称，Close 时按名称取消注册健康检查、连接池指标和熔断器
+*/
+var instances sync.Map
+
 type dbConn struct {
-	Url    string
-	DbName string
+	Url                    string
+	DbName                 string
+	ConnectTimeout         config.Duration `json:"connect_timeout"`          //建立连接的超时时间，为 0 时使用驱动默认值 30s
+	PingTimeout            config.Duration `json:"ping_timeout"`             //启动时每次 ping 的超时时间，默认 5s
+	ServerSelectionTimeout config.Duration `json:"server_selection_timeout"` //选择可用节点的超时时间，为 0 时使用驱动默认值 30s
+	Retry                  *retry.Policy   `json:"retry"`                    //启动时 ping 的重试策略，为空时使用全局默认策略
+	Lazy                   bool            `json:"lazy"`                     //延迟连接，创建时不 ping
+	Breaker                *breaker.Config `json:"breaker"`                  //熔断配置，为空时不熔断，只作用在建立连接上
 }
 
 /*
@@ -21,11 +38,18 @@ dbKey etcd存储的数据库连接字符串的key
 endpoints etcd的ip节点列表
 */
 func ConnByEtcd(dbKey string, endpoints ...string) (*mongo.Database, error) {
-	connStr, err := etcd.Conn(endpoints...).Get(dbKey)
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*mongo.Database, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -36,11 +60,18 @@ etcdPass etcd密码
 endpoints etcd的ip节点列表
 */
 func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*mongo.Database, error) {
-	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).Get(dbKey)
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*mongo.Database, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -49,11 +80,18 @@ env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
 dbKey etcd存储的数据库连接字符串的key
 */
 func ConnByEnv(env, dbKey string) (*mongo.Database, error) {
-	connStr, err := etcd.ConnByEnv(env).Get(dbKey)
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*mongo.Database, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -62,35 +100,116 @@ url 地址
 dbName 数据库名称
 */
 func ConnByStr(url, dbName string) (*mongo.Database, error) {
+	return ConnByStrCtx(context.Background(), url, dbName)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByStrCtx(ctx context.Context, url, dbName string) (*mongo.Database, error) {
 	cfg := new(dbConn)
 	cfg.Url = url
 	cfg.DbName = dbName
-	return conn(cfg)
+	return conn(ctx, dbName, cfg)
 }
 
-func connByConnByte(connByte []byte) (*mongo.Database, error) {
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (*mongo.Database, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (*mongo.Database, error) {
+	return connByConnByte(ctx, name, data)
+}
+
+func connByConnByte(ctx context.Context, name string, connByte []byte) (*mongo.Database, error) {
 	cfg := new(dbConn)
-	if err := json.Unmarshal(connByte, cfg); err != nil {
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, err
 	}
-	return conn(cfg)
+	return conn(ctx, name, cfg)
 }
 
-func conn(cfg *dbConn) (*mongo.Database, error) {
-	ctx := Ctx(5)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Url))
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(cfg.Url).
+		SetMonitor(commandMonitor(name)).
+		SetPoolMonitor(poolMonitor(name))
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout.Std())
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(cfg.ServerSelectionTimeout.Std())
+	}
+	if cfg.Breaker != nil {
+		opts.SetDialer(&dialer{breaker: breaker.New(name, *cfg.Breaker, nil)})
+	}
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		return nil, err
+		metrics.ConnectError(backend, name)
+		metrics.UnregisterPool(backend, name)
+		if cfg.Breaker != nil {
+			breaker.Unregister(name)
+		}
+		return nil, dberr.Connect(name, err)
 	}
 	//是否连接上了数据库
-	err = client.Ping(ctx, readpref.Primary())
+	err = ping(ctx, name, cfg, client)
 	if err != nil {
-		return nil, err
+		metrics.ConnectError(backend, name)
+		metrics.UnregisterPool(backend, name)
+		if cfg.Breaker != nil {
+			breaker.Unregister(name)
+		}
+		client.Disconnect(context.Background())
+		return nil, dberr.Connect(name, err)
 	}
+	health.Register(backend, name, func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	})
+	instances.Store(client, name)
 	//设置数据库
 	return client.Database(cfg.DbName), nil
 }
 
+/*
+断开 conn 返回的数据库所属的客户端，同时取消注册健康检查、连接池指标和熔断器
+ctx 控制等待进行中的操作结束的时间
+*/
+func Close(ctx context.Context, db *mongo.Database) error {
+	client := db.Client()
+	if name, ok := instances.LoadAndDelete(client); ok {
+		health.Unregister(name.(string))
+		metrics.UnregisterPool(backend, name.(string))
+		breaker.Unregister(name.(string))
+	}
+	return client.Disconnect(ctx)
+}
+
+func ping(ctx context.Context, name string, cfg *dbConn, client *mongo.Client) error {
+	if cfg.Lazy {
+		return nil
+	}
+	timeout := cfg.PingTimeout.Std()
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+	return retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return client.Ping(ctx, readpref.Primary())
+	})
+}
+
 func CtxAndCancel(timeout int) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
 }
@@ -0,0 +1,72 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/mongodb"
+
+/*
+没有内嵌的 mongodb，etcd 中写入延迟连接的配置，只验证读取配置和创建客户端
+*/
+func check(db *mongo.Database, err error) error {
+	if err != nil {
+		return err
+	}
+	defer mongodb.Close(context.Background(), db)
+	if db.Name() != "app" {
+		return fmt.Errorf("database = %q, want app", db.Name())
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	url := "mongodb://app:secret@" + cmanydbtest.ClosedAddr(t) + "/app"
+	if err := env.Put(dbKey, map[string]interface{}{"Url": url, "DbName": "app", "lazy": true}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return check(mongodb.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return check(mongodb.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return check(mongodb.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	url := "mongodb://app:secret@" + cmanydbtest.ClosedAddr(t) + "/app?serverSelectionTimeoutMS=300&connectTimeoutMS=300"
+	_, err := mongodb.ConnByStr(url, "app")
+	if !errors.Is(err, dberr.ErrUnreachable) {
+		t.Fatalf("closed port = %v, want ErrUnreachable", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error contains the password: %v", err)
+	}
+}
+
+func TestConnByJSONUnregistersBreaker(t *testing.T) {
+	url := "mongodb://" + cmanydbtest.ClosedAddr(t) + "/app"
+	data := []byte(`{"Url":"` + url + `","DbName":"app","ping_timeout":"300ms","breaker":{}}`)
+	if _, err := mongodb.ConnByJSON("breaker", data); err == nil {
+		t.Fatal("connect to closed port succeeded")
+	}
+	if breaker.Get("breaker") != nil {
+		t.Fatal("breaker still registered after failed connect")
+	}
+}
@@ -0,0 +1,34 @@
+package mongodb
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+mongodb 错误码
+*/
+const (
+	errUnauthorized         = 13
+	errAuthenticationFailed = 18
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		Timeout:      mongo.IsTimeout,
+		DuplicateKey: mongo.IsDuplicateKeyError,
+		NotFound: func(err error) bool {
+			return errors.Is(err, mongo.ErrNoDocuments)
+		},
+		Auth: func(err error) bool {
+			var se mongo.ServerError
+			return errors.As(err, &se) && (se.HasErrorCode(errAuthenticationFailed) || se.HasErrorCode(errUnauthorized))
+		},
+		Retryable: func(err error) bool {
+			var le mongo.LabeledError
+			return errors.As(err, &le) && (le.HasErrorLabel("NetworkError") || le.HasErrorLabel("RetryableWriteError") ||
+				le.HasErrorLabel("TransientTransactionError"))
+		},
+	})
+}
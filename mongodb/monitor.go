@@ -0,0 +1,113 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const backend = "mongodb"
+
+/*
+通过驱动的连接池事件统计连接数
+*/
+type poolCounter struct {
+	open         int64
+	inUse        int64
+	waitCount    int64
+	waitDuration int64
+}
+
+func (p *poolCounter) event(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		atomic.AddInt64(&p.open, 1)
+	case event.ConnectionClosed:
+		atomic.AddInt64(&p.open, -1)
+	case event.GetSucceeded:
+		atomic.AddInt64(&p.inUse, 1)
+		atomic.AddInt64(&p.waitCount, 1)
+		atomic.AddInt64(&p.waitDuration, int64(e.Duration))
+	case event.GetFailed:
+		atomic.AddInt64(&p.waitCount, 1)
+		atomic.AddInt64(&p.waitDuration, int64(e.Duration))
+	case event.ConnectionReturned:
+		atomic.AddInt64(&p.inUse, -1)
+	}
+}
+
+func (p *poolCounter) stats() metrics.PoolStats {
+	open := atomic.LoadInt64(&p.open)
+	inUse := atomic.LoadInt64(&p.inUse)
+	return metrics.PoolStats{
+		Open:         int(open),
+		Idle:         int(open - inUse),
+		InUse:        int(inUse),
+		WaitCount:    atomic.LoadInt64(&p.waitCount),
+		WaitDuration: time.Duration(atomic.LoadInt64(&p.waitDuration)),
+	}
+}
+
+func poolMonitor(name string) *event.PoolMonitor {
+	counter := new(poolCounter)
+	metrics.RegisterPool(backend, name, counter.stats)
+	return &event.PoolMonitor{Event: counter.event}
+}
+
+/*
+统计每个命令的耗时，开启追踪时为每个命令创建 span
+span 在 Started 中创建，按连接和请求 ID 暂存，在 Succeeded 或 Failed 中结束
+*/
+func commandMonitor(name string) *event.CommandMonitor {
+	var spans sync.Map
+	end := func(connID string, requestID int64, err error) {
+		if span, ok := spans.LoadAndDelete(spanKey(connID, requestID)); ok {
+			tracing.End(span.(trace.Span), err)
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			if !tracing.Enabled() {
+				return
+			}
+			_, span := tracing.Start(ctx, backend, name, e.CommandName, statement(e))
+			spans.Store(spanKey(e.ConnectionID, e.RequestID), span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			metrics.Observe(backend, name, strings.ToUpper(e.CommandName), time.Now().Add(-e.Duration), nil)
+			end(e.ConnectionID, e.RequestID, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			err := commandError(e.Failure)
+			metrics.Observe(backend, name, strings.ToUpper(e.CommandName), time.Now().Add(-e.Duration), err)
+			end(e.ConnectionID, e.RequestID, err)
+		},
+	}
+}
+
+func spanKey(connID string, requestID int64) string {
+	return fmt.Sprintf("%s/%d", connID, requestID)
+}
+
+/*
+脱敏后的命令，只保留命令名、库名和集合名，不包含查询条件
+*/
+func statement(e *event.CommandStartedEvent) string {
+	if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		return e.CommandName + " " + e.DatabaseName + "." + coll
+	}
+	return e.CommandName + " " + e.DatabaseName
+}
+
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
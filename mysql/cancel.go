@@ -0,0 +1,164 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/chu108/cmany_db/logger"
+	"github.com/chu108/cmany_db/metrics"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+KILL QUERY 建立连接和执行的超时时间
+*/
+const killTimeout = time.Second * 5
+
+/*
+ctx 取消时在新连接上执行 KILL QUERY，驱动只会断开本地连接，服务端的语句会继续执行
+返回的函数在语句结束时调用，KILL QUERY 已经开始时等待它结束，并标记本连接不再复用，避免误杀之后的语句
+*/
+func (c *wrapConn) watchCancel(ctx context.Context) func() {
+	if c.killer == nil || ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(done)
+		c.killQuery()
+	})
+	return func() {
+		if !stop() {
+			<-done
+			c.killed = true
+		}
+	}
+}
+
+func (c *wrapConn) killQuery() {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	begin := time.Now()
+	cn, err := c.killer.Connect(ctx)
+	if err == nil {
+		if e, ok := cn.(driver.ExecerContext); ok {
+			_, err = e.ExecContext(ctx, "KILL QUERY "+strconv.FormatUint(c.connID, 10), nil)
+		}
+		cn.Close()
+	}
+	metrics.Observe(backend, c.name, "KILL", begin, err)
+	if err != nil {
+		logger.For(c.name).Warn("kill query failed", logger.F("connection_id", c.connID), logger.F("error", err.Error()))
+		return
+	}
+	logger.For(c.name).Debug("query killed", logger.F("connection_id", c.connID))
+}
+
+/*
+ctx 有截止时间时给 SELECT 加上 MAX_EXECUTION_TIME 提示，超时后服务端自己中止语句
+已有提示注释时合并到同一个注释中，已设置 MAX_EXECUTION_TIME 时不修改
+只对直接执行的查询生效：预处理语句的文本在 Prepare 时固定，之后每次执行的截止时间不同，所以不加提示
+没有开启 interpolateParams 时带参数的查询由 database/sql 改用预处理语句执行，同样不加提示
+*/
+func (c *wrapConn) deadlineHint(ctx context.Context, query string) string {
+	if !c.hint {
+		return query
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || command(query) != "SELECT" || strings.Contains(strings.ToUpper(query), "MAX_EXECUTION_TIME") {
+		return query
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	hint := "MAX_EXECUTION_TIME(" + strconv.FormatInt(ms, 10) + ")"
+	i := len(query) - len(strings.TrimLeft(query, " \t\r\n(")) + len("SELECT")
+	rest := strings.TrimLeft(query[i:], " \t\r\n")
+	if strings.HasPrefix(rest, "/*+") {
+		j := len(query) - len(rest) + len("/*+")
+		return query[:j] + " " + hint + query[j:]
+	}
+	return query[:i] + " /*+ " + hint + " */" + query[i:]
+}
+
+/*
+语句出错时立即停止监听，否则在 rows 关闭时停止，读取结果期间取消也会 KILL QUERY
+*/
+func stopOnClose(rows driver.Rows, err error, stop func()) (driver.Rows, error) {
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	return &wrapRows{Rows: rows, onClose: stop}, nil
+}
+
+/*
+rows 关闭时执行 onClose，转发驱动 rows 的可选接口，避免丢失列类型和多结果集
+*/
+type wrapRows struct {
+	driver.Rows
+	onClose func()
+	closed  bool
+}
+
+func (r *wrapRows) Close() error {
+	err := r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		r.onClose()
+	}
+	return err
+}
+
+func (r *wrapRows) HasNextResultSet() bool {
+	if n, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return n.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *wrapRows) NextResultSet() error {
+	if n, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return n.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *wrapRows) ColumnTypeScanType(index int) reflect.Type {
+	if t, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return t.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *wrapRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *wrapRows) ColumnTypeLength(index int) (int64, bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return t.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *wrapRows) ColumnTypeNullable(index int) (bool, bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return t.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *wrapRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return t.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/fake"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestWatchCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool  //语句结束前取消 ctx
+		killErr error //KILL QUERY 返回的错误
+		killed  bool
+	}{
+		{name: "finished before cancel"},
+		{name: "cancelled", cancel: true, killed: true},
+		{name: "kill failed", cancel: true, killErr: errors.New("unknown thread id"), killed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, killer := fake.NewSQL()
+			if tt.killErr != nil {
+				killer.OnError("KILL QUERY 42", tt.killErr)
+			} else {
+				killer.OnExec("KILL QUERY 42", 0, 0)
+			}
+			c := &wrapConn{name: "kill", connID: 42, killer: killer}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stop := c.watchCancel(ctx)
+			if tt.cancel {
+				cancel()
+				//等待 KILL QUERY 开始执行，stop 会等它结束
+				deadline := time.Now().Add(time.Second)
+				for len(killer.Statements()) == 0 && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			stop()
+			cancel()
+
+			var kills int
+			for _, stmt := range killer.Statements() {
+				if stmt.Query == "KILL QUERY 42" {
+					kills++
+				}
+			}
+			want := 0
+			if tt.cancel {
+				want = 1
+			}
+			if kills != want {
+				t.Fatalf("KILL QUERY executed %d times, want %d", kills, want)
+			}
+			if c.killed != tt.killed || c.IsValid() == tt.killed {
+				t.Fatalf("killed = %v, IsValid = %v, want killed %v", c.killed, c.IsValid(), tt.killed)
+			}
+		})
+	}
+}
+
+func TestWatchCancelDisabled(t *testing.T) {
+	//没有开启 kill_on_cancel 或 ctx 不会取消时不监听
+	_, killer := fake.NewSQL()
+	for _, c := range []struct {
+		conn *wrapConn
+		ctx  context.Context
+	}{
+		{&wrapConn{connID: 42}, canceled()},
+		{&wrapConn{connID: 42, killer: killer}, context.Background()},
+	} {
+		c.conn.watchCancel(c.ctx)()
+	}
+	if n := len(killer.Statements()); n != 0 {
+		t.Fatalf("executed %d statements, want none", n)
+	}
+}
+
+func canceled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestDeadlineHint(t *testing.T) {
+	tests := []struct {
+		name     string
+		hint     bool
+		deadline bool
+		query    string
+		want     string
+	}{
+		{"disabled", false, true, "SELECT 1", "SELECT 1"},
+		{"no deadline", true, false, "SELECT 1", "SELECT 1"},
+		{"select", true, true, "SELECT 1", "SELECT /*+ MAX_EXECUTION_TIME(N) */ 1"},
+		{"parenthesized", true, true, " (SELECT 1) UNION (SELECT 2)", " (SELECT /*+ MAX_EXECUTION_TIME(N) */ 1) UNION (SELECT 2)"},
+		{"existing hint", true, true, "SELECT /*+ BKA(t) */ * FROM t", "SELECT /*+ MAX_EXECUTION_TIME(N) BKA(t) */ * FROM t"},
+		{"already set", true, true, "SELECT /*+ MAX_EXECUTION_TIME(5) */ 1", "SELECT /*+ MAX_EXECUTION_TIME(5) */ 1"},
+		{"not a select", true, true, "UPDATE t SET a = 1", "UPDATE t SET a = 1"},
+	}
+	ms := regexp.MustCompile(`MAX_EXECUTION_TIME\(\d{4}\)`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.deadline {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+				defer cancel()
+			}
+			c := &wrapConn{hint: tt.hint}
+			if got := ms.ReplaceAllString(c.deadlineHint(ctx, tt.query), "MAX_EXECUTION_TIME(N)"); got != tt.want {
+				t.Fatalf("deadlineHint = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
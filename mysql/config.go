@@ -0,0 +1,213 @@
+package mysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/chu108/cmany_db/config"
+	gomysql "github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxOpen         = 100
+	defaultMaxIdle         = 10
+	defaultPort            = 3306
+	defaultConnMaxLifetime = time.Second * 100
+)
+
+/*
+分字段配置时默认的 DSN 参数，保证各服务的字符集和时间解析一致
+*/
+var defaultParams = map[string]string{
+	"charset":   "utf8mb4",
+	"parseTime": "true",
+	"loc":       "Local",
+}
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(mysqlConfig))
+}
+
+/*
+没有配置从库时从库与主库相同
+*/
+func (c *mysqlConfig) SetDefaults() {
+	if c.Slave.DSN == "" && c.Slave.Host == "" {
+		c.Slave = c.Master
+	}
+	c.Master.SetDefaults()
+	c.Slave.SetDefaults()
+}
+
+func (c *mysqlConfig) Validate(p *config.Problems) {
+	c.Master.Validate(p.Sub("master"))
+	c.Slave.Validate(p.Sub("slave"))
+	if c.Master.Breaker != nil && c.Master.Breaker.Fallback == "master" {
+		p.Add("master.breaker.fallback", "master can only be used by slave")
+	}
+	if c.Master.GTIDWait != 0 {
+		p.Add("master.gtid_wait", "only used by slave")
+	}
+}
+
+/*
+max_open 默认 100，max_idle 默认 10 且不超过 max_open，分字段配置时 port 默认 3306
+*/
+func (c *dbConn) SetDefaults() {
+	if c.DSN == "" && c.Port == 0 {
+		c.Port = defaultPort
+	}
+	if c.MaxOpen == 0 {
+		c.MaxOpen = defaultMaxOpen
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = defaultMaxIdle
+		if c.MaxIdle > c.MaxOpen && c.MaxOpen > 0 {
+			c.MaxIdle = c.MaxOpen
+		}
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	switch {
+	case c.DSN == "" && c.Host == "":
+		p.Add("dsn", "dsn or host is required")
+	case c.DSN != "" && c.Host != "":
+		p.Add("dsn", "dsn and host can not be used together")
+	case c.DSN != "":
+		if _, err := gomysql.ParseDSN(c.DSN); err != nil {
+			p.Add("dsn", err.Error())
+		}
+	default:
+		p.Range("port", c.Port, 1, 65535)
+		if c.Password != "" && c.User == "" {
+			p.Add("user", "required when password is set")
+		}
+		if _, err := parseParams(c.Params); err != nil {
+			p.Add("params", err.Error())
+		}
+	}
+	p.NonNegative("dial_timeout", c.DialTimeout)
+	p.NonNegative("read_timeout", c.ReadTimeout)
+	p.NonNegative("write_timeout", c.WriteTimeout)
+	p.NonNegative("conn_max_lifetime", c.ConnMaxLifetime)
+	p.NonNegative("conn_max_idle_time", c.ConnMaxIdleTime)
+	if c.MaxOpen < 0 {
+		p.Addf("max_open", "must not be negative, got %d", c.MaxOpen)
+	}
+	if c.MaxIdle < 0 {
+		p.Addf("max_idle", "must not be negative, got %d", c.MaxIdle)
+	} else if c.MaxIdle > c.MaxOpen {
+		p.Addf("max_idle", "must not be greater than max_open %d, got %d", c.MaxOpen, c.MaxIdle)
+	}
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.NonNegative("gtid_wait", c.GTIDWait)
+	p.Check("retry", c.Retry)
+	p.Check("breaker", c.Breaker)
+	if c.Breaker != nil && c.Breaker.Fallback == "miss" {
+		p.Add("breaker.fallback", "miss is not supported by mysql")
+	}
+}
+
+func (c *dbConn) connMaxLifetime() time.Duration {
+	if c.ConnMaxLifetime <= 0 {
+		return defaultConnMaxLifetime
+	}
+	return c.ConnMaxLifetime.Std()
+}
+
+/*
+生成 go-sql-driver 的配置，dsn 和分字段配置都支持，超时和 TLS 对两种方式都生效
+*/
+func (c *dbConn) driverConfig() (*gomysql.Config, error) {
+	var cfg *gomysql.Config
+	if c.DSN != "" {
+		parsed, err := gomysql.ParseDSN(c.DSN)
+		if err != nil {
+			return nil, err
+		}
+		cfg = parsed
+	} else {
+		//参数交给驱动解析，parseTime、loc 等特殊参数和 DSN 中的写法效果相同
+		parsed, err := parseParams(c.Params)
+		if err != nil {
+			return nil, err
+		}
+		cfg = parsed
+		cfg.User = c.User
+		cfg.Passwd = c.Password
+		cfg.Net = "tcp"
+		port := c.Port
+		if port == 0 {
+			port = defaultPort
+		}
+		cfg.Addr = net.JoinHostPort(c.Host, strconv.Itoa(port))
+		cfg.DBName = c.Database
+	}
+	if c.DialTimeout > 0 {
+		cfg.Timeout = c.DialTimeout.Std()
+	}
+	if c.ReadTimeout > 0 {
+		cfg.ReadTimeout = c.ReadTimeout.Std()
+	}
+	if c.WriteTimeout > 0 {
+		cfg.WriteTimeout = c.WriteTimeout.Std()
+	}
+	if c.TLSCA != "" {
+		tlsCfg, err := loadCA(c.TLSCA, cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS = tlsCfg
+	}
+	return cfg, nil
+}
+
+func parseParams(params map[string]string) (*gomysql.Config, error) {
+	values := url.Values{}
+	for k, v := range defaultParams {
+		values.Set(k, v)
+	}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	return gomysql.ParseDSN("/?" + values.Encode())
+}
+
+func loadCA(path, addr string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls_ca: no certificate found in " + path)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return &tls.Config{RootCAs: pool, ServerName: host}, nil
+}
+
+/*
+不含密码的连接信息，主从配置相同时共用一个连接池
+*/
+func (c *dbConn) dsnKey() string {
+	cfg, err := c.driverConfig()
+	if err != nil {
+		return c.DSN
+	}
+	cfg.Passwd = ""
+	return cfg.FormatDSN()
+}
@@ -1,17 +1,46 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
 	"github.com/chu108/cmany_db/etcd"
-	_ "github.com/go-sql-driver/mysql"
-	"time"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
+	gomysql "github.com/go-sql-driver/mysql"
+	"sync"
 )
 
+/*
+连接信息可以写 dsn，也可以分字段写 host、port、user 等，两者只能选一种
+{"host":"127.0.0.1","port":3306,"user":"root","password":"***","database":"test","params":{"charset":"utf8mb4"},"tls_ca":"/etc/mysql/ca.pem"}
+*/
 type dbConn struct {
-	DSN     string `json:"dsn"`
-	MaxOpen int    `json:"max_open"`
-	MaxIdle int    `json:"max_idle"`
+	DSN             string            `json:"dsn"`
+	Host            string            `json:"host"`
+	Port            int               `json:"port"` //默认 3306
+	User            string            `json:"user"`
+	Password        string            `json:"password"`
+	Database        string            `json:"database"`
+	Params          map[string]string `json:"params"`        //DSN 参数，默认 charset=utf8mb4、parseTime=true、loc=Local
+	DialTimeout     config.Duration   `json:"dial_timeout"`  //建立连接的超时时间，对 dsn 也生效
+	ReadTimeout     config.Duration   `json:"read_timeout"`  //读超时，对 dsn 也生效
+	WriteTimeout    config.Duration   `json:"write_timeout"` //写超时，对 dsn 也生效
+	TLSCA           string            `json:"tls_ca"`        //CA 证书文件路径，配置后使用 TLS 连接，对 dsn 也生效
+	MaxOpen         int               `json:"max_open"`
+	MaxIdle         int               `json:"max_idle"`
+	ConnMaxLifetime config.Duration   `json:"conn_max_lifetime"`  //连接最长使用时间，默认 100s
+	ConnMaxIdleTime config.Duration   `json:"conn_max_idle_time"` //连接最长空闲时间，为 0 时不限制
+	PingTimeout     config.Duration   `json:"ping_timeout"`       //启动时每次 ping 的超时时间，为 0 时只受 ctx 控制
+	Retry           *retry.Policy     `json:"retry"`              //启动时 ping 的重试策略，为空时使用全局默认策略
+	Lazy            bool              `json:"lazy"`               //延迟连接，创建时不 ping
+	Breaker         *breaker.Config   `json:"breaker"`            //熔断配置，为空时不熔断，从库 fallback 为 master 时熔断后改用主库
+	GTIDWait        config.Duration   `json:"gtid_wait"`          //只对从库生效，WithSession 的读等待主库写入的 GTID 的最长时间，超时改读主库，为 0 时不等待
+	KillOnCancel    bool              `json:"kill_on_cancel"`     //ctx 取消时在新连接上执行 KILL QUERY，中止服务端仍在执行的语句
+	DeadlineHint    bool              `json:"deadline_hint"`      //ctx 有截止时间时给 SELECT 加 MAX_EXECUTION_TIME 提示
 }
 
 type mysqlConfig struct {
@@ -25,11 +54,18 @@ dbKey etcd存储的数据库连接字符串的key
 endpoints etcd的ip节点列表
 */
 func ConnByEtcd(dbKey string, endpoints ...string) (*sql.DB, *sql.DB, error) {
-	connStr, err := etcd.Conn(endpoints...).Get(dbKey)
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -40,11 +76,18 @@ etcdPass etcd密码
 endpoints etcd的ip节点列表
 */
 func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*sql.DB, *sql.DB, error) {
-	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).Get(dbKey)
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -53,11 +96,18 @@ env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
 dbKey etcd存储的数据库连接字符串的key
 */
 func ConnByEnv(env, dbKey string) (*sql.DB, *sql.DB, error) {
-	connStr, err := etcd.ConnByEnv(env).Get(dbKey)
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -67,50 +117,194 @@ maxOpen 最大打开连接
 maxIdle 最大闲置的连接数
 */
 func ConnByStr(dsn string, maxOpen, maxIdle int) (masterDB, slaveDB *sql.DB, err error) {
+	return ConnByStrCtx(context.Background(), dsn, maxOpen, maxIdle)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时 ping 的时间
+*/
+func ConnByStrCtx(ctx context.Context, dsn string, maxOpen, maxIdle int) (masterDB, slaveDB *sql.DB, err error) {
 	cfg := new(mysqlConfig)
 	cfg.Master.DSN = dsn
 	cfg.Master.MaxOpen = maxOpen
 	cfg.Master.MaxIdle = maxIdle
 	cfg.Slave = cfg.Master
-	return conn(cfg)
+	return conn(ctx, dsnName(dsn), cfg)
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (masterDB, slaveDB *sql.DB, err error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (masterDB, slaveDB *sql.DB, err error) {
+	return connByConnByte(ctx, name, data)
 }
 
-func connByConnByte(connByte []byte) (masterDB, slaveDB *sql.DB, err error) {
+func connByConnByte(ctx context.Context, name string, connByte []byte) (masterDB, slaveDB *sql.DB, err error) {
 	cfg := new(mysqlConfig)
-	if err := json.Unmarshal(connByte, cfg); err != nil {
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, nil, err
 	}
-	return conn(cfg)
+	return conn(ctx, name, cfg)
 }
 
-func conn(cfg *mysqlConfig) (masterDB, slaveDB *sql.DB, err error) {
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *mysqlConfig) (masterDB, slaveDB *sql.DB, err error) {
 	//主库
-	masterDB, err = sql.Open("mysql", cfg.Master.DSN)
+	master, err := newConnector(name+"/master", cfg.Master, nil)
 	if err != nil {
 		return nil, nil, err
 	}
-	masterDB.SetMaxOpenConns(cfg.Master.MaxOpen)
-	masterDB.SetMaxIdleConns(cfg.Master.MaxIdle)
-	masterDB.SetConnMaxLifetime(time.Second * 100)
-	if err = masterDB.Ping(); err != nil {
+	masterDB, err = open(ctx, master, cfg.Master)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	if cfg.Master.DSN == cfg.Slave.DSN {
-		slaveDB = masterDB
+	masters.Store(masterDB, masterDB)
+	if master.dsn == cfg.Slave.dsnKey() {
+		return masterDB, masterDB, nil
 	}
 
 	//从库
-	slaveDB, err = sql.Open("mysql", cfg.Slave.DSN)
+	slave, err := newConnector(name+"/slave", cfg.Slave, master)
 	if err != nil {
+		closeDB(masterDB)
 		return nil, nil, err
 	}
-	slaveDB.SetMaxOpenConns(cfg.Slave.MaxOpen)
-	slaveDB.SetMaxIdleConns(cfg.Slave.MaxIdle)
-	slaveDB.SetConnMaxLifetime(time.Second * 100)
-	if err = slaveDB.Ping(); err != nil {
+	slaveDB, err = open(ctx, slave, cfg.Slave)
+	if err != nil {
+		closeDB(masterDB)
 		return nil, nil, err
 	}
+	masters.Store(slaveDB, masterDB)
 
 	return
 }
+
+/*
+master 主库的 Connector，从库配置了熔断降级或 gtid_wait 时使用
+*/
+func newConnector(name string, cfg dbConn, master *connector) (*connector, error) {
+	dsnCfg, err := cfg.driverConfig()
+	if err != nil {
+		return nil, &dberr.ConfigError{Key: name, Fields: []dberr.FieldError{{Field: "dsn", Problem: err.Error()}}, Err: err}
+	}
+	mc, err := gomysql.NewConnector(dsnCfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &connector{Connector: mc, name: name, dsn: cfg.dsnKey(), kill: cfg.KillOnCancel, hint: cfg.DeadlineHint, interpolate: dsnCfg.InterpolateParams}
+	if master != nil && cfg.GTIDWait > 0 {
+		c.gtidWait, c.master = cfg.GTIDWait.Std(), master
+	}
+	if cfg.Breaker != nil {
+		c.breaker = breaker.New(name, *cfg.Breaker, isFailure)
+		if cfg.Breaker.Fallback == "master" {
+			c.fallback = master
+		}
+	}
+	return c, nil
+}
+
+func open(ctx context.Context, c *connector, cfg dbConn) (*sql.DB, error) {
+	name := c.name
+	db := sql.OpenDB(c)
+	db.SetMaxOpenConns(cfg.MaxOpen)
+	db.SetMaxIdleConns(cfg.MaxIdle)
+	db.SetConnMaxLifetime(cfg.connMaxLifetime())
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime.Std())
+	if err := ping(ctx, name, cfg, db); err != nil {
+		metrics.ConnectError(backend, name)
+		db.Close()
+		if c.breaker != nil {
+			breaker.Unregister(name)
+		}
+		return nil, dberr.Connect(name, err)
+	}
+	metrics.RegisterPool(backend, name, func() metrics.PoolStats {
+		s := db.Stats()
+		return metrics.PoolStats{
+			Open:         s.OpenConnections,
+			Idle:         s.Idle,
+			InUse:        s.InUse,
+			WaitCount:    s.WaitCount,
+			WaitDuration: s.WaitDuration,
+		}
+	})
+	health.Register(backend, name, db.PingContext)
+	instances.Store(db, name)
+	return db, nil
+}
+
+/*
+open 打开的连接池对应的实例名称，关闭时按名称取消注册
+*/
+var instances sync.Map
+
+/*
+关闭 conn 返回的主从连接池，主从相同时只关闭一次
+同时取消注册健康检查、连接池指标和熔断器
+*/
+func Close(masterDB, slaveDB *sql.DB) error {
+	var err error
+	for _, db := range []*sql.DB{masterDB, slaveDB} {
+		if db == nil {
+			continue
+		}
+		if e := closeDB(db); e != nil && err == nil {
+			err = e
+		}
+		if slaveDB == masterDB {
+			break
+		}
+	}
+	return err
+}
+
+/*
+关闭 open 打开的连接池，并取消注册健康检查、连接池指标、熔断器和 masters 中的记录
+*/
+func closeDB(db *sql.DB) error {
+	masters.Delete(db)
+	if name, ok := instances.LoadAndDelete(db); ok {
+		health.Unregister(name.(string))
+		metrics.UnregisterPool(backend, name.(string))
+		breaker.Unregister(name.(string))
+	}
+	return db.Close()
+}
+
+func ping(ctx context.Context, name string, cfg dbConn, db *sql.DB) error {
+	if cfg.Lazy {
+		return nil
+	}
+	return retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) error {
+		if cfg.PingTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.PingTimeout.Std())
+			defer cancel()
+		}
+		return db.PingContext(ctx)
+	})
+}
+
+/*
+以 DSN 中的地址和库名作为实例名称
+*/
+func dsnName(dsn string) string {
+	cfg, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		return backend
+	}
+	return cfg.Addr + "/" + cfg.DBName
+}
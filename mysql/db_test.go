@@ -0,0 +1,59 @@
+package mysql_test
+
+import (
+	"database/sql"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/mysql"
+	"testing"
+)
+
+/*
+主从都能执行查询后关闭
+*/
+func ping(master, slave *sql.DB, err error) error {
+	if err != nil {
+		return err
+	}
+	defer mysql.Close(master, slave)
+	for _, db := range []*sql.DB{master, slave} {
+		var n int
+		if err := db.QueryRow("SELECT 1").Scan(&n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: cmanydbtest.MySQLKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return ping(mysql.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return ping(mysql.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return ping(mysql.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	env := cmanydbtest.New(t)
+	master, slave, err := mysql.ConnByStr("root@tcp("+env.MySQLAddr+")/"+cmanydbtest.MySQLDatabase, 10, 5)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer mysql.Close(master, slave)
+	if master != slave {
+		t.Fatalf("ConnByStr returned different master and slave")
+	}
+	if got := master.Stats().MaxOpenConnections; got != 10 {
+		t.Fatalf("max open = %d, want 10", got)
+	}
+	if err := ping(master, slave, nil); err != nil {
+		t.Fatalf("SELECT 1: %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	gomysql "github.com/go-sql-driver/mysql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backend = "mysql"
+
+/*
+包装 go-sql-driver 的 Connector，统计每条语句的耗时，开启追踪时为每条语句创建 span
+配置了熔断时，熔断期间不再建立新连接，有降级的 Connector 时改用降级的连接
+*/
+type connector struct {
+	driver.Connector
+	name        string
+	dsn         string //不含密码的连接信息，用于判断主从是否相同
+	breaker     *breaker.Breaker
+	fallback    *connector
+	gtidWait    time.Duration //从库读之前等待会话 GTID 的最长时间，为 0 时不等待
+	master      *connector    //从库对应的主库，等待 GTID 超时时改读主库
+	kill        bool          //ctx 取消时在新连接上 KILL QUERY
+	hint        bool          //ctx 有截止时间时给 SELECT 加 MAX_EXECUTION_TIME 提示
+	interpolate bool          //DSN 开启了 interpolateParams，带参数的语句也直接执行
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.breaker != nil {
+		if err := c.breaker.Allow(); err != nil {
+			if c.fallback != nil {
+				return c.fallbackConnect(ctx)
+			}
+			return nil, err
+		}
+	}
+	start := time.Now()
+	cn, err := c.Connector.Connect(ctx)
+	metrics.Observe(backend, c.name, "CONNECT", start, err)
+	if c.breaker != nil {
+		c.breaker.ReportResult(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wc := &wrapConn{Conn: cn, name: c.name, breaker: c.breaker, gtidWait: c.gtidWait, master: c.master, hint: c.hint, interpolate: c.interpolate}
+	if c.kill {
+		//KILL QUERY 需要服务端的连接 id，建立连接时查询一次
+		id, err := queryValue(ctx, cn, "SELECT CONNECTION_ID()")
+		if err == nil {
+			wc.connID, err = strconv.ParseUint(id, 10, 64)
+		}
+		if err != nil {
+			cn.Close()
+			return nil, err
+		}
+		wc.killer = c.Connector
+	}
+	return wc, nil
+}
+
+/*
+熔断期间改用降级的连接，连接标记上本连接池的熔断器，熔断结束后不再复用，由 database/sql 丢弃
+*/
+func (c *connector) fallbackConnect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.fallback.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if wc, ok := cn.(*wrapConn); ok {
+		wc.fallbackFor = c.breaker
+	}
+	return cn, nil
+}
+
+type wrapConn struct {
+	driver.Conn
+	name        string
+	breaker     *breaker.Breaker
+	gtidWait    time.Duration
+	master      *connector
+	primary     driver.Conn //从库落后时使用的主库连接，随本连接关闭
+	synced      string      //本连接已确认执行过的 GTID 集合
+	inTx        bool
+	txWrote     bool             //事务中有写语句，提交后记录 GTID
+	hint        bool             //SELECT 加 MAX_EXECUTION_TIME 提示
+	connID      uint64           //服务端的连接 id，开启 kill_on_cancel 时有值
+	killer      driver.Connector //执行 KILL QUERY 的连接来源，不经过熔断
+	killed      bool             //KILL QUERY 可能在语句结束后才执行，连接不再复用
+	interpolate bool
+	fallbackFor *breaker.Breaker //熔断降级时借给从库连接池的主库连接所对应的从库熔断器
+}
+
+/*
+连接不能再复用：执行过 KILL QUERY，或者是降级借来的主库连接而从库的熔断已经结束
+半开时也不复用，新连接才会试探从库，否则熔断器一直没有机会恢复
+*/
+func (c *wrapConn) stale() bool {
+	return c.killed || c.fallbackFor != nil && c.fallbackFor.State() != breaker.StateOpen
+}
+
+/*
+驱动是否会对这条语句返回 driver.ErrSkip：没有开启 interpolateParams 时带参数的语句要走预处理
+提前返回 ErrSkip，避免为不会执行的调用创建 span
+*/
+func (c *wrapConn) skip(args []driver.NamedValue) bool {
+	return len(args) > 0 && !c.interpolate
+}
+
+/*
+开始一条语句，返回的函数在语句结束时调用
+熔断时返回 driver.ErrBadConn，database/sql 会丢弃该连接并重新获取，新连接由 Connector 决定熔断或降级
+*/
+func (c *wrapConn) start(ctx context.Context, command, query string) (context.Context, func(error), error) {
+	if c.breaker != nil && c.breaker.Allow() != nil {
+		return ctx, nil, driver.ErrBadConn
+	}
+	begin := time.Now()
+	statement := ""
+	if query != "" && tracing.Enabled() {
+		statement = tracing.SanitizeSQL(query)
+	}
+	ctx, span := tracing.Start(ctx, backend, c.name, command, statement)
+	return ctx, func(err error) {
+		if err == driver.ErrSkip {
+			//没有访问数据库，database/sql 会改用预处理语句重新执行，不计入熔断，span 不结束也就不会导出
+			if c.breaker != nil {
+				c.breaker.Release()
+			}
+			return
+		}
+		metrics.Observe(backend, c.name, command, begin, err)
+		if c.breaker != nil {
+			c.breaker.ReportResult(err)
+		}
+		tracing.End(span, err)
+	}, nil
+}
+
+func (c *wrapConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *wrapConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	ctx, end, err := c.start(ctx, "PREPARE", query)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrapStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *wrapConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *wrapConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	spanCtx, end, err := c.start(ctx, "BEGIN", "")
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(spanCtx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	c.inTx = true
+	return &wrapTx{Tx: tx, conn: c, ctx: ctx}, nil
+}
+
+func (c *wrapConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok || c.skip(args) {
+		return nil, driver.ErrSkip
+	}
+	ctx, end, err := c.start(ctx, command(query), query)
+	if err != nil {
+		return nil, err
+	}
+	stop := c.watchCancel(ctx)
+	res, err = e.ExecContext(ctx, query, args)
+	stop()
+	end(err)
+	if err == nil {
+		c.captureGTID(ctx, query)
+	}
+	return
+}
+
+func (c *wrapConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok || c.skip(args) {
+		return nil, driver.ErrSkip
+	}
+	query = c.deadlineHint(ctx, query)
+	if !c.waitGTID(ctx) {
+		return c.queryMaster(ctx, query, args)
+	}
+	ctx, end, err := c.start(ctx, command(query), query)
+	if err != nil {
+		return nil, err
+	}
+	stop := c.watchCancel(ctx)
+	rows, err = q.QueryContext(ctx, query, args)
+	end(err)
+	return stopOnClose(rows, err, stop)
+}
+
+func (c *wrapConn) Close() error {
+	if c.primary != nil {
+		c.primary.Close()
+	}
+	return c.Conn.Close()
+}
+
+func (c *wrapConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrapConn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrapConn) IsValid() bool {
+	if c.stale() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *wrapConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type wrapStmt struct {
+	driver.Stmt
+	conn  *wrapConn
+	query string
+}
+
+func (s *wrapStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, end, err := s.conn.start(context.Background(), command(s.query), s.query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.Stmt.Exec(args)
+	end(err)
+	return res, err
+}
+
+func (s *wrapStmt) Query(args []driver.Value) (driver.Rows, error) {
+	_, end, err := s.conn.start(context.Background(), command(s.query), s.query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.Stmt.Query(args)
+	end(err)
+	return rows, err
+}
+
+func (s *wrapStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, end, err := s.conn.start(ctx, command(s.query), s.query)
+	if err != nil {
+		return nil, err
+	}
+	stop := s.conn.watchCancel(ctx)
+	res, err := e.ExecContext(ctx, args)
+	stop()
+	end(err)
+	if err == nil {
+		s.conn.captureGTID(ctx, s.query)
+	}
+	return res, err
+}
+
+func (s *wrapStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if !s.conn.waitGTID(ctx) {
+		return s.conn.queryMaster(ctx, s.query, args)
+	}
+	ctx, end, err := s.conn.start(ctx, command(s.query), s.query)
+	if err != nil {
+		return nil, err
+	}
+	stop := s.conn.watchCancel(ctx)
+	rows, err := q.QueryContext(ctx, args)
+	end(err)
+	return stopOnClose(rows, err, stop)
+}
+
+func (s *wrapStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+type wrapTx struct {
+	driver.Tx
+	conn *wrapConn
+	ctx  context.Context //BeginTx 的 ctx，提交和回滚的 span 挂在它下面
+}
+
+/*
+提交和回滚不受熔断限制，避免事务悬挂
+*/
+func (t *wrapTx) Commit() error {
+	begin := time.Now()
+	_, span := tracing.Start(t.ctx, backend, t.conn.name, "COMMIT", "")
+	err := t.Tx.Commit()
+	metrics.Observe(backend, t.conn.name, "COMMIT", begin, err)
+	tracing.End(span, err)
+	wrote := t.conn.txWrote
+	t.conn.inTx, t.conn.txWrote = false, false
+	if err == nil && wrote {
+		t.conn.captureGTID(t.ctx, "COMMIT")
+	}
+	return err
+}
+
+func (t *wrapTx) Rollback() error {
+	begin := time.Now()
+	_, span := tracing.Start(t.ctx, backend, t.conn.name, "ROLLBACK", "")
+	err := t.Tx.Rollback()
+	t.conn.inTx, t.conn.txWrote = false, false
+	metrics.Observe(backend, t.conn.name, "ROLLBACK", begin, err)
+	tracing.End(span, err)
+	return err
+}
+
+/*
+取语句的第一个关键字作为命令名称，避免把整条 SQL 作为指标标签
+*/
+func command(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n(;")
+	if end > 0 {
+		query = query[:end]
+	}
+	if query == "" || len(query) > 16 {
+		return "OTHER"
+	}
+	for _, r := range query {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return "OTHER"
+		}
+	}
+	return strings.ToUpper(query)
+}
+
+/*
+服务端返回的错误说明数据库可用，不计入熔断失败
+*/
+func isFailure(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return false
+	}
+	return errors.Is(err, gomysql.ErrInvalidConn) || breaker.IsConnError(err)
+}
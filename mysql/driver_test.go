@@ -0,0 +1,58 @@
+package mysql_test
+
+import (
+	"encoding/json"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/mysql"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSlaveFallback(t *testing.T) {
+	env := cmanydbtest.New(t)
+	proxy := cmanydbtest.NewProxy(t, env.MySQLAddr)
+	node := func(addr string) map[string]interface{} {
+		host, port, _ := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+		return map[string]interface{}{"host": host, "port": n, "user": "root", "database": cmanydbtest.MySQLDatabase, "max_open": 1, "max_idle": 1}
+	}
+	slaveCfg := node(proxy.Addr())
+	slaveCfg["breaker"] = map[string]interface{}{"failure_threshold": 1, "cool_down": "200ms", "fallback": "master"}
+	data, _ := json.Marshal(map[string]interface{}{"master": node(env.MySQLAddr), "slave": slaveCfg})
+	master, slave, err := mysql.ConnByJSON("fallback", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mysql.Close(master, slave)
+
+	connID := func() int64 {
+		t.Helper()
+		var id int64
+		if err := slave.QueryRow("SELECT CONNECTION_ID()").Scan(&id); err != nil {
+			t.Fatalf("slave query: %v", err)
+		}
+		return id
+	}
+	connID()
+
+	//从库宕机后熔断，改用主库的连接
+	proxy.SetDown(true)
+	slave.QueryRow("SELECT 1").Scan(new(int))
+	if s := breaker.Get("fallback/slave").State(); s != breaker.StateOpen {
+		t.Fatalf("slave breaker = %v, want open", s)
+	}
+	fallbackID := connID()
+
+	//从库恢复、冷却结束后不再复用借来的主库连接，改回从库
+	proxy.SetDown(false)
+	time.Sleep(300 * time.Millisecond)
+	if id := connID(); id == fallbackID {
+		t.Fatalf("slave pool still uses the master connection %d after recovery", id)
+	}
+	if s := breaker.Get("fallback/slave").State(); s != breaker.StateClosed {
+		t.Fatalf("slave breaker = %v, want closed", s)
+	}
+}
@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+/*
+mysql 错误码
+*/
+const (
+	errAccessDenied     = 1045
+	errDBAccessDenied   = 1044
+	errDupEntry         = 1062
+	errDupEntryAutoInc  = 1586
+	errLockWaitTimeout  = 1205
+	errDeadlock         = 1213
+	errMaxExecutionTime = 3024
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		Timeout: func(err error) bool {
+			code := errCode(err)
+			return code == errLockWaitTimeout || code == errMaxExecutionTime
+		},
+		DuplicateKey: func(err error) bool {
+			code := errCode(err)
+			return code == errDupEntry || code == errDupEntryAutoInc
+		},
+		Auth: func(err error) bool {
+			code := errCode(err)
+			return code == errAccessDenied || code == errDBAccessDenied
+		},
+		Retryable: func(err error) bool {
+			return errors.Is(err, gomysql.ErrInvalidConn) || errCode(err) == errDeadlock
+		},
+	})
+}
+
+func errCode(err error) uint16 {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/chu108/cmany_db/logger"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+读己之写的会话，记录会话中最近一次写入后主库已执行的 GTID 集合
+*/
+type session struct {
+	mu   sync.Mutex
+	gtid string
+}
+
+type sessionKey struct{}
+
+/*
+开启读己之写，返回的 ctx 在主库写入（事务在提交）后记录 GTID
+从库配置了 gtid_wait 时，用该 ctx 读从库前先等待从库执行到这个 GTID，超时或出错时改读主库
+ctx 中已有会话时直接返回，一个会话通常对应一次请求
+*/
+func WithSession(ctx context.Context) context.Context {
+	if sessionFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey{}, new(session))
+}
+
+/*
+恢复会话，gtid 为上一次请求 SessionGTID 的结果（如放在 cookie 中），用于跨请求的读己之写
+*/
+func WithSessionGTID(ctx context.Context, gtid string) context.Context {
+	ctx = WithSession(ctx)
+	if validGTID(gtid) {
+		sessionFrom(ctx).set(gtid)
+	}
+	return ctx
+}
+
+/*
+会话中记录的 GTID 集合，没有会话或还没有写入时返回空字符串
+*/
+func SessionGTID(ctx context.Context) string {
+	s := sessionFrom(ctx)
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gtid
+}
+
+func sessionFrom(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+func (s *session) set(gtid string) {
+	s.mu.Lock()
+	s.gtid = gtid
+	s.mu.Unlock()
+}
+
+/*
+写语句成功后记录主库的 gtid_executed，事务中的写语句在提交后记录
+主库的 gtid_executed 只增不减，包含了本次写入，直接覆盖会话中的值
+*/
+func (c *wrapConn) captureGTID(ctx context.Context, query string) {
+	if !isWrite(query) {
+		return
+	}
+	if c.inTx {
+		c.txWrote = true
+		return
+	}
+	s := sessionFrom(ctx)
+	if s == nil {
+		return
+	}
+	gtid, err := queryValue(ctx, c.Conn, "SELECT @@GLOBAL.gtid_executed")
+	if err != nil {
+		logger.For(c.name).Warn("capture gtid failed", logger.F("error", err.Error()))
+		return
+	}
+	gtid = strings.ReplaceAll(gtid, "\n", "")
+	if gtid != "" {
+		s.set(gtid)
+	}
+}
+
+/*
+从库读之前等待会话的 GTID，返回 false 时从库落后或不支持 GTID，需要改读主库
+事务中不等待，避免同一事务的语句分散到主从两个库
+*/
+func (c *wrapConn) waitGTID(ctx context.Context) bool {
+	if c.gtidWait <= 0 || c.master == nil || c.inTx {
+		return true
+	}
+	gtid := SessionGTID(ctx)
+	if gtid == "" || gtid == c.synced {
+		return true
+	}
+	if !validGTID(gtid) {
+		return false
+	}
+	//GTID 集合只含字母、数字和分隔符，可以直接拼进语句，避免驱动因参数改走预处理
+	query := fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %s)", gtid,
+		strconv.FormatFloat(c.gtidWait.Seconds(), 'f', -1, 64))
+	ctx, end, err := c.start(ctx, "GTID_WAIT", "")
+	if err != nil {
+		return false
+	}
+	result, err := queryValue(ctx, c.Conn, query)
+	end(err)
+	if err != nil {
+		logger.For(c.name).Warn("wait gtid failed, reading from master", logger.F("error", err.Error()))
+		return false
+	}
+	if result != "0" {
+		logger.For(c.name).Debug("replica behind, reading from master", logger.F("gtid", gtid))
+		return false
+	}
+	c.synced = gtid
+	return true
+}
+
+/*
+在主库连接上执行查询，连接在第一次使用时建立，之后复用到本连接关闭
+database/sql 在 rows 关闭前不会复用本连接，所以同一时间只有一个查询使用它
+*/
+func (c *wrapConn) queryMaster(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.primary == nil {
+		cn, err := c.master.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.primary = cn
+	}
+	rows, err := c.queryPrimary(ctx, query, args)
+	if err == driver.ErrBadConn {
+		c.primary.Close()
+		c.primary = nil
+	}
+	return rows, err
+}
+
+func (c *wrapConn) queryPrimary(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.primary.(driver.QueryerContext); ok {
+		rows, err := q.QueryContext(ctx, query, args)
+		if err != driver.ErrSkip {
+			return rows, err
+		}
+	}
+	//有参数时驱动要求走预处理语句，rows 关闭时一起关闭语句
+	p, ok := c.primary.(driver.ConnPrepareContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	q, ok := stmt.(driver.StmtQueryContext)
+	if !ok {
+		stmt.Close()
+		return nil, driver.ErrSkip
+	}
+	rows, err := q.QueryContext(ctx, args)
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return &wrapRows{Rows: rows, onClose: func() { stmt.Close() }}, nil
+}
+
+/*
+执行不带参数的查询，返回第一行第一列
+*/
+func queryValue(ctx context.Context, cn driver.Conn, query string) (string, error) {
+	q, ok := cn.(driver.QueryerContext)
+	if !ok {
+		return "", driver.ErrSkip
+	}
+	rows, err := q.QueryContext(ctx, query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+	switch v := dest[0].(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		return string(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+/*
+不改变数据的语句不需要记录 GTID
+*/
+func isWrite(query string) bool {
+	switch command(query) {
+	case "SELECT", "SHOW", "SET", "USE", "DO", "EXPLAIN", "DESCRIBE", "DESC", "SAVEPOINT", "RELEASE", "ROLLBACK":
+		return false
+	}
+	return true
+}
+
+func validGTID(gtid string) bool {
+	if gtid == "" {
+		return false
+	}
+	for _, r := range gtid {
+		switch {
+		//8.3 之后的 GTID 可以带字母、数字和下划线组成的标签
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r == '-', r == '_', r == ':', r == ',', r == ' ', r == '\n':
+		default:
+			return false
+		}
+	}
+	return true
+}
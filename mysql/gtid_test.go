@@ -0,0 +1,144 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/chu108/cmany_db/fake"
+	"testing"
+	"time"
+)
+
+const (
+	testGTID  = "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5"
+	waitQuery = "SELECT WAIT_FOR_EXECUTED_GTID_SET('" + testGTID + "', 1)"
+)
+
+/*
+主从都是 fake.SQL，从库等待 GTID 超时或出错时改读主库
+*/
+func newGTIDDB(t *testing.T) (master, slave *sql.DB, masterFake, slaveFake *fake.SQL) {
+	_, masterFake = fake.NewSQL()
+	_, slaveFake = fake.NewSQL()
+	masterConn := &connector{Connector: masterFake, name: "master"}
+	slaveConn := &connector{Connector: slaveFake, name: "slave", gtidWait: time.Second, master: masterConn}
+	master, slave = sql.OpenDB(masterConn), sql.OpenDB(slaveConn)
+	slave.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		master.Close()
+		slave.Close()
+	})
+
+	masterFake.OnExec("INSERT INTO t VALUES (1)", 1, 1)
+	masterFake.OnQuery("SELECT @@GLOBAL.gtid_executed", []string{"gtid"}, []interface{}{testGTID})
+	masterFake.OnQuery("SELECT v FROM t", []string{"v"}, []interface{}{"master"})
+	slaveFake.OnQuery("SELECT v FROM t", []string{"v"}, []interface{}{"slave"})
+	return
+}
+
+func TestCaptureGTID(t *testing.T) {
+	master, _, _, _ := newGTIDDB(t)
+
+	//没有会话时不记录
+	if _, err := master.ExecContext(context.Background(), "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithSession(context.Background())
+	var v string
+	if err := master.QueryRowContext(ctx, "SELECT v FROM t").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if got := SessionGTID(ctx); got != "" {
+		t.Fatalf("GTID after SELECT = %q, want empty", got)
+	}
+	if _, err := master.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if got := SessionGTID(ctx); got != testGTID {
+		t.Fatalf("GTID after INSERT = %q, want %q", got, testGTID)
+	}
+
+	//事务中的写语句在提交后记录
+	ctx = WithSession(context.Background())
+	tx, err := master.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if got := SessionGTID(ctx); got != "" {
+		t.Fatalf("GTID before COMMIT = %q, want empty", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := SessionGTID(ctx); got != testGTID {
+		t.Fatalf("GTID after COMMIT = %q, want %q", got, testGTID)
+	}
+}
+
+func TestWaitGTID(t *testing.T) {
+	tests := []struct {
+		name   string
+		gtid   string
+		wait   interface{} //WAIT_FOR_EXECUTED_GTID_SET 的结果，为 error 时语句出错
+		want   string
+		waited bool
+	}{
+		{name: "no session gtid", want: "slave"},
+		{name: "replica caught up", gtid: testGTID, wait: 0, want: "slave", waited: true},
+		{name: "replica behind", gtid: testGTID, wait: 1, want: "master", waited: true},
+		{name: "wait failed", gtid: testGTID, wait: errors.New("gtid_mode is OFF"), want: "master", waited: true},
+		{name: "invalid gtid is ignored", gtid: "x'); DROP TABLE t; --", want: "slave"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, slave, _, slaveFake := newGTIDDB(t)
+			switch v := tt.wait.(type) {
+			case nil:
+			case error:
+				slaveFake.OnError(waitQuery, v)
+			default:
+				slaveFake.OnQuery(waitQuery, []string{"r"}, []interface{}{v})
+			}
+
+			ctx := WithSessionGTID(context.Background(), tt.gtid)
+			var got string
+			if err := slave.QueryRowContext(ctx, "SELECT v FROM t").Scan(&got); err != nil || got != tt.want {
+				t.Fatalf("read = %q, %v, want %q", got, err, tt.want)
+			}
+			if waited := waitCount(slaveFake) > 0; waited != tt.waited {
+				t.Fatalf("waited = %v, want %v", waited, tt.waited)
+			}
+		})
+	}
+}
+
+func TestWaitGTIDOncePerConn(t *testing.T) {
+	_, slave, _, slaveFake := newGTIDDB(t)
+	slaveFake.OnQuery(waitQuery, []string{"r"}, []interface{}{0})
+
+	//同一连接确认过的 GTID 不再等待
+	ctx := WithSessionGTID(context.Background(), testGTID)
+	for i := 0; i < 3; i++ {
+		var got string
+		if err := slave.QueryRowContext(ctx, "SELECT v FROM t").Scan(&got); err != nil || got != "slave" {
+			t.Fatalf("read = %q, %v, want slave", got, err)
+		}
+	}
+	if n := waitCount(slaveFake); n != 1 {
+		t.Fatalf("waited %d times, want 1", n)
+	}
+}
+
+func waitCount(s *fake.SQL) int {
+	n := 0
+	for _, stmt := range s.Statements() {
+		if stmt.Query == waitQuery {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,16 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+)
+
+/*
+应用代码用到的 SQL 查询和执行，*sql.DB、*sql.Tx、*sql.Conn 都满足
+单元测试中可以用 fake.NewSQL() 返回的 *sql.DB 代替，不需要连接数据库
+*/
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
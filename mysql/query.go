@@ -0,0 +1,419 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+单条语句最多 65535 个占位符
+*/
+const maxPlaceholders = 65535
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+/*
+读语句的执行对象：ctx 中有同一主库的事务时在事务中执行，否则在 db（通常是从库）上执行
+*/
+func reader(ctx context.Context, db *sql.DB) querier {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok && st.master == Master(db) {
+		return st.tx
+	}
+	return db
+}
+
+/*
+写语句的执行对象：ctx 中有同一主库的事务时在事务中执行，否则在主库上执行
+*/
+func writer(ctx context.Context, db *sql.DB) querier {
+	master := Master(db)
+	if st, ok := ctx.Value(txKey{}).(*txState); ok && st.master == master {
+		return st.tx
+	}
+	return master
+}
+
+/*
+查询多行并扫描为 T，T 为结构体时按 db 标签对应列名，否则只能查询一列
+db 传从库，语句在从库上执行，WithTx 中调用时在事务中执行
+query 支持命名参数 :name（args 为一个 map[string]interface{} 或结构体）和切片参数展开为 IN (?, ?)
+*/
+func QueryStructs[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]T, error) {
+	query, args, err := bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := reader(ctx, db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows[T](rows)
+}
+
+/*
+查询一行，没有数据时返回 sql.ErrNoRows，参数同 QueryStructs
+*/
+func QueryOne[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) (T, error) {
+	var zero T
+	items, err := QueryStructs[T](ctx, db, query, args...)
+	if err != nil {
+		return zero, err
+	}
+	if len(items) == 0 {
+		return zero, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+/*
+执行写语句，db 为从库时自动换成主库，参数同 QueryStructs
+*/
+func Exec(ctx context.Context, db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return writer(ctx, db).ExecContext(ctx, query, args...)
+}
+
+/*
+批量插入，按 max_allowed_packet 和占位符数量拆分为多条 INSERT，返回插入的行数
+table 表名，rows 中的结构体按 db 标签对应列名，db 为从库时自动换成主库
+不在事务中时各批次单独提交，需要整体成功时在 WithTx 中调用
+*/
+func InsertMany[T any](ctx context.Context, db *sql.DB, table string, rows []T) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	t := reflect.TypeOf(rows[0])
+	if t.Kind() != reflect.Struct {
+		return 0, fmt.Errorf("mysql: InsertMany needs struct rows, got %s", t)
+	}
+	fm := fieldsOf(t)
+	if len(fm.columns) == 0 {
+		return 0, fmt.Errorf("mysql: %s has no columns", t)
+	}
+	limit, err := maxPacket(ctx, Master(db))
+	if err != nil {
+		return 0, err
+	}
+	//留出语句本身和协议头的余量
+	limit = limit * 9 / 10
+
+	head := "INSERT INTO " + quoteIdent(table) + " (" + joinIdents(fm.columns) + ") VALUES "
+	rowSQL := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(fm.columns)), ", ") + ")"
+	w := writer(ctx, db)
+
+	var total int64
+	var args []interface{}
+	var n, size int
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		query := head + strings.TrimSuffix(strings.Repeat(rowSQL+", ", n), ", ")
+		res, err := w.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, _ := res.RowsAffected()
+		total += affected
+		args, n, size = args[:0], 0, 0
+		return nil
+	}
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		rowArgs := make([]interface{}, len(fm.columns))
+		rowSize := len(rowSQL) + 2
+		for i, col := range fm.columns {
+			rowArgs[i] = fieldByIndex(v, fm.index[col]).Interface()
+			rowSize += argSize(rowArgs[i])
+		}
+		if n > 0 && (size+rowSize > limit || len(args)+len(rowArgs) > maxPlaceholders) {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+		args = append(args, rowArgs...)
+		size += rowSize
+		n++
+	}
+	return total, flush()
+}
+
+/*
+各主库的 max_allowed_packet
+*/
+var packets sync.Map
+
+func maxPacket(ctx context.Context, master *sql.DB) (int, error) {
+	if v, ok := packets.Load(master); ok {
+		return v.(int), nil
+	}
+	var size int
+	if err := master.QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&size); err != nil {
+		return 0, err
+	}
+	packets.Store(master, size)
+	return size, nil
+}
+
+/*
+估算参数的字节数，和文本协议中的长度接近
+*/
+func argSize(arg interface{}) int {
+	if valuer, ok := arg.(driver.Valuer); ok {
+		if v, err := valuer.Value(); err == nil {
+			arg = v
+		}
+	}
+	switch v := arg.(type) {
+	case nil:
+		return 4
+	case string:
+		return len(v) + 2
+	case []byte:
+		return len(v) + 2
+	default:
+		return len(fmt.Sprint(v)) + 2
+	}
+}
+
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.ReplaceAll(p, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+func joinIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+/*
+处理命名参数和切片参数
+一个 map 或结构体参数且语句中有 :name 时按名称绑定，之后把切片参数展开为多个占位符，[]byte 不展开
+*/
+func bind(query string, args []interface{}) (string, []interface{}, error) {
+	if len(args) == 1 && isNamedSource(args[0]) {
+		var err error
+		query, args, err = bindNamed(query, args[0])
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return expandIn(query, args)
+}
+
+func isNamedSource(arg interface{}) bool {
+	if _, ok := arg.(driver.Valuer); ok {
+		return false
+	}
+	t := reflect.TypeOf(arg)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String ||
+		t.Kind() == reflect.Struct && !isScanner(t) && t.PkgPath() != "time"
+}
+
+func bindNamed(query string, src interface{}) (string, []interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(src))
+	lookup := func(name string) (interface{}, bool) {
+		if v.Kind() == reflect.Map {
+			value := v.MapIndex(reflect.ValueOf(name))
+			if !value.IsValid() {
+				return nil, false
+			}
+			return value.Interface(), true
+		}
+		index, ok := fieldsOf(v.Type()).index[name]
+		if !ok {
+			return nil, false
+		}
+		return fieldByIndex(v, index).Interface(), true
+	}
+
+	var b strings.Builder
+	var args []interface{}
+	err := walkSQL(query, func(c byte, rest string) (int, bool) {
+		//:: 是类型转换，不是参数
+		if strings.HasPrefix(rest, "::") {
+			return 2, false
+		}
+		if c != ':' {
+			return 0, false
+		}
+		end := 1
+		for end < len(rest) && isIdentByte(rest[end]) {
+			end++
+		}
+		if end == 1 {
+			return 0, false
+		}
+		return end, true
+	}, func(token string, mark bool) error {
+		if !mark {
+			b.WriteString(token)
+			return nil
+		}
+		name := token[1:]
+		value, ok := lookup(name)
+		if !ok {
+			return fmt.Errorf("mysql: missing named parameter %q", name)
+		}
+		b.WriteByte('?')
+		args = append(args, value)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return b.String(), args, nil
+}
+
+func expandIn(query string, args []interface{}) (string, []interface{}, error) {
+	expand := false
+	for _, arg := range args {
+		if isExpandable(arg) {
+			expand = true
+			break
+		}
+	}
+	if !expand {
+		return query, args, nil
+	}
+
+	var b strings.Builder
+	var out []interface{}
+	i := 0
+	err := walkSQL(query, func(c byte, rest string) (int, bool) {
+		return 1, c == '?'
+	}, func(token string, mark bool) error {
+		if !mark {
+			b.WriteString(token)
+			return nil
+		}
+		if i >= len(args) {
+			return errors.New("mysql: not enough arguments for placeholders")
+		}
+		arg := args[i]
+		i++
+		if !isExpandable(arg) {
+			b.WriteByte('?')
+			out = append(out, arg)
+			return nil
+		}
+		v := reflect.ValueOf(arg)
+		if v.Len() == 0 {
+			//空列表时 IN (NULL) 不匹配任何行
+			b.WriteString("NULL")
+			return nil
+		}
+		b.WriteString(strings.TrimSuffix(strings.Repeat("?, ", v.Len()), ", "))
+		for j := 0; j < v.Len(); j++ {
+			out = append(out, v.Index(j).Interface())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return b.String(), append(out, args[i:]...), nil
+}
+
+func isExpandable(arg interface{}) bool {
+	if _, ok := arg.(driver.Valuer); ok {
+		return false
+	}
+	if _, ok := arg.([]byte); ok {
+		return false
+	}
+	t := reflect.TypeOf(arg)
+	return t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+/*
+逐段遍历语句，跳过引号和注释中的内容
+match 判断当前位置是否是需要替换的标记，返回标记长度或需要跳过的长度，emit 依次收到普通文本和标记，mark 为 true 时是标记
+*/
+func walkSQL(query string, match func(c byte, rest string) (int, bool), emit func(token string, mark bool) error) error {
+	start := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			for j := i + 1; j < len(query); j++ {
+				if query[j] == '\\' && c != '`' {
+					j++
+					continue
+				}
+				if query[j] == c {
+					i = j
+					break
+				}
+				if j == len(query)-1 {
+					i = j
+				}
+			}
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "-- ") || c == '#':
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+			continue
+		}
+		n, ok := match(c, query[i:])
+		if !ok {
+			//n 大于 1 时跳过这段不是标记的内容
+			if n > 1 {
+				i += n - 1
+			}
+			continue
+		}
+		if start < i {
+			if err := emit(query[start:i], false); err != nil {
+				return err
+			}
+		}
+		if err := emit(query[i:i+n], true); err != nil {
+			return err
+		}
+		i += n - 1
+		start = i + 1
+	}
+	if start < len(query) {
+		return emit(query[start:], false)
+	}
+	return nil
+}
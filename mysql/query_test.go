@@ -0,0 +1,208 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type user struct {
+	UserID int
+	Name   string `db:"user_name"`
+	Secret string `db:"-"`
+}
+
+func TestBind(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		want  string
+		out   []interface{}
+		err   bool
+	}{
+		{
+			name:  "positional",
+			query: "SELECT * FROM t WHERE a = ? AND b = ?",
+			args:  []interface{}{1, "x"},
+			want:  "SELECT * FROM t WHERE a = ? AND b = ?",
+			out:   []interface{}{1, "x"},
+		},
+		{
+			name:  "named map",
+			query: "SELECT * FROM t WHERE a = :a AND b = :b AND c = :a",
+			args:  []interface{}{map[string]interface{}{"a": 1, "b": 2}},
+			want:  "SELECT * FROM t WHERE a = ? AND b = ? AND c = ?",
+			out:   []interface{}{1, 2, 1},
+		},
+		{
+			name:  "named struct",
+			query: "UPDATE t SET user_name = :user_name WHERE user_id = :user_id",
+			args:  []interface{}{&user{UserID: 7, Name: "n"}},
+			want:  "UPDATE t SET user_name = ? WHERE user_id = ?",
+			out:   []interface{}{"n", 7},
+		},
+		{
+			name:  "ignored field",
+			query: "SELECT :secret",
+			args:  []interface{}{user{}},
+			err:   true,
+		},
+		{
+			name:  "missing named parameter",
+			query: "SELECT :a, :b",
+			args:  []interface{}{map[string]interface{}{"a": 1}},
+			err:   true,
+		},
+		{
+			name:  "names in quotes, comments and casts",
+			query: "SELECT ':a', `:a`, a::int /* :a */, :a -- :a",
+			args:  []interface{}{map[string]interface{}{"a": 1}},
+			want:  "SELECT ':a', `:a`, a::int /* :a */, ? -- :a",
+			out:   []interface{}{1},
+		},
+		{
+			name:  "time is not a named source",
+			query: "SELECT ?",
+			args:  []interface{}{at},
+			want:  "SELECT ?",
+			out:   []interface{}{at},
+		},
+		{
+			name:  "named slice expands",
+			query: "SELECT * FROM t WHERE id IN (:ids)",
+			args:  []interface{}{map[string]interface{}{"ids": []int{1, 2}}},
+			want:  "SELECT * FROM t WHERE id IN (?, ?)",
+			out:   []interface{}{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, out, err := bind(tt.query, tt.args)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("bind = %q, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want || !reflect.DeepEqual(out, tt.out) {
+				t.Fatalf("bind = %q, %v, %v, want %q, %v", got, out, err, tt.want, tt.out)
+			}
+		})
+	}
+}
+
+func TestExpandIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		want  string
+		out   []interface{}
+		err   bool
+	}{
+		{
+			name:  "slice",
+			query: "SELECT * FROM t WHERE id IN (?) AND s = ?",
+			args:  []interface{}{[]int{1, 2, 3}, "x"},
+			want:  "SELECT * FROM t WHERE id IN (?, ?, ?) AND s = ?",
+			out:   []interface{}{1, 2, 3, "x"},
+		},
+		{
+			name:  "array",
+			query: "SELECT * FROM t WHERE id IN (?)",
+			args:  []interface{}{[2]string{"a", "b"}},
+			want:  "SELECT * FROM t WHERE id IN (?, ?)",
+			out:   []interface{}{"a", "b"},
+		},
+		{
+			name:  "empty slice",
+			query: "SELECT * FROM t WHERE id IN (?)",
+			args:  []interface{}{[]int{}},
+			want:  "SELECT * FROM t WHERE id IN (NULL)",
+			out:   nil,
+		},
+		{
+			name:  "bytes are not expanded",
+			query: "SELECT * FROM t WHERE b = ? AND id IN (?)",
+			args:  []interface{}{[]byte("ab"), []int{1}},
+			want:  "SELECT * FROM t WHERE b = ? AND id IN (?)",
+			out:   []interface{}{[]byte("ab"), 1},
+		},
+		{
+			name:  "placeholders in quotes and comments",
+			query: "SELECT '?', \"?\", 'it\\'s ?' # ?\n, ? /* ? */",
+			args:  []interface{}{[]int{1, 2}},
+			want:  "SELECT '?', \"?\", 'it\\'s ?' # ?\n, ?, ? /* ? */",
+			out:   []interface{}{1, 2},
+		},
+		{
+			name:  "not enough arguments",
+			query: "SELECT ?, ?",
+			args:  []interface{}{[]int{1}},
+			err:   true,
+		},
+		{
+			name:  "no slices keeps the query",
+			query: "SELECT ?, ?",
+			args:  []interface{}{1},
+			want:  "SELECT ?, ?",
+			out:   []interface{}{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, out, err := expandIn(tt.query, tt.args)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expandIn = %q, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want || !reflect.DeepEqual(out, tt.out) {
+				t.Fatalf("expandIn = %q, %v, %v, want %q, %v", got, out, err, tt.want, tt.out)
+			}
+		})
+	}
+}
+
+func TestWalkSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		marks []string
+	}{
+		{"plain", "SELECT ?, ?", []string{"?", "?"}},
+		{"single quotes", "SELECT '?', ?", []string{"?"}},
+		{"escaped quote", `SELECT 'a\'?', ?`, []string{"?"}},
+		{"backquotes do not escape", "SELECT `a\\`, ?", []string{"?"}},
+		{"double dash needs a space", "SELECT 1 --?\n-- ?\n?", []string{"?", "?"}},
+		{"hash comment", "SELECT 1 # ?\n?", []string{"?"}},
+		{"block comment", "SELECT /* ? */ ?", []string{"?"}},
+		{"unterminated quote", "SELECT '?", nil},
+		{"unterminated comment", "SELECT /* ?", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var marks []string
+			var text string
+			err := walkSQL(tt.query, func(c byte, rest string) (int, bool) {
+				return 1, c == '?'
+			}, func(token string, mark bool) error {
+				text += token
+				if mark {
+					marks = append(marks, token)
+				}
+				return nil
+			})
+			if err != nil || !reflect.DeepEqual(marks, tt.marks) {
+				t.Fatalf("marks = %q, %v, want %q", marks, err, tt.marks)
+			}
+			//普通文本和标记拼起来是原语句
+			if text != tt.query {
+				t.Fatalf("tokens = %q, want %q", text, tt.query)
+			}
+		})
+	}
+}
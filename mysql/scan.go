@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+结构体字段和列名的对应关系，列名取 db 标签，没有标签时为字段名的蛇形写法，db:"-" 忽略
+匿名嵌入的结构体字段展开
+*/
+type fieldMap struct {
+	columns []string
+	index   map[string][]int
+}
+
+var fieldMaps sync.Map
+
+func fieldsOf(t reflect.Type) *fieldMap {
+	if fm, ok := fieldMaps.Load(t); ok {
+		return fm.(*fieldMap)
+	}
+	fm := &fieldMap{index: make(map[string][]int)}
+	collectFields(fm, t, nil)
+	fieldMaps.Store(t, fm)
+	return fm
+}
+
+func collectFields(fm *fieldMap, t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		index := append(append([]int(nil), parent...), i)
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			collectFields(fm, f.Type, index)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = snakeCase(f.Name)
+		}
+		if _, ok := fm.index[name]; ok {
+			continue
+		}
+		fm.columns = append(fm.columns, name)
+		fm.index[name] = index
+	}
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+/*
+扫描所有行，T 为结构体时按列名填充字段，否则只能有一列
+*/
+func scanRows[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	t := reflect.TypeOf(&zero).Elem()
+	isStruct := t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{}) && !isScanner(t)
+	if !isStruct && len(columns) != 1 {
+		return nil, fmt.Errorf("mysql: scan %d columns into %s", len(columns), t)
+	}
+	var fm *fieldMap
+	if isStruct {
+		fm = fieldsOf(t)
+	}
+	var result []T
+	for rows.Next() {
+		var item T
+		v := reflect.ValueOf(&item).Elem()
+		dest := make([]interface{}, len(columns))
+		if isStruct {
+			for i, col := range columns {
+				index, ok := fm.index[col]
+				if !ok {
+					//没有对应字段的列丢弃
+					dest[i] = new(sql.RawBytes)
+					continue
+				}
+				dest[i] = fieldByIndex(v, index).Addr().Interface()
+			}
+		} else {
+			dest[0] = v.Addr().Interface()
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, x := range index {
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isScanner(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
@@ -0,0 +1,493 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/hashring"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+分片方式
+*/
+const (
+	StrategyModulo = "modulo" //整数 key 对分片数取模，字符串 key 先做哈希
+	StrategyRange  = "range"  //整数 key 按区间分片
+	StrategyHash   = "hash"   //一致性哈希，增删分片时只迁移部分数据
+	StrategyLookup = "lookup" //按映射表分片，如租户到分片
+)
+
+var ErrNoShardKey = errors.New("mysql: no shard key in context")
+
+/*
+分片配置
+{"strategy":"range","shards":[{"name":"s0","master":{...},"slave":{...}},{"name":"s1","master":{...}}],
+"ranges":[{"shard":"s0","min":0,"max":1000000},{"shard":"s1","min":1000000,"max":2000000}]}
+*/
+type shardConfig struct {
+	Strategy string            `json:"strategy"`
+	Shards   []shardEntry      `json:"shards"`
+	Ranges   []ShardRange      `json:"ranges"`   //range 方式的区间，包含 min 不包含 max
+	Lookup   map[string]string `json:"lookup"`   //lookup 方式的映射表，key 到分片名称
+	Default  string            `json:"default"`  //lookup 方式找不到 key 时使用的分片，为空时报错
+	Replicas int               `json:"replicas"` //hash 方式每个分片的虚拟节点数，默认 160
+}
+
+type shardEntry struct {
+	Name   string `json:"name"`
+	Master dbConn `json:"master"`
+	Slave  dbConn `json:"slave"`
+}
+
+/*
+range 方式的一个区间
+*/
+type ShardRange struct {
+	Shard string `json:"shard"`
+	Min   int64  `json:"min"`
+	Max   int64  `json:"max"`
+}
+
+func (c *shardConfig) SetDefaults() {
+	if c.Strategy == "" {
+		c.Strategy = StrategyModulo
+	}
+	for i := range c.Shards {
+		cfg := c.Shards[i].config()
+		cfg.SetDefaults()
+		c.Shards[i].Master, c.Shards[i].Slave = cfg.Master, cfg.Slave
+	}
+}
+
+func (c *shardConfig) Validate(p *config.Problems) {
+	names := make(map[string]bool)
+	if len(c.Shards) == 0 {
+		p.Add("shards", "required")
+	}
+	for i, shard := range c.Shards {
+		sp := p.Sub("shards").Index(i)
+		sp.Required("name", shard.Name)
+		if names[shard.Name] {
+			sp.Addf("name", "duplicate shard %q", shard.Name)
+		}
+		names[shard.Name] = true
+		cfg := shard.config()
+		cfg.Validate(sp)
+	}
+	switch c.Strategy {
+	case StrategyModulo, StrategyHash:
+	case StrategyRange:
+		if len(c.Ranges) == 0 {
+			p.Add("ranges", "required by range strategy")
+		}
+		for i, r := range c.Ranges {
+			rp := p.Sub("ranges").Index(i)
+			if !names[r.Shard] {
+				rp.Addf("shard", "unknown shard %q", r.Shard)
+			}
+			if r.Min >= r.Max {
+				rp.Addf("max", "must be greater than min %d, got %d", r.Min, r.Max)
+			}
+		}
+		validateOverlap(p.Sub("ranges"), c.Ranges)
+	case StrategyLookup:
+		for key, shard := range c.Lookup {
+			if !names[shard] {
+				p.Sub("lookup").Addf(key, "unknown shard %q", shard)
+			}
+		}
+		if c.Default != "" && !names[c.Default] {
+			p.Addf("default", "unknown shard %q", c.Default)
+		}
+	default:
+		p.Addf("strategy", "unknown strategy %q", c.Strategy)
+	}
+	if c.Replicas < 0 {
+		p.Addf("replicas", "must not be negative, got %d", c.Replicas)
+	}
+}
+
+/*
+按 min 排序后检查相邻区间是否重叠，重叠时路由结果取决于排序，不允许
+*/
+func validateOverlap(p *config.Problems, ranges []ShardRange) {
+	index := make([]int, len(ranges))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool { return ranges[index[i]].Min < ranges[index[j]].Min })
+	for k := 1; k < len(index); k++ {
+		prev, cur := ranges[index[k-1]], ranges[index[k]]
+		if cur.Min < prev.Max {
+			p.Index(index[k]).Addf("min", "overlaps ranges[%d] [%d, %d)", index[k-1], prev.Min, prev.Max)
+		}
+	}
+}
+
+func (e *shardEntry) config() *mysqlConfig {
+	return &mysqlConfig{Master: e.Master, Slave: e.Slave}
+}
+
+/*
+根据分片 key 返回分片名称，可以用 Cluster.SetShardFunc 替换为自定义的实现
+*/
+type ShardFunc func(key interface{}) (string, error)
+
+/*
+一个分片的主库和从库
+*/
+type Shard struct {
+	Name   string
+	Master *sql.DB
+	Slave  *sql.DB
+}
+
+/*
+多个 mysql 集群组成的分片集群
+*/
+type Cluster struct {
+	mu     sync.RWMutex
+	shards map[string]*Shard
+	names  []string
+	fn     ShardFunc
+}
+
+/*
+通过ETCD方式连接分片集群
+dbKey etcd存储的分片配置的key
+endpoints etcd的ip节点列表
+*/
+func ShardedByEtcd(dbKey string, endpoints ...string) (*Cluster, error) {
+	return ShardedByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接分片集群，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ShardedByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*Cluster, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return sharded(ctx, dbKey, connStr)
+}
+
+/*
+通过ETCD 授权方式连接分片集群
+dbKey etcd存储的分片配置的key
+etcdName etcd用户名
+etcdPass etcd密码
+endpoints etcd的ip节点列表
+*/
+func ShardedByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*Cluster, error) {
+	return ShardedByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接分片集群，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ShardedByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*Cluster, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return sharded(ctx, dbKey, connStr)
+}
+
+/*
+通过ENV 变量方式连接分片集群
+env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
+dbKey etcd存储的分片配置的key
+*/
+func ShardedByEnv(env, dbKey string) (*Cluster, error) {
+	return ShardedByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接分片集群，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ShardedByEnvCtx(ctx context.Context, env, dbKey string) (*Cluster, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, err
+	}
+	return sharded(ctx, dbKey, connStr)
+}
+
+/*
+校验 etcd 中的分片配置，不连接数据库
+*/
+func ValidateSharded(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(shardConfig))
+}
+
+func sharded(ctx context.Context, name string, data []byte) (*Cluster, error) {
+	cfg := new(shardConfig)
+	if err := config.Decode(name, data, cfg); err != nil {
+		return nil, err
+	}
+	c := &Cluster{shards: make(map[string]*Shard)}
+	for _, entry := range cfg.Shards {
+		master, slave, err := conn(ctx, name+"/"+entry.Name, entry.config())
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards[entry.Name] = &Shard{Name: entry.Name, Master: master, Slave: slave}
+		c.names = append(c.names, entry.Name)
+	}
+	c.fn = newShardFunc(cfg, c.names)
+	return c, nil
+}
+
+func newShardFunc(cfg *shardConfig, names []string) ShardFunc {
+	switch cfg.Strategy {
+	case StrategyRange:
+		return RangeShard(cfg.Ranges...)
+	case StrategyHash:
+		return HashShard(cfg.Replicas, names...)
+	case StrategyLookup:
+		return LookupShard(cfg.Lookup, cfg.Default)
+	default:
+		return ModuloShard(names...)
+	}
+}
+
+/*
+对分片数取模，整数 key 直接取模，其它 key 先做哈希
+*/
+func ModuloShard(names ...string) ShardFunc {
+	return func(key interface{}) (string, error) {
+		if len(names) == 0 {
+			return "", errors.New("mysql: no shards")
+		}
+		if n, ok := toInt64(key); ok {
+			return names[uint64(n)%uint64(len(names))], nil
+		}
+		return names[hashring.Hash(fmt.Sprint(key))%uint32(len(names))], nil
+	}
+}
+
+/*
+整数 key 按区间分片，区间包含 min 不包含 max，区间不能重叠，配置中的重叠由校验报告
+*/
+func RangeShard(ranges ...ShardRange) ShardFunc {
+	sorted := append([]ShardRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min < sorted[j].Min })
+	return func(key interface{}) (string, error) {
+		n, ok := toInt64(key)
+		if !ok {
+			return "", fmt.Errorf("mysql: range shard key must be an integer, got %T", key)
+		}
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Max > n })
+		if i == len(sorted) || sorted[i].Min > n {
+			return "", fmt.Errorf("mysql: no shard for key %d", n)
+		}
+		return sorted[i].Shard, nil
+	}
+}
+
+/*
+一致性哈希分片，replicas 每个分片的虚拟节点数
+*/
+func HashShard(replicas int, names ...string) ShardFunc {
+	ring := hashring.New(replicas, names...)
+	return func(key interface{}) (string, error) {
+		name := ring.Get(fmt.Sprint(key))
+		if name == "" {
+			return "", errors.New("mysql: no shards")
+		}
+		return name, nil
+	}
+}
+
+/*
+按映射表分片，def 为找不到 key 时使用的分片，为空时报错
+*/
+func LookupShard(table map[string]string, def string) ShardFunc {
+	return func(key interface{}) (string, error) {
+		if name, ok := table[fmt.Sprint(key)]; ok {
+			return name, nil
+		}
+		if def != "" {
+			return def, nil
+		}
+		return "", fmt.Errorf("mysql: no shard for key %v", key)
+	}
+}
+
+func toInt64(key interface{}) (int64, bool) {
+	switch v := key.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+type shardKey struct{}
+
+/*
+把分片 key 放入 ctx，之后用 Cluster.ShardCtx 路由
+*/
+func WithShardKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, shardKey{}, key)
+}
+
+/*
+ctx 中的分片 key
+*/
+func ShardKey(ctx context.Context) (interface{}, bool) {
+	key := ctx.Value(shardKey{})
+	return key, key != nil
+}
+
+/*
+替换分片函数，返回的名称必须是配置中的分片
+*/
+func (c *Cluster) SetShardFunc(fn ShardFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fn = fn
+}
+
+/*
+按 key 路由到分片
+*/
+func (c *Cluster) Shard(key interface{}) (*Shard, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name, err := c.fn(key)
+	if err != nil {
+		return nil, err
+	}
+	shard, ok := c.shards[name]
+	if !ok {
+		return nil, fmt.Errorf("mysql: unknown shard %q", name)
+	}
+	return shard, nil
+}
+
+/*
+按 ctx 中的分片 key 路由，没有 key 时返回 ErrNoShardKey
+*/
+func (c *Cluster) ShardCtx(ctx context.Context) (*Shard, error) {
+	key, ok := ShardKey(ctx)
+	if !ok {
+		return nil, ErrNoShardKey
+	}
+	return c.Shard(key)
+}
+
+/*
+所有分片，按配置中的顺序
+*/
+func (c *Cluster) Shards() []*Shard {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	shards := make([]*Shard, 0, len(c.names))
+	for _, name := range c.names {
+		shards = append(shards, c.shards[name])
+	}
+	return shards
+}
+
+/*
+在所有分片上并发执行 fn，返回第一个错误，出错时取消其它分片
+*/
+func (c *Cluster) Each(ctx context.Context, fn func(ctx context.Context, shard *Shard) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	shards := c.Shards()
+	errs := make(chan error, len(shards))
+	for _, shard := range shards {
+		go func(shard *Shard) {
+			err := fn(ctx, shard)
+			if err != nil {
+				cancel()
+				err = fmt.Errorf("shard %s: %w", shard.Name, err)
+			}
+			errs <- err
+		}(shard)
+	}
+	var first error
+	for range shards {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+/*
+关闭所有分片的连接，并取消注册它们的健康检查、连接池指标和熔断器
+*/
+func (c *Cluster) Close() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var first error
+	for _, shard := range c.shards {
+		if shard.Slave != nil && shard.Slave != shard.Master {
+			if err := closeDB(shard.Slave); err != nil && first == nil {
+				first = err
+			}
+		}
+		if shard.Master != nil {
+			if err := closeDB(shard.Master); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+/*
+在所有分片的从库上执行查询并合并结果，结果按分片顺序拼接，排序和分页由调用方处理
+*/
+func ScatterQuery[T any](ctx context.Context, c *Cluster, query string, args ...interface{}) ([]T, error) {
+	shards := c.Shards()
+	results := make([][]T, len(shards))
+	index := make(map[string]int, len(shards))
+	for i, shard := range shards {
+		index[shard.Name] = i
+	}
+	err := c.Each(ctx, func(ctx context.Context, shard *Shard) error {
+		items, err := QueryStructs[T](ctx, shard.Slave, query, args...)
+		results[index[shard.Name]] = items
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var merged []T
+	for _, items := range results {
+		merged = append(merged, items...)
+	}
+	return merged, nil
+}
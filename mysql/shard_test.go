@@ -0,0 +1,122 @@
+package mysql_test
+
+import (
+	"github.com/chu108/cmany_db/mysql"
+	"testing"
+)
+
+func TestModuloShard(t *testing.T) {
+	fn := mysql.ModuloShard("s0", "s1", "s2")
+	tests := []struct {
+		key  interface{}
+		want string
+	}{
+		{0, "s0"},
+		{4, "s1"},
+		{int64(5), "s2"},
+		{uint8(6), "s0"},
+		{"7", "s1"},
+	}
+	for _, tt := range tests {
+		if got, err := fn(tt.key); err != nil || got != tt.want {
+			t.Fatalf("ModuloShard(%v) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+	//非整数 key 按哈希取模，结果稳定
+	a, err := fn("user-a")
+	if b, _ := fn("user-a"); err != nil || a == "" || a != b {
+		t.Fatalf("ModuloShard(user-a) = %q then %q, %v", a, b, err)
+	}
+	if _, err := mysql.ModuloShard()(1); err == nil {
+		t.Fatal("ModuloShard without shards succeeded")
+	}
+}
+
+func TestRangeShard(t *testing.T) {
+	//故意打乱顺序，并在 [200, 300) 留出空洞
+	fn := mysql.RangeShard(mysql.ShardRange{Shard: "s2", Min: 300, Max: 400}, mysql.ShardRange{Shard: "s0", Min: 0, Max: 100}, mysql.ShardRange{Shard: "s1", Min: 100, Max: 200})
+	tests := []struct {
+		key  interface{}
+		want string
+		err  bool
+	}{
+		{key: 0, want: "s0"},
+		{key: 99, want: "s0"},
+		{key: 100, want: "s1"},
+		{key: "199", want: "s1"},
+		{key: uint32(300), want: "s2"},
+		{key: 250, err: true},
+		{key: 400, err: true},
+		{key: -1, err: true},
+		{key: "abc", err: true},
+		{key: 1.5, err: true},
+	}
+	for _, tt := range tests {
+		got, err := fn(tt.key)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Fatalf("RangeShard(%v) = %q, %v, want %q, error %v", tt.key, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestHashShard(t *testing.T) {
+	fn := mysql.HashShard(0, "s0", "s1")
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		a, err := fn(i)
+		if b, _ := fn(i); err != nil || a != b {
+			t.Fatalf("HashShard(%d) = %q then %q, %v", i, a, b, err)
+		}
+		counts[a]++
+	}
+	if len(counts) != 2 || counts["s0"] == 0 || counts["s1"] == 0 {
+		t.Fatalf("HashShard distribution = %v", counts)
+	}
+	if _, err := mysql.HashShard(0)(1); err == nil {
+		t.Fatal("HashShard without shards succeeded")
+	}
+}
+
+func TestLookupShard(t *testing.T) {
+	table := map[string]string{"cn": "s0", "1": "s1"}
+	tests := []struct {
+		def  string
+		key  interface{}
+		want string
+		err  bool
+	}{
+		{key: "cn", want: "s0"},
+		{key: 1, want: "s1"},
+		{key: "us", err: true},
+		{def: "s9", key: "us", want: "s9"},
+	}
+	for _, tt := range tests {
+		got, err := mysql.LookupShard(table, tt.def)(tt.key)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Fatalf("LookupShard(%v) = %q, %v, want %q, error %v", tt.key, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestValidateSharded(t *testing.T) {
+	const shards = `"shards":[{"name":"s0","master":{"dsn":"root@tcp(127.0.0.1:3306)/a"}},{"name":"s1","master":{"dsn":"root@tcp(127.0.0.1:3306)/b"}}]`
+	tests := []struct {
+		name string
+		data string
+		err  bool
+	}{
+		{"modulo", `{` + shards + `}`, false},
+		{"range", `{"strategy":"range",` + shards + `,"ranges":[{"shard":"s0","min":0,"max":10},{"shard":"s1","min":10,"max":20}]}`, false},
+		{"overlapping ranges", `{"strategy":"range",` + shards + `,"ranges":[{"shard":"s0","min":0,"max":10},{"shard":"s1","min":5,"max":20}]}`, true},
+		{"empty range", `{"strategy":"range",` + shards + `,"ranges":[{"shard":"s0","min":10,"max":10}]}`, true},
+		{"unknown shard", `{"strategy":"lookup",` + shards + `,"lookup":{"cn":"s9"}}`, true},
+		{"unknown strategy", `{"strategy":"random",` + shards + `}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mysql.ValidateSharded("shard", []byte(tt.data)); (err != nil) != tt.err {
+				t.Fatalf("ValidateSharded = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
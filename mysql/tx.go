@@ -0,0 +1,148 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/retry"
+	"sync"
+	"time"
+)
+
+/*
+本包创建的连接池对应的主库，从库和主库都指向主库
+*/
+var masters sync.Map
+
+/*
+db 对应的主库，db 不是本包创建的从库时返回 db 本身
+*/
+func Master(db *sql.DB) *sql.DB {
+	if m, ok := masters.Load(db); ok {
+		return m.(*sql.DB)
+	}
+	return db
+}
+
+/*
+死锁和锁等待超时默认重试 3 次
+*/
+var defaultTxRetry = &retry.Policy{
+	MaxAttempts:    3,
+	InitialBackoff: config.Duration(time.Millisecond * 50),
+	MaxBackoff:     config.Duration(time.Second),
+	Jitter:         0.2,
+}
+
+/*
+事务选项
+Isolation 隔离级别，为 0 时使用数据库默认级别
+ReadOnly 只读事务
+Retry 死锁和锁等待超时时的重试策略，为空时重试 3 次
+*/
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	Retry     *retry.Policy
+}
+
+type txKey struct{}
+
+type txState struct {
+	tx     *sql.Tx
+	master *sql.DB
+	depth  int
+}
+
+/*
+ctx 中正在执行的事务，在 WithTx 的回调中使用
+*/
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	st, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return nil, false
+	}
+	return st.tx, true
+}
+
+/*
+在主库上执行事务，fn 返回 nil 时提交，返回错误或 panic 时回滚
+db 主库或从库，从库会自动换成对应的主库
+opts 为空时使用默认选项
+遇到死锁（1213）和锁等待超时（1205）时整个事务按 opts.Retry 重试，fn 可能被执行多次
+ctx 中已经有同一主库的事务时不再开启新事务，改用 SAVEPOINT，fn 出错时只回滚到保存点
+*/
+func WithTx(ctx context.Context, db *sql.DB, opts *TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	master := Master(db)
+	if st, ok := ctx.Value(txKey{}).(*txState); ok && st.master == master {
+		return savepoint(ctx, st, fn)
+	}
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	policy := opts.Retry
+	if policy == nil {
+		policy = defaultTxRetry
+	}
+	return retry.Do(ctx, "mysql tx", policy, func(ctx context.Context) error {
+		err := runTx(ctx, master, opts, fn)
+		if err != nil && !isLockError(err) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+}
+
+func runTx(ctx context.Context, master *sql.DB, opts *TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	tx, err := master.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, txKey{}, &txState{tx: tx, master: master}), tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/*
+嵌套事务，fn 出错时回滚到保存点，错误原样返回，死锁和锁等待超时由最外层重试
+死锁（1213）时 mysql 已经回滚了整个事务，保存点随之失效，不再回滚到保存点
+锁等待超时（1205）只回滚了出错的语句，事务和保存点都还在，仍要回滚到保存点
+*/
+func savepoint(ctx context.Context, parent *txState, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	st := &txState{tx: parent.tx, master: parent.master, depth: parent.depth + 1}
+	name := fmt.Sprintf("cmany_sp_%d", st.depth)
+	if _, err = st.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, txKey{}, st), st.tx); err != nil {
+		if errCode(err) != errDeadlock {
+			st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		}
+		return err
+	}
+	_, err = st.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+/*
+死锁或锁等待超时
+*/
+func isLockError(err error) bool {
+	code := errCode(err)
+	return code == errDeadlock || code == errLockWaitTimeout
+}
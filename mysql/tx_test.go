@@ -0,0 +1,145 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/chu108/cmany_db/fake"
+	"github.com/chu108/cmany_db/mysql"
+	"github.com/chu108/cmany_db/retry"
+	gomysql "github.com/go-sql-driver/mysql"
+	"reflect"
+	"testing"
+)
+
+var (
+	errDeadlock        = &gomysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	errLockWaitTimeout = &gomysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	errOther           = errors.New("other")
+)
+
+func statements(s *fake.SQL) []string {
+	var out []string
+	for _, st := range s.Statements() {
+		out = append(out, st.Query)
+	}
+	return out
+}
+
+func TestWithTxRetry(t *testing.T) {
+	policy := &retry.Policy{MaxAttempts: 3}
+	tests := []struct {
+		name  string
+		errs  []error //每次执行 fn 返回的错误，用完后返回 nil
+		err   error
+		calls int
+		want  []string
+	}{
+		{
+			name:  "commit",
+			calls: 1,
+			want:  []string{"BEGIN", "COMMIT"},
+		},
+		{
+			name:  "deadlock retried",
+			errs:  []error{errDeadlock, errLockWaitTimeout},
+			calls: 3,
+			want:  []string{"BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "COMMIT"},
+		},
+		{
+			name:  "attempts exhausted",
+			errs:  []error{errDeadlock, errDeadlock, errDeadlock},
+			err:   errDeadlock,
+			calls: 3,
+			want:  []string{"BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK"},
+		},
+		{
+			name:  "other error not retried",
+			errs:  []error{errOther},
+			err:   errOther,
+			calls: 1,
+			want:  []string{"BEGIN", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, s := fake.NewSQL()
+			defer db.Close()
+			calls := 0
+			err := mysql.WithTx(context.Background(), db, &mysql.TxOptions{Retry: policy}, func(ctx context.Context, tx *sql.Tx) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("WithTx = %v, want %v", err, tt.err)
+			}
+			if calls != tt.calls {
+				t.Fatalf("fn called %d times, want %d", calls, tt.calls)
+			}
+			if got := statements(s); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("statements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			name: "release",
+			want: []string{"BEGIN", "SAVEPOINT cmany_sp_1", "RELEASE SAVEPOINT cmany_sp_1", "COMMIT"},
+		},
+		{
+			name: "error rolls back to savepoint",
+			err:  errOther,
+			want: []string{"BEGIN", "SAVEPOINT cmany_sp_1", "ROLLBACK TO SAVEPOINT cmany_sp_1", "COMMIT"},
+		},
+		{
+			//锁等待超时只回滚了出错的语句，保存点还在
+			name: "lock wait timeout rolls back to savepoint",
+			err:  errLockWaitTimeout,
+			want: []string{"BEGIN", "SAVEPOINT cmany_sp_1", "ROLLBACK TO SAVEPOINT cmany_sp_1", "COMMIT"},
+		},
+		{
+			//死锁时整个事务已经回滚，保存点不存在了
+			name: "deadlock skips savepoint rollback",
+			err:  errDeadlock,
+			want: []string{"BEGIN", "SAVEPOINT cmany_sp_1", "COMMIT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, s := fake.NewSQL()
+			defer db.Close()
+			s.OnExec("SAVEPOINT cmany_sp_1", 0, 0)
+			s.OnExec("RELEASE SAVEPOINT cmany_sp_1", 0, 0)
+			s.OnExec("ROLLBACK TO SAVEPOINT cmany_sp_1", 0, 0)
+			err := mysql.WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sql.Tx) error {
+				//外层事务吞掉嵌套事务的错误后提交
+				err := mysql.WithTx(ctx, db, nil, func(ctx context.Context, inner *sql.Tx) error {
+					if inner != tx {
+						t.Error("nested WithTx started a new transaction")
+					}
+					return tt.err
+				})
+				if !errors.Is(err, tt.err) {
+					t.Errorf("nested WithTx = %v, want %v", err, tt.err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statements(s); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("statements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
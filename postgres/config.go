@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/chu108/cmany_db/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxOpen         = 100
+	defaultMaxIdle         = 10
+	defaultPort            = 5432
+	defaultConnMaxLifetime = time.Second * 100
+)
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(pgConfig))
+}
+
+/*
+没有配置备库时备库与主库相同
+*/
+func (c *pgConfig) SetDefaults() {
+	if c.Standby.DSN == "" && c.Standby.Host == "" {
+		c.Standby = c.Primary
+	}
+	c.Primary.SetDefaults()
+	c.Standby.SetDefaults()
+}
+
+func (c *pgConfig) Validate(p *config.Problems) {
+	c.Primary.Validate(p.Sub("primary"))
+	c.Standby.Validate(p.Sub("standby"))
+}
+
+/*
+max_open 默认 100，max_idle 默认 10 且不超过 max_open，分字段配置时 port 默认 5432
+开启 pgxpool 时 pgxpool_max_open 默认为 max_open 的一半
+*/
+func (c *dbConn) SetDefaults() {
+	if c.DSN == "" && c.Port == 0 {
+		c.Port = defaultPort
+	}
+	if c.MaxOpen == 0 {
+		c.MaxOpen = defaultMaxOpen
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = defaultMaxIdle
+		if c.MaxIdle > c.MaxOpen && c.MaxOpen > 0 {
+			c.MaxIdle = c.MaxOpen
+		}
+	}
+	if c.PgxPool && c.PgxPoolMaxOpen == 0 {
+		c.PgxPoolMaxOpen = c.MaxOpen / 2
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	switch {
+	case c.DSN == "" && c.Host == "":
+		p.Add("dsn", "dsn or host is required")
+	case c.DSN != "" && c.Host != "":
+		p.Add("dsn", "dsn and host can not be used together")
+	default:
+		if c.DSN == "" {
+			p.Range("port", c.Port, 1, 65535)
+			if c.Password != "" && c.User == "" {
+				p.Add("user", "required when password is set")
+			}
+		}
+		//解析时驱动会读取证书文件，校验配置时只检查连接串本身，sslmode 在下面单独检查
+		plain := *c
+		plain.SSLRootCert, plain.SSLCert, plain.SSLKey = "", "", ""
+		if !sslModes[plain.SSLMode] {
+			plain.SSLMode = ""
+		}
+		if s, err := plain.connString(); err != nil {
+			p.Add("dsn", err.Error())
+		} else if _, err := pgx.ParseConfig(s); err != nil {
+			p.Add("dsn", err.Error())
+		}
+	}
+	if c.SSLMode != "" && !sslModes[c.SSLMode] {
+		p.Addf("sslmode", "must be one of disable, allow, prefer, require, verify-ca, verify-full, got %q", c.SSLMode)
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		p.Add("sslcert", "sslcert and sslkey must be set together")
+	}
+	p.NonNegative("connect_timeout", c.ConnectTimeout)
+	p.NonNegative("conn_max_lifetime", c.ConnMaxLifetime)
+	p.NonNegative("conn_max_idle_time", c.ConnMaxIdleTime)
+	if c.MaxOpen < 0 {
+		p.Addf("max_open", "must not be negative, got %d", c.MaxOpen)
+	}
+	if c.MaxIdle < 0 {
+		p.Addf("max_idle", "must not be negative, got %d", c.MaxIdle)
+	} else if c.MaxIdle > c.MaxOpen {
+		p.Addf("max_idle", "must not be greater than max_open %d, got %d", c.MaxOpen, c.MaxIdle)
+	}
+	switch {
+	case !c.PgxPool && c.PgxPoolMaxOpen != 0:
+		p.Add("pgxpool_max_open", "only used when pgxpool is enabled")
+	case c.PgxPool && c.MaxOpen < 2:
+		p.Addf("max_open", "must be at least 2 when pgxpool is enabled, got %d", c.MaxOpen)
+	case c.PgxPool:
+		p.Range("pgxpool_max_open", c.PgxPoolMaxOpen, 1, c.MaxOpen-1)
+	}
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.Check("retry", c.Retry)
+}
+
+/*
+database/sql 的连接数上限，开启 pgxpool 时扣除原生连接池占用的部分
+*/
+func (c *dbConn) sqlMaxOpen() int {
+	if !c.PgxPool || c.MaxOpen <= 0 {
+		return c.MaxOpen
+	}
+	return c.MaxOpen - c.PgxPoolMaxOpen
+}
+
+func (c *dbConn) connMaxLifetime() time.Duration {
+	if c.ConnMaxLifetime <= 0 {
+		return defaultConnMaxLifetime
+	}
+	return c.ConnMaxLifetime.Std()
+}
+
+/*
+生成 pgx 的连接串，dsn 和分字段配置都支持，SSL 选项对两种方式都生效
+*/
+func (c *dbConn) connString() (string, error) {
+	opts := map[string]string{}
+	for k, v := range c.Params {
+		opts[k] = v
+	}
+	if c.SSLMode != "" {
+		opts["sslmode"] = c.SSLMode
+	}
+	if c.SSLRootCert != "" {
+		opts["sslrootcert"] = c.SSLRootCert
+	}
+	if c.SSLCert != "" {
+		opts["sslcert"] = c.SSLCert
+	}
+	if c.SSLKey != "" {
+		opts["sslkey"] = c.SSLKey
+	}
+
+	if c.DSN == "" {
+		port := c.Port
+		if port == 0 {
+			port = defaultPort
+		}
+		opts["host"] = c.Host
+		opts["port"] = strconv.Itoa(port)
+		if c.User != "" {
+			opts["user"] = c.User
+		}
+		if c.Password != "" {
+			opts["password"] = c.Password
+		}
+		if c.Database != "" {
+			opts["dbname"] = c.Database
+		}
+		return keywordString("", opts), nil
+	}
+	if strings.HasPrefix(c.DSN, "postgres://") || strings.HasPrefix(c.DSN, "postgresql://") {
+		u, err := url.Parse(c.DSN)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		for k, v := range opts {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	return keywordString(c.DSN, opts), nil
+}
+
+/*
+key=value 格式的连接串，后出现的同名参数覆盖前面的
+*/
+func keywordString(base string, opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	if base != "" {
+		parts = append(parts, base)
+	}
+	for _, k := range keys {
+		v := strings.ReplaceAll(opts[k], `\`, `\\`)
+		v = strings.ReplaceAll(v, `'`, `\'`)
+		parts = append(parts, k+"='"+v+"'")
+	}
+	return strings.Join(parts, " ")
+}
+
+/*
+database/sql 使用的配置，name 为指标和追踪中的实例名称
+*/
+func (c *dbConn) connConfig(name string) (*pgx.ConnConfig, error) {
+	s, err := c.connString()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := pgx.ParseConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	if c.ConnectTimeout > 0 {
+		cfg.ConnectTimeout = c.ConnectTimeout.Std()
+	}
+	cfg.Tracer = &tracer{name: name}
+	return cfg, nil
+}
+
+/*
+原生连接池的配置，连接数为 pgxpool_max_open，连接时间与 database/sql 的设置相同
+*/
+func (c *dbConn) poolConfig(name string) (*pgxpool.Config, error) {
+	s, err := c.connString()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := pgxpool.ParseConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	if c.ConnectTimeout > 0 {
+		cfg.ConnConfig.ConnectTimeout = c.ConnectTimeout.Std()
+	}
+	cfg.ConnConfig.Tracer = &tracer{name: name}
+	if c.PgxPoolMaxOpen > 0 {
+		cfg.MaxConns = int32(c.PgxPoolMaxOpen)
+	}
+	cfg.MaxConnLifetime = c.connMaxLifetime()
+	if c.ConnMaxIdleTime > 0 {
+		cfg.MaxConnIdleTime = c.ConnMaxIdleTime.Std()
+	}
+	return cfg, nil
+}
+
+/*
+连接串的摘要，包含 sslmode、params 和证书等所有连接参数，主备的摘要相同时共用一个连接池
+只用于比较，不返回原始连接串，连接串无效时返回空
+*/
+func (c *dbConn) dsnKey() string {
+	s, err := c.connString()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+以 DSN 中的地址和库名作为实例名称
+*/
+func dsnName(dsn string) string {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return backend
+	}
+	return cfg.Host + ":" + strconv.Itoa(int(cfg.Port)) + "/" + cfg.Database
+}
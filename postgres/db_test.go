@@ -0,0 +1,66 @@
+package postgres_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/postgres"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/postgres"
+
+/*
+没有内嵌的 postgres，etcd 中写入延迟连接的配置，只验证读取配置和创建连接池
+*/
+func check(primary, standby *sql.DB, err error) error {
+	if err != nil {
+		return err
+	}
+	defer postgres.Close(primary, standby)
+	if primary != standby {
+		return errors.New("standby should be the primary when not configured")
+	}
+	if got := primary.Stats().MaxOpenConnections; got != 100 {
+		return fmt.Errorf("max open = %d, want default 100", got)
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(cmanydbtest.ClosedAddr(t))
+	n, _ := strconv.Atoi(port)
+	conn := map[string]interface{}{"host": host, "port": n, "user": "app", "password": "secret", "database": "app", "lazy": true}
+	if err := env.Put(dbKey, map[string]interface{}{"primary": conn}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return check(postgres.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return check(postgres.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return check(postgres.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	dsn := "postgres://app:secret@" + cmanydbtest.ClosedAddr(t) + "/app?sslmode=disable&connect_timeout=1"
+	_, _, err := postgres.ConnByStr(dsn, 10, 5)
+	if !errors.Is(err, dberr.ErrUnreachable) {
+		t.Fatalf("closed port = %v, want ErrUnreachable", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error contains the password: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+/*
+postgres SQLSTATE
+*/
+const (
+	errInvalidPassword      = "28P01"
+	errInvalidAuthorization = "28000"
+	errInsufficientPrivs    = "42501"
+	errUniqueViolation      = "23505"
+	errSerializationFailure = "40001"
+	errDeadlockDetected     = "40P01"
+	errQueryCanceled        = "57014"
+	errLockNotAvailable     = "55P03"
+	errCannotConnectNow     = "57P03"
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		Timeout: func(err error) bool {
+			code := errCode(err)
+			return code == errQueryCanceled || code == errLockNotAvailable
+		},
+		DuplicateKey: func(err error) bool {
+			return errCode(err) == errUniqueViolation
+		},
+		Auth: func(err error) bool {
+			switch errCode(err) {
+			case errInvalidPassword, errInvalidAuthorization, errInsufficientPrivs:
+				return true
+			}
+			return false
+		},
+		Retryable: func(err error) bool {
+			switch errCode(err) {
+			case errSerializationFailure, errDeadlockDetected, errCannotConnectNow:
+				return true
+			}
+			return false
+		},
+	})
+}
+
+func errCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
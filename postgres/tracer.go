@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+const backend = "postgres"
+
+/*
+pgx 的 tracer，统计每条语句和建立连接的耗时，开启追踪时为每条语句创建 span
+database/sql 和原生连接池共用
+*/
+type tracer struct {
+	name string
+}
+
+type traceKey struct{}
+
+type traceData struct {
+	start   time.Time
+	command string
+	span    trace.Span
+}
+
+func (t *tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement := ""
+	if tracing.Enabled() {
+		statement = tracing.SanitizeSQL(data.SQL)
+	}
+	cmd := command(data.SQL)
+	ctx, span := tracing.Start(ctx, backend, t.name, cmd, statement)
+	return context.WithValue(ctx, traceKey{}, &traceData{start: time.Now(), command: cmd, span: span})
+}
+
+func (t *tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	d, ok := ctx.Value(traceKey{}).(*traceData)
+	if !ok {
+		return
+	}
+	metrics.Observe(backend, t.name, d.command, d.start, data.Err)
+	tracing.End(d.span, data.Err)
+}
+
+func (t *tracer) TraceConnectStart(ctx context.Context, _ pgx.TraceConnectStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, &traceData{start: time.Now(), command: "CONNECT"})
+}
+
+func (t *tracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	if d, ok := ctx.Value(traceKey{}).(*traceData); ok {
+		metrics.Observe(backend, t.name, d.command, d.start, data.Err)
+	}
+}
+
+/*
+取语句的第一个关键字作为命令名称，避免把整条 SQL 作为指标标签
+*/
+func command(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n(;")
+	if end > 0 {
+		query = query[:end]
+	}
+	if query == "" || len(query) > 16 {
+		return "OTHER"
+	}
+	for _, r := range query {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return "OTHER"
+		}
+	}
+	return strings.ToUpper(query)
+}
@@ -0,0 +1,9 @@
+package redigo
+
+/*
+应用代码用到的命令执行，redis.Conn 满足
+单元测试中可以用 fake.NewRedis() 代替，支持 GET、SET、DEL 等常用字符串命令，未命中时返回 nil 回复
+*/
+type Doer interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+}
@@ -0,0 +1,63 @@
+package redigo
+
+import (
+	"github.com/chu108/cmany_db/config"
+)
+
+const (
+	defaultPort      = 6379
+	defaultMaxActive = 100
+	defaultMaxIdle   = 10
+)
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+port 默认 6379，max_active 默认 100，max_idle 默认 10 且不超过 max_active
+*/
+func (c *dbConn) SetDefaults() {
+	if c.Port == 0 {
+		c.Port = defaultPort
+	}
+	if c.MaxActive == 0 {
+		c.MaxActive = defaultMaxActive
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = defaultMaxIdle
+		if c.MaxIdle > c.MaxActive && c.MaxActive > 0 {
+			c.MaxIdle = c.MaxActive
+		}
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("host", c.Host)
+	p.Range("port", c.Port, 1, 65535)
+	if c.DBNumber < 0 {
+		p.Addf("db_number", "must not be negative, got %d", c.DBNumber)
+	}
+	if c.MaxActive < 0 {
+		p.Addf("max_active", "must not be negative, got %d", c.MaxActive)
+	}
+	if c.MaxIdle < 0 {
+		p.Addf("max_idle", "must not be negative, got %d", c.MaxIdle)
+	} else if c.MaxIdle > c.MaxActive {
+		p.Addf("max_idle", "must not be greater than max_active %d, got %d", c.MaxActive, c.MaxIdle)
+	}
+	p.NonNegative("connect_timeout", c.ConnectTimeout)
+	p.NonNegative("read_timeout", c.ReadTimeout)
+	p.NonNegative("write_timeout", c.WriteTimeout)
+	p.NonNegative("idle_timeout", c.IdleTimeout)
+	p.Check("retry", c.Retry)
+	p.Check("breaker", c.Breaker)
+	if c.Breaker != nil && c.Breaker.Fallback == "master" {
+		p.Add("breaker.fallback", "master is not supported by redis")
+	}
+}
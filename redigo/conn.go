@@ -0,0 +1,211 @@
+package redigo
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"github.com/garyburd/redigo/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backend = "redigo"
+
+/*
+包装 redis.Conn，统计 Do 执行的命令，开启追踪时为每个命令创建 span
+配置了熔断时，熔断期间 Do 直接返回 breaker.ErrOpen，降级为 miss 时读命令返回 redis.ErrNil
+连接从 pool 中获取，还没有连接或连接出错时下一次命令重新获取
+*/
+type instrumentedConn struct {
+	pool    *redis.Pool
+	mu      sync.Mutex
+	conn    redis.Conn //当前使用的连接，延迟连接时第一次执行命令前为空
+	name    string
+	breaker *breaker.Breaker
+	miss    bool
+}
+
+/*
+取当前连接，为空或已出错时关闭旧连接并从 pool 重新获取
+*/
+func (c *instrumentedConn) get(ctx context.Context) (redis.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		if c.conn.Err() == nil {
+			return c.conn, nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *instrumentedConn) Send(commandName string, args ...interface{}) error {
+	conn, err := c.get(context.Background())
+	if err != nil {
+		return err
+	}
+	return conn.Send(commandName, args...)
+}
+
+func (c *instrumentedConn) Flush() error {
+	conn, err := c.get(context.Background())
+	if err != nil {
+		return err
+	}
+	return conn.Flush()
+}
+
+func (c *instrumentedConn) Receive() (interface{}, error) {
+	conn, err := c.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return conn.Receive()
+}
+
+/*
+还没有连接时返回 nil，下一次命令会去连接
+*/
+func (c *instrumentedConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Err()
+}
+
+/*
+归还当前连接并关闭 pool
+*/
+func (c *instrumentedConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+	if perr := c.pool.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+func (c *instrumentedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return c.DoContext(context.Background(), commandName, args...)
+}
+
+func (c *instrumentedConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	//redigo 以空命令刷新管道，不单独统计
+	if commandName == "" {
+		conn, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return conn.Do(commandName, args...)
+	}
+	op := strings.ToUpper(commandName)
+	if c.breaker != nil {
+		if err := c.breaker.Allow(); err != nil {
+			if c.miss && readCommands[op] {
+				return nil, redis.ErrNil
+			}
+			return nil, err
+		}
+	}
+	_, span := tracing.Start(ctx, backend, c.name, op, op+strings.Repeat(" ?", len(args)))
+	start := time.Now()
+	var reply interface{}
+	conn, err := c.get(ctx)
+	if err == nil {
+		reply, err = conn.Do(commandName, args...)
+	}
+	metrics.Observe(backend, c.name, op, start, cmdErr(err))
+	if c.breaker != nil {
+		c.breaker.ReportResult(err)
+	}
+	tracing.End(span, cmdErr(err))
+	return reply, err
+}
+
+/*
+关闭本包返回的连接和连接池，同时取消注册健康检查、连接池指标和熔断器
+c 不是本包返回的连接时直接关闭
+*/
+func Close(c redis.Conn) error {
+	if ic, ok := c.(*instrumentedConn); ok {
+		health.Unregister(ic.name)
+		metrics.UnregisterPool(backend, ic.name)
+		if ic.breaker != nil {
+			breaker.Unregister(ic.name)
+		}
+	}
+	return c.Close()
+}
+
+/*
+带 context 执行命令，span 挂在 ctx 中的 span 下面
+c 不是本包返回的连接时直接执行 Do
+*/
+func DoContext(ctx context.Context, c redis.Conn, commandName string, args ...interface{}) (interface{}, error) {
+	if ic, ok := c.(*instrumentedConn); ok {
+		return ic.DoContext(ctx, commandName, args...)
+	}
+	return c.Do(commandName, args...)
+}
+
+/*
+注册连接池统计，redigo 只提供活跃和空闲连接数
+*/
+func registerPool(name string, pool *redis.Pool) {
+	metrics.RegisterPool(backend, name, func() metrics.PoolStats {
+		s := pool.Stats()
+		return metrics.PoolStats{
+			Open:  s.ActiveCount,
+			Idle:  s.IdleCount,
+			InUse: s.ActiveCount - s.IdleCount,
+		}
+	})
+}
+
+/*
+熔断降级为 miss 时按未命中处理的读命令，写命令仍返回 breaker.ErrOpen，避免调用方误以为写入成功
+*/
+var readCommands = map[string]bool{
+	"GET": true, "MGET": true, "GETRANGE": true, "STRLEN": true, "EXISTS": true, "TTL": true, "PTTL": true, "TYPE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true, "HLEN": true, "HEXISTS": true, "HSTRLEN": true,
+	"LRANGE": true, "LINDEX": true, "LLEN": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SCARD": true, "SRANDMEMBER": true,
+	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true, "ZREVRANGEBYSCORE": true, "ZSCORE": true, "ZRANK": true, "ZREVRANK": true, "ZCARD": true, "ZCOUNT": true,
+}
+
+/*
+redis.ErrNil 表示 key 不存在，不计入错误
+*/
+func cmdErr(err error) error {
+	if err == redis.ErrNil {
+		return nil
+	}
+	return err
+}
+
+/*
+redis.ErrNil 和服务端返回的错误说明 redis 可用，不计入熔断失败
+*/
+func isFailure(err error) bool {
+	if _, ok := err.(redis.Error); ok {
+		return false
+	}
+	return err != redis.ErrNil && breaker.IsConnError(err)
+}
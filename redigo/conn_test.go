@@ -0,0 +1,47 @@
+package redigo_test
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/redigo"
+	"github.com/garyburd/redigo/redis"
+	"net"
+	"testing"
+)
+
+func TestBreakerMiss(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"breaker":{"failure_threshold":1,"cool_down":"1h","fallback":"miss"}}`)
+	conn, err := redigo.ConnByJSON("miss", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redigo.Close(conn)
+
+	//redis 停止后第一次失败就熔断
+	env.Redis.Close()
+	if _, err := conn.Do("GET", "k"); err == nil || err == redis.ErrNil {
+		t.Fatalf("GET after redis stopped = %v, want connection error", err)
+	}
+	if s := breaker.Get("miss").State(); s != breaker.StateOpen {
+		t.Fatalf("breaker state = %v, want open", s)
+	}
+
+	tests := []struct {
+		cmd  string
+		args []interface{}
+		want error
+	}{
+		{"GET", []interface{}{"k"}, redis.ErrNil},
+		{"hgetall", []interface{}{"h"}, redis.ErrNil},
+		{"SET", []interface{}{"k", "v"}, breaker.ErrOpen},
+		{"DEL", []interface{}{"k"}, breaker.ErrOpen},
+	}
+	for _, tt := range tests {
+		if _, err := conn.Do(tt.cmd, tt.args...); !errors.Is(err, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.cmd, err, tt.want)
+		}
+	}
+}
@@ -2,20 +2,32 @@ package redigo
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
 	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
 	"github.com/garyburd/redigo/redis"
 	"time"
 )
 
 type dbConn struct {
-	Host      string `json:"host"`
-	Port      int    `json:"port"`
-	Password  string `json:"password"`
-	DBNumber  int    `json:"db_number"`
-	MaxActive int    `json:"max_active"`
-	MaxIdle   int    `json:"max_idle"`
+	Host           string          `json:"host"`
+	Port           int             `json:"port"`
+	Password       string          `json:"password"`
+	DBNumber       int             `json:"db_number"`
+	MaxActive      int             `json:"max_active"`
+	MaxIdle        int             `json:"max_idle"`
+	ConnectTimeout config.Duration `json:"connect_timeout"` //建立连接的超时时间，默认 2s
+	ReadTimeout    config.Duration `json:"read_timeout"`    //读超时，默认 2s
+	WriteTimeout   config.Duration `json:"write_timeout"`   //写超时，默认 2s
+	IdleTimeout    config.Duration `json:"idle_timeout"`    //空闲连接超时时间，默认 1s
+	Retry          *retry.Policy   `json:"retry"`           //启动时连接的重试策略，为空时使用全局默认策略
+	Lazy           bool            `json:"lazy"`            //延迟连接，创建时不连接，第一次执行命令时再从连接池获取连接
+	Breaker        *breaker.Config `json:"breaker"`         //熔断配置，为空时不熔断，fallback 为 miss 时熔断期间的读命令返回 redis.ErrNil
 }
 
 /*
@@ -24,11 +36,18 @@ dbKey etcd存储的数据库连接字符串的key
 endpoints etcd的ip节点列表
 */
 func ConnByEtcd(dbKey string, endpoints ...string) (redis.Conn, error) {
-	connStr, err := etcd.Conn(endpoints...).Get(dbKey)
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (redis.Conn, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -39,11 +58,18 @@ etcdPass etcd密码
 endpoints etcd的ip节点列表
 */
 func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (redis.Conn, error) {
-	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).Get(dbKey)
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (redis.Conn, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -52,11 +78,18 @@ env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
 dbKey etcd存储的数据库连接字符串的key
 */
 func ConnByEnv(env, dbKey string) (redis.Conn, error) {
-	connStr, err := etcd.ConnByEnv(env).Get(dbKey)
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (redis.Conn, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -66,6 +99,13 @@ port 端口
 password 密码
 */
 func ConnByStr(host string, port int, password string) (redis.Conn, error) {
+	return ConnByStrCtx(context.Background(), host, port, password)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByStrCtx(ctx context.Context, host string, port int, password string) (redis.Conn, error) {
 	cfg := new(dbConn)
 	cfg.Host = host
 	cfg.Port = port
@@ -74,19 +114,48 @@ func ConnByStr(host string, port int, password string) (redis.Conn, error) {
 	cfg.MaxActive = 100
 	cfg.MaxIdle = 10
 
-	return conn(cfg)
+	return conn(ctx, fmt.Sprintf("%s:%d", host, port), cfg)
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (redis.Conn, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (redis.Conn, error) {
+	return connByConnByte(ctx, name, data)
 }
 
-func connByConnByte(connByte []byte) (redis.Conn, error) {
+func connByConnByte(ctx context.Context, name string, connByte []byte) (redis.Conn, error) {
 	cfg := new(dbConn)
-	if err := json.Unmarshal(connByte, cfg); err != nil {
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, err
 	}
 
-	return conn(cfg)
+	return conn(ctx, name, cfg)
+}
+
+/*
+d 为 0 时返回默认值 def
+*/
+func timeout(d config.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d.Std()
 }
 
-func conn(cfg *dbConn) (redis.Conn, error) {
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (redis.Conn, error) {
 	pool := &redis.Pool{
 		Dial: func() (redis.Conn, error) {
 			return redis.Dial(
@@ -94,20 +163,56 @@ func conn(cfg *dbConn) (redis.Conn, error) {
 				fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 				redis.DialPassword(cfg.Password),
 				redis.DialDatabase(cfg.DBNumber),
-				redis.DialConnectTimeout(time.Second*2),
-				redis.DialReadTimeout(time.Second*2),
-				redis.DialWriteTimeout(time.Second*2),
+				redis.DialConnectTimeout(timeout(cfg.ConnectTimeout, time.Second*2)),
+				redis.DialReadTimeout(timeout(cfg.ReadTimeout, time.Second*2)),
+				redis.DialWriteTimeout(timeout(cfg.WriteTimeout, time.Second*2)),
 			)
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
 			return err
 		},
-		MaxIdle:     cfg.MaxIdle,   //最大空闲连接数，即会有这么多个连接提前等待着，但过了超时时间也会关闭
-		MaxActive:   cfg.MaxActive, //最大连接数，即最多的tcp连接数，一般建议往大的配置，但不要超过操作系统文件句柄个数（centos下可以ulimit -n查看）
-		IdleTimeout: time.Second,   //空闲连接超时时间，但应该设置比redis服务器超时时间短。否则服务端超时了，客户端保持着连接也没用
-		Wait:        true,          //当超过最大连接数 是报错还是等待，true 等待 false 报错
+		MaxIdle:     cfg.MaxIdle,                           //最大空闲连接数，即会有这么多个连接提前等待着，但过了超时时间也会关闭
+		MaxActive:   cfg.MaxActive,                         //最大连接数，即最多的tcp连接数，一般建议往大的配置，但不要超过操作系统文件句柄个数（centos下可以ulimit -n查看）
+		IdleTimeout: timeout(cfg.IdleTimeout, time.Second), //空闲连接超时时间，但应该设置比redis服务器超时时间短。否则服务端超时了，客户端保持着连接也没用
+		Wait:        true,                                  //当超过最大连接数 是报错还是等待，true 等待 false 报错
+	}
+
+	registerPool(name, pool)
+	health.Register(backend, name, func(ctx context.Context) error {
+		c, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_, err = c.Do("PING")
+		return err
+	})
+	ic := &instrumentedConn{pool: pool, name: name}
+	if cfg.Breaker != nil {
+		ic.breaker = breaker.New(name, *cfg.Breaker, isFailure)
+		ic.miss = cfg.Breaker.Fallback == "miss"
+	}
+	if cfg.Lazy {
+		return ic, nil
+	}
+
+	var c redis.Conn
+	err := retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) (err error) {
+		c, err = pool.GetContext(ctx)
+		return err
+	})
+	if err != nil {
+		metrics.ConnectError(backend, name)
+		metrics.UnregisterPool(backend, name)
+		health.Unregister(name)
+		if ic.breaker != nil {
+			breaker.Unregister(name)
+		}
+		pool.Close()
+		return nil, dberr.Connect(name, err)
 	}
 
-	return pool.GetContext(context.Background())
+	ic.conn = c
+	return ic, nil
 }
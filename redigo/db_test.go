@@ -0,0 +1,106 @@
+package redigo_test
+
+import (
+	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/redigo"
+	"github.com/garyburd/redigo/redis"
+	"net"
+	"strconv"
+	"testing"
+)
+
+/*
+通过连接写入后在 miniredis 中读到，然后关闭连接
+*/
+func set(env *cmanydbtest.Env, conn redis.Conn, err error) error {
+	if err != nil {
+		return err
+	}
+	defer redigo.Close(conn)
+	if _, err := conn.Do("SET", "k", "v"); err != nil {
+		return err
+	}
+	if got, _ := env.Redis.Get("k"); got != "v" {
+		return fmt.Errorf("k = %q, want v", got)
+	}
+	env.Redis.Del("k")
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: cmanydbtest.RedisKey,
+		Etcd: func(key string, endpoints ...string) error {
+			conn, err := redigo.ConnByEtcd(key, endpoints...)
+			return set(env, conn, err)
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			conn, err := redigo.ConnByEtcdAuth(key, user, password, endpoints...)
+			return set(env, conn, err)
+		},
+		Env: func(name, key string) error {
+			conn, err := redigo.ConnByEnv(name, key)
+			return set(env, conn, err)
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	n, _ := strconv.Atoi(port)
+	conn, err := redigo.ConnByStr(host, n, "")
+	if err := set(env, conn, err); err != nil {
+		t.Fatal(err)
+	}
+
+	//密码错误时识别为认证失败
+	env.Redis.RequireAuth("secret")
+	_, err = redigo.ConnByStr(host, n, "wrong")
+	if !dberr.IsAuth(err) {
+		t.Fatalf("wrong password = %v, want auth error", err)
+	}
+}
+
+func TestConnByJSONLazy(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	env.Redis.Close()
+
+	//redis 不可用时延迟连接也能创建，命令返回连接错误
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"lazy":true}`)
+	conn, err := redigo.ConnByJSON("lazy", data)
+	if err != nil {
+		t.Fatalf("lazy connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := conn.Do("PING"); err == nil {
+		t.Fatal("PING before redis starts succeeded")
+	}
+
+	//redis 启动后下一次命令重新获取连接
+	if err := env.Redis.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("SET", "k", "v"); err != nil {
+		t.Fatalf("SET after restart: %v", err)
+	}
+	if got, _ := env.Redis.Get("k"); got != "v" {
+		t.Fatalf("k = %q, want v", got)
+	}
+}
+
+func TestConnByJSONUnregistersBreaker(t *testing.T) {
+	host, port, _ := net.SplitHostPort(cmanydbtest.ClosedAddr(t))
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"breaker":{}}`)
+	if _, err := redigo.ConnByJSON("breaker", data); err == nil {
+		t.Fatal("connect to closed port succeeded")
+	}
+	if breaker.Get("breaker") != nil {
+		t.Fatal("breaker still registered after failed connect")
+	}
+}
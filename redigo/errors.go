@@ -0,0 +1,38 @@
+package redigo
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/garyburd/redigo/redis"
+	"strings"
+)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		NotFound: func(err error) bool {
+			return errors.Is(err, redis.ErrNil)
+		},
+		Auth: func(err error) bool {
+			return hasServerPrefix(err, "NOAUTH", "WRONGPASS", "NOPERM", "ERR invalid password", "ERR AUTH")
+		},
+		Retryable: func(err error) bool {
+			return hasServerPrefix(err, "LOADING", "READONLY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "BUSY ")
+		},
+	})
+}
+
+/*
+err 是否是以 prefixes 之一开头的服务端错误 redis.Error，其它错误即使内容相同也不算
+*/
+func hasServerPrefix(err error, prefixes ...string) bool {
+	var serverErr redis.Error
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(string(serverErr), prefix) {
+			return true
+		}
+	}
+	return false
+}
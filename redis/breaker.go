@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/go-redis/redis"
+	"strings"
+)
+
+/*
+熔断器作为 go-redis 的 Limiter，熔断期间获取连接直接失败，返回 breaker.ErrOpen
+*/
+type limiter struct {
+	*breaker.Breaker
+}
+
+func newLimiter(name string, cfg *breaker.Config) *limiter {
+	return &limiter{Breaker: breaker.New(name, *cfg, isFailure)}
+}
+
+/*
+fallback 为 miss 时，熔断期间的读命令返回 redis.Nil，调用方按缓存未命中处理
+写命令和 pipeline 仍返回 breaker.ErrOpen，避免调用方误以为写入成功
+半开状态下试探名额用完时读命令同样返回 breaker.ErrOpen
+*/
+func wrapMiss(b *breaker.Breaker) func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+	return func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			if readCommands[strings.ToUpper(cmd.Name())] && b.State() == breaker.StateOpen {
+				return missClient.Process(cmd)
+			}
+			return old(cmd)
+		}
+	}
+}
+
+/*
+go-redis 不能从外部设置命令的错误，借助 Limiter 总是返回 redis.Nil 的客户端设置，该客户端不会建立连接
+*/
+var missClient = newMissClient()
+
+func newMissClient() *redis.Client {
+	cli := redis.NewClient(&redis.Options{IdleTimeout: -1})
+	cli.SetLimiter(missLimiter{})
+	return cli
+}
+
+type missLimiter struct{}
+
+func (missLimiter) Allow() error {
+	return redis.Nil
+}
+
+func (missLimiter) ReportResult(error) {}
+
+/*
+熔断降级为 miss 时按未命中处理的读命令
+*/
+var readCommands = map[string]bool{
+	"GET": true, "MGET": true, "GETRANGE": true, "STRLEN": true, "EXISTS": true, "TTL": true, "PTTL": true, "TYPE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true, "HLEN": true, "HEXISTS": true, "HSTRLEN": true,
+	"LRANGE": true, "LINDEX": true, "LLEN": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SCARD": true, "SRANDMEMBER": true,
+	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true, "ZREVRANGEBYSCORE": true, "ZSCORE": true, "ZRANK": true, "ZREVRANK": true, "ZCARD": true, "ZCOUNT": true,
+}
+
+/*
+redis.Nil 和服务端返回的错误说明 redis 可用，不计入熔断失败
+*/
+func isFailure(err error) bool {
+	return err != redis.Nil && breaker.IsConnError(err)
+}
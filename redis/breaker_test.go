@@ -0,0 +1,49 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/redis"
+	goredis "github.com/go-redis/redis"
+	"net"
+	"testing"
+)
+
+func TestBreakerMiss(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"breaker":{"failure_threshold":1,"cool_down":"1h","fallback":"miss"}}`)
+	client, err := redis.ConnByJSON("miss", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close(client)
+
+	//redis 停止后第一次失败就熔断
+	env.Redis.Close()
+	if err := client.Get("k").Err(); err == nil || err == goredis.Nil {
+		t.Fatalf("GET after redis stopped = %v, want connection error", err)
+	}
+	if s := breaker.Get("miss").State(); s != breaker.StateOpen {
+		t.Fatalf("breaker state = %v, want open", s)
+	}
+
+	tests := []struct {
+		name string
+		cmd  goredis.Cmder
+		want error
+	}{
+		{"GET", client.Get("k"), goredis.Nil},
+		{"HGETALL", client.HGetAll("h"), goredis.Nil},
+		{"SET", client.Set("k", "v", 0), breaker.ErrOpen},
+		{"DEL", client.Del("k"), breaker.ErrOpen},
+		{"WithContext GET", redis.WithContext(context.Background(), client).Get("k"), goredis.Nil},
+	}
+	for _, tt := range tests {
+		if err := tt.cmd.Err(); !errors.Is(err, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
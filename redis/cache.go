@@ -0,0 +1,16 @@
+package redis
+
+import (
+	"github.com/go-redis/redis"
+	"time"
+)
+
+/*
+应用代码用到的缓存读写，*redis.Client 满足
+单元测试中可以用 fake.NewRedis() 代替，未命中时 Get 的结果返回 redis.Nil
+*/
+type Cache interface {
+	Get(key string) *redis.StringCmd
+	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Del(keys ...string) *redis.IntCmd
+}
@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"github.com/chu108/cmany_db/config"
+)
+
+const defaultPort = 6379
+
+/*
+校验 etcd 中的配置，不连接数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+port 默认 6379
+*/
+func (c *dbConn) SetDefaults() {
+	if c.Port == 0 {
+		c.Port = defaultPort
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("host", c.Host)
+	p.Range("port", c.Port, 1, 65535)
+	if c.DBNumber < 0 {
+		p.Addf("db_number", "must not be negative, got %d", c.DBNumber)
+	}
+	p.NonNegative("dial_timeout", c.DialTimeout)
+	p.NonNegative("read_timeout", c.ReadTimeout)
+	p.NonNegative("write_timeout", c.WriteTimeout)
+	p.NonNegative("pool_timeout", c.PoolTimeout)
+	p.NonNegative("idle_timeout", c.IdleTimeout)
+	p.NonNegative("ping_timeout", c.PingTimeout)
+	p.Check("retry", c.Retry)
+	p.Check("breaker", c.Breaker)
+	if c.Breaker != nil && c.Breaker.Fallback == "master" {
+		p.Add("breaker.fallback", "master is not supported by redis")
+	}
+}
@@ -1,18 +1,33 @@
 package redis
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
 	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/retry"
 	"github.com/go-redis/redis"
 	"time"
 )
 
 type dbConn struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Password string `json:"password"`
-	DBNumber int    `json:"db_number"`
+	Host         string          `json:"host"`
+	Port         int             `json:"port"`
+	Password     string          `json:"password"`
+	DBNumber     int             `json:"db_number"`
+	DialTimeout  config.Duration `json:"dial_timeout"`  //建立连接的超时时间，为 0 时使用 go-redis 的默认值 5s
+	ReadTimeout  config.Duration `json:"read_timeout"`  //读超时，为 0 时使用 go-redis 的默认值 3s
+	WriteTimeout config.Duration `json:"write_timeout"` //写超时，为 0 时与读超时相同
+	PoolTimeout  config.Duration `json:"pool_timeout"`  //连接池满时等待空闲连接的时间，为 0 时为读超时加 1s
+	IdleTimeout  config.Duration `json:"idle_timeout"`  //空闲连接超时时间，默认 60s
+	PingTimeout  config.Duration `json:"ping_timeout"`  //启动时每次 ping 的超时时间，为 0 时只受 ctx 和读写超时控制
+	Retry        *retry.Policy   `json:"retry"`         //启动时 ping 的重试策略，为空时使用全局默认策略
+	Lazy         bool            `json:"lazy"`          //延迟连接，创建时不 ping
+	Breaker      *breaker.Config `json:"breaker"`       //熔断配置，为空时不熔断，fallback 为 miss 时熔断期间的读命令返回 redis.Nil
 }
 
 /*
@@ -21,11 +36,18 @@ dbKey etcd存储的数据库连接字符串的key
 endpoints etcd的ip节点列表
 */
 func ConnByEtcd(dbKey string, endpoints ...string) (*redis.Client, error) {
-	connStr, err := etcd.Conn(endpoints...).Get(dbKey)
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*redis.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -36,11 +58,18 @@ etcdPass etcd密码
 endpoints etcd的ip节点列表
 */
 func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*redis.Client, error) {
-	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).Get(dbKey)
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*redis.Client, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -49,11 +78,18 @@ env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
 dbKey etcd存储的数据库连接字符串的key
 */
 func ConnByEnv(env, dbKey string) (*redis.Client, error) {
-	connStr, err := etcd.ConnByEnv(env).Get(dbKey)
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时连接的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*redis.Client, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
 	if err != nil {
 		return nil, err
 	}
-	return connByConnByte(connStr)
+	return connByConnByte(ctx, dbKey, connStr)
 }
 
 /*
@@ -63,35 +99,126 @@ port 端口
 password 密码
 */
 func ConnByStr(host string, port int, password string) (client *redis.Client, err error) {
+	return ConnByStrCtx(context.Background(), host, port, password)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时 ping 的时间
+*/
+func ConnByStrCtx(ctx context.Context, host string, port int, password string) (client *redis.Client, err error) {
 	cfg := new(dbConn)
 	cfg.Host = host
 	cfg.Port = port
 	cfg.Password = password
 	cfg.DBNumber = 0
-	return conn(cfg)
+	return conn(ctx, fmt.Sprintf("%s:%d", host, port), cfg)
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (*redis.Client, error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (*redis.Client, error) {
+	return connByConnByte(ctx, name, data)
 }
 
-func connByConnByte(connByte []byte) (client *redis.Client, err error) {
+func connByConnByte(ctx context.Context, name string, connByte []byte) (client *redis.Client, err error) {
 	cfg := new(dbConn)
-	if err := json.Unmarshal(connByte, cfg); err != nil {
+	if err := config.Decode(name, connByte, cfg); err != nil {
 		return nil, err
 	}
-	return conn(cfg)
+	return conn(ctx, name, cfg)
 }
 
-func conn(cfg *dbConn) (*redis.Client, error) {
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (*redis.Client, error) {
+	idleTimeout := cfg.IdleTimeout.Std()
+	if idleTimeout <= 0 {
+		idleTimeout = time.Second * 60
+	}
 	cli := redis.NewClient(&redis.Options{
-		Addr:        fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Password:    cfg.Password,
-		DB:          cfg.DBNumber,
-		IdleTimeout: time.Second * 60,
-		MaxRetries:  2,
+		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password:     cfg.Password,
+		DB:           cfg.DBNumber,
+		DialTimeout:  cfg.DialTimeout.Std(),
+		ReadTimeout:  cfg.ReadTimeout.Std(),
+		WriteTimeout: cfg.WriteTimeout.Std(),
+		PoolTimeout:  cfg.PoolTimeout.Std(),
+		IdleTimeout:  idleTimeout,
+		MaxRetries:   2,
 	})
 
-	_, err := cli.Ping().Result()
+	err := ping(ctx, name, cfg, cli)
 	if err != nil {
-		return nil, err
+		metrics.ConnectError(backend, name)
+		cli.Close()
+		return nil, dberr.Connect(name, err)
 	}
+	if cfg.Breaker != nil {
+		l := newLimiter(name, cfg.Breaker)
+		cli.SetLimiter(l)
+		if cfg.Breaker.Fallback == "miss" {
+			cli.WrapProcess(wrapMiss(l.Breaker))
+		}
+	}
+	instrument(name, cli)
+	health.Register(backend, name, func(ctx context.Context) error {
+		return pingCtx(ctx, cli)
+	})
 
 	return cli, nil
 }
+
+/*
+关闭 conn 返回的客户端，同时取消注册健康检查、连接池指标和熔断器
+*/
+func Close(cli *redis.Client) error {
+	if v, ok := hooks.LoadAndDelete(cli.Options()); ok {
+		name := v.(*hook).name
+		health.Unregister(name)
+		metrics.UnregisterPool(backend, name)
+		breaker.Unregister(name)
+	}
+	return cli.Close()
+}
+
+func ping(ctx context.Context, name string, cfg *dbConn, cli *redis.Client) error {
+	if cfg.Lazy {
+		return nil
+	}
+	return retry.Do(ctx, name, cfg.Retry, func(ctx context.Context) error {
+		if cfg.PingTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.PingTimeout.Std())
+			defer cancel()
+		}
+		return pingCtx(ctx, cli)
+	})
+}
+
+/*
+go-redis v6 的命令不使用 ctx 做网络读写，在 goroutine 中 ping，ctx 结束时直接返回
+未结束的 ping 受读写超时限制，调用方关闭客户端后也会返回
+*/
+func pingCtx(ctx context.Context, cli *redis.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.Ping().Err()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
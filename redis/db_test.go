@@ -0,0 +1,130 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chu108/cmany_db/breaker"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/redis"
+	goredis "github.com/go-redis/redis"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+通过客户端写入后在 miniredis 中读到，然后关闭客户端
+*/
+func set(env *cmanydbtest.Env, client *goredis.Client, err error) error {
+	if err != nil {
+		return err
+	}
+	defer redis.Close(client)
+	if err := client.Set("k", "v", 0).Err(); err != nil {
+		return err
+	}
+	if got, _ := env.Redis.Get("k"); got != "v" {
+		return fmt.Errorf("k = %q, want v", got)
+	}
+	env.Redis.Del("k")
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: cmanydbtest.RedisKey,
+		Etcd: func(key string, endpoints ...string) error {
+			client, err := redis.ConnByEtcd(key, endpoints...)
+			return set(env, client, err)
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			client, err := redis.ConnByEtcdAuth(key, user, password, endpoints...)
+			return set(env, client, err)
+		},
+		Env: func(name, key string) error {
+			client, err := redis.ConnByEnv(name, key)
+			return set(env, client, err)
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	n, _ := strconv.Atoi(port)
+	client, err := redis.ConnByStr(host, n, "")
+	if err := set(env, client, err); err != nil {
+		t.Fatal(err)
+	}
+
+	//密码错误时识别为认证失败
+	env.Redis.RequireAuth("secret")
+	_, err = redis.ConnByStr(host, n, "wrong")
+	if !dberr.IsAuth(err) {
+		t.Fatalf("wrong password = %v, want auth error", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	env := cmanydbtest.New(t)
+	host, port, _ := net.SplitHostPort(env.Redis.Addr())
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"breaker":{}}`)
+	client, err := redis.ConnByJSON("close", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registered("close") || breaker.Get("close") == nil {
+		t.Fatal("health check or breaker not registered")
+	}
+	if err := redis.Close(client); err != nil {
+		t.Fatal(err)
+	}
+	if registered("close") || breaker.Get("close") != nil {
+		t.Fatal("health check or breaker still registered after Close")
+	}
+}
+
+func registered(name string) bool {
+	for _, s := range health.Check(context.Background()) {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConnByJSONCtxDeadline(t *testing.T) {
+	//只接受连接不回复的服务端，ping 会一直等到读超时
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	data := []byte(`{"host":"` + host + `","port":` + port + `,"read_timeout":"10s"}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = redis.ConnByJSONCtx(ctx, "deadline", data)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("connect = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("connect took %v, want about 200ms", d)
+	}
+}
@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"errors"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/go-redis/redis"
+	"reflect"
+	"strings"
+)
+
+/*
+go-redis 的服务端错误类型在 internal 包中，不能直接引用，通过同类型的 redis.Nil 取得
+*/
+var serverErrorType = reflect.TypeOf(redis.Nil)
+
+func init() {
+	dberr.RegisterClassifier(backend, dberr.Classifier{
+		NotFound: func(err error) bool {
+			return errors.Is(err, redis.Nil)
+		},
+		Auth: func(err error) bool {
+			return hasServerPrefix(err, "NOAUTH", "WRONGPASS", "NOPERM", "ERR invalid password", "ERR AUTH")
+		},
+		Retryable: func(err error) bool {
+			return hasServerPrefix(err, "LOADING", "READONLY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "BUSY ")
+		},
+	})
+}
+
+/*
+err 的错误链中是否有以 prefixes 之一开头的服务端错误，其它错误即使内容相同也不算
+*/
+func hasServerPrefix(err error, prefixes ...string) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if reflect.TypeOf(err) != serverErrorType {
+			continue
+		}
+		msg := err.Error()
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(msg, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/metrics"
+	"github.com/chu108/cmany_db/tracing"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backend = "redis"
+
+/*
+客户端挂载的命令统计和链路追踪，保存未包装的处理函数，WithContext 复制出的客户端以此重新包装
+*/
+type hook struct {
+	name      string
+	process   func(cmd redis.Cmder) error
+	pipelines []func(cmds []redis.Cmder) error //依次为 pipeline 和事务 pipeline 的处理函数
+}
+
+/*
+已挂载的客户端，key 为 *redis.Options，客户端复制后仍共用同一个 Options
+*/
+var hooks sync.Map
+
+/*
+为客户端挂载命令统计和链路追踪
+*/
+func instrument(name string, cli *redis.Client) {
+	h := &hook{name: name}
+	cli.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		h.process = old
+		return h.wrapProcess(cli, old)
+	})
+	cli.WrapProcessPipeline(func(old func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		h.pipelines = append(h.pipelines, old)
+		return h.wrapPipeline(cli, old)
+	})
+	hooks.Store(cli.Options(), h)
+	metrics.RegisterPool(backend, name, func() metrics.PoolStats {
+		s := cli.PoolStats()
+		return metrics.PoolStats{
+			Open:  int(s.TotalConns),
+			Idle:  int(s.IdleConns),
+			InUse: int(s.TotalConns - s.IdleConns),
+			//go-redis 不统计等待次数，以获取连接超时的次数代替
+			WaitCount: int64(s.Timeouts),
+		}
+	})
+}
+
+/*
+返回绑定 ctx 的客户端，命令的 span 挂在 ctx 中的 span 下面
+go-redis v6 的 cli.WithContext 复制客户端时沿用原来包装的处理函数，span 的父级仍取自原客户端，因此要用这里的 WithContext
+cli 不是本包返回的客户端时等同于 cli.WithContext
+*/
+func WithContext(ctx context.Context, cli *redis.Client) *redis.Client {
+	c := cli.WithContext(ctx)
+	v, ok := hooks.Load(cli.Options())
+	if !ok {
+		return c
+	}
+	h := v.(*hook)
+	c.WrapProcess(func(func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return h.wrapProcess(c, h.process)
+	})
+	i := 0
+	c.WrapProcessPipeline(func(func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		old := h.pipelines[i]
+		i++
+		return h.wrapPipeline(c, old)
+	})
+	return c
+}
+
+func (h *hook) wrapProcess(cli *redis.Client, old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+	return func(cmd redis.Cmder) error {
+		op := strings.ToUpper(cmd.Name())
+		span := startSpan(cli, h.name, op, cmd)
+		start := time.Now()
+		err := old(cmd)
+		metrics.Observe(backend, h.name, op, start, cmdErr(err))
+		tracing.End(span, cmdErr(err))
+		return err
+	}
+}
+
+func (h *hook) wrapPipeline(cli *redis.Client, old func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+	return func(cmds []redis.Cmder) error {
+		span := startSpan(cli, h.name, "PIPELINE", cmds...)
+		start := time.Now()
+		err := old(cmds)
+		metrics.Observe(backend, h.name, "PIPELINE", start, cmdErr(err))
+		tracing.End(span, cmdErr(err))
+		return err
+	}
+}
+
+func startSpan(cli *redis.Client, name, op string, cmds ...redis.Cmder) trace.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	lines := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		lines = append(lines, statement(cmd))
+	}
+	_, span := tracing.Start(cli.Context(), backend, name, op, strings.Join(lines, "\n"))
+	return span
+}
+
+/*
+脱敏后的命令，只保留命令名，参数替换成 ?
+*/
+func statement(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(cmd.Name()) + strings.Repeat(" ?", len(args)-1)
+}
+
+/*
+redis.Nil 表示 key 不存在，不计入错误
+*/
+func cmdErr(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
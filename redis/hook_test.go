@@ -0,0 +1,56 @@
+package redis_test
+
+import (
+	"context"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/redis"
+	"github.com/chu108/cmany_db/tracing"
+	goredis "github.com/go-redis/redis"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestWithContext(t *testing.T) {
+	env := cmanydbtest.New(t)
+	cli, err := env.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracing.Enable(tp)
+	defer tracing.Disable()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if err := redis.WithContext(ctx, cli).Set("k", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redis.WithContext(ctx, cli).Pipelined(func(p goredis.Pipeliner) error {
+		p.Get("k")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	//原客户端不受影响，span 没有父级
+	if err := cli.Get("k").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	for _, s := range spans[:2] {
+		if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %q parent = %v, want %v", s.Name(), s.Parent().SpanID(), parent.SpanContext().SpanID())
+		}
+	}
+	if s := spans[3]; s.Parent().IsValid() {
+		t.Errorf("span %q has parent %v, want none", s.Name(), s.Parent().SpanID())
+	}
+}
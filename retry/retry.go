@@ -0,0 +1,179 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/logger"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultMultiplier     = 2
+)
+
+/*
+重试策略，可以写在各数据库的 JSON 配置中
+{"max_attempts":5,"initial_backoff":"200ms","max_backoff":"5s","multiplier":2,"jitter":0.2,"deadline":"30s"}
+*/
+type Policy struct {
+	MaxAttempts    int             `json:"max_attempts"`    //最多尝试次数，小于等于 1 时不重试，为 0 且设置了 deadline 时一直重试到超时
+	InitialBackoff config.Duration `json:"initial_backoff"` //第一次重试前的等待时间，默认 100ms
+	MaxBackoff     config.Duration `json:"max_backoff"`     //最长等待时间，默认 10s
+	Multiplier     float64         `json:"multiplier"`      //每次等待时间的增长倍数，默认 2
+	Jitter         float64         `json:"jitter"`          //随机抖动比例，0~1，0.2 表示等待时间在 ±20% 范围内随机
+	Deadline       config.Duration `json:"deadline"`        //整体超时时间，0 表示不限制
+}
+
+var (
+	mu  sync.RWMutex
+	def = &Policy{MaxAttempts: 1}
+)
+
+/*
+设置全局默认策略，配置中没有 retry 时使用，默认不重试
+*/
+func SetDefault(p *Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+	if p == nil {
+		p = &Policy{MaxAttempts: 1}
+	}
+	def = p
+}
+
+/*
+校验配置中的重试策略
+*/
+func (p *Policy) Validate(problems *config.Problems) {
+	if p.MaxAttempts < 0 {
+		problems.Addf("max_attempts", "must not be negative, got %d", p.MaxAttempts)
+	}
+	problems.NonNegative("initial_backoff", p.InitialBackoff)
+	problems.NonNegative("max_backoff", p.MaxBackoff)
+	problems.NonNegative("deadline", p.Deadline)
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		problems.Addf("multiplier", "must be at least 1, got %g", p.Multiplier)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		problems.Addf("jitter", "must be between 0 and 1, got %g", p.Jitter)
+	}
+}
+
+/*
+获取全局默认策略
+*/
+func Default() *Policy {
+	mu.RLock()
+	defer mu.RUnlock()
+	return def
+}
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanent) Unwrap() error {
+	return p.err
+}
+
+/*
+标记为不可重试的错误，Do 遇到后立即返回原错误
+*/
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanent{err: err}
+}
+
+/*
+按策略执行 fn，直到成功、遇到不可重试的错误、次数用完或 ctx 结束
+name 实例名称，用于日志
+p 为 nil 时使用全局默认策略，返回最后一次的错误
+*/
+func Do(ctx context.Context, name string, p *Policy, fn func(ctx context.Context) error) error {
+	if p == nil {
+		p = Default()
+	}
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline.Std())
+		defer cancel()
+	}
+	backoff := p.InitialBackoff.Std()
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perm *permanent
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		//认证失败和配置错误重试也不会成功
+		if dberr.IsAuth(err) || errors.Is(err, dberr.ErrInvalidConfig) {
+			return err
+		}
+		if !p.more(attempt) {
+			return err
+		}
+		wait := p.jitter(backoff)
+		logger.For(name).Warn("retrying", logger.F("attempt", attempt), logger.F("wait", wait), logger.F("error", err.Error()))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = p.next(backoff)
+	}
+}
+
+func (p *Policy) more(attempt int) bool {
+	if p.MaxAttempts == 0 {
+		return p.Deadline > 0
+	}
+	return attempt < p.MaxAttempts
+}
+
+func (p *Policy) next(backoff time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+	max := p.MaxBackoff.Std()
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+func (p *Policy) jitter(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return backoff
+	}
+	j := p.Jitter
+	if j > 1 {
+		j = 1
+	}
+	delta := (rand.Float64()*2 - 1) * j * float64(backoff)
+	return backoff + time.Duration(delta)
+}
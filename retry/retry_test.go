@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{"default multiplier", Policy{}, 100 * time.Millisecond, 200 * time.Millisecond},
+		{"multiplier", Policy{Multiplier: 3}, 100 * time.Millisecond, 300 * time.Millisecond},
+		{"multiplier below 1 uses default", Policy{Multiplier: 0.5}, 100 * time.Millisecond, 200 * time.Millisecond},
+		{"capped by max backoff", Policy{MaxBackoff: config.Duration(time.Second)}, 800 * time.Millisecond, time.Second},
+		{"capped by default max backoff", Policy{}, 8 * time.Second, defaultMaxBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.next(tt.backoff); got != tt.want {
+				t.Fatalf("next(%v) = %v, want %v", tt.backoff, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	const backoff = time.Second
+	tests := []struct {
+		name     string
+		jitter   float64
+		min, max time.Duration
+	}{
+		{"no jitter", 0, backoff, backoff},
+		{"20 percent", 0.2, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"above 1 is capped", 2, 0, 2 * backoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{Jitter: tt.jitter}
+			for i := 0; i < 100; i++ {
+				if got := p.jitter(backoff); got < tt.min || got > tt.max {
+					t.Fatalf("jitter = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	errConn := errors.New("connection refused")
+	fast := config.Duration(time.Millisecond)
+	tests := []struct {
+		name     string
+		policy   *Policy
+		errs     []error //每次尝试返回的错误，用完后返回 nil
+		want     error
+		attempts int
+	}{
+		{"success", &Policy{MaxAttempts: 3, InitialBackoff: fast}, nil, nil, 1},
+		{"retry until success", &Policy{MaxAttempts: 3, InitialBackoff: fast}, []error{errConn, errConn}, nil, 3},
+		{"attempts exhausted", &Policy{MaxAttempts: 2, InitialBackoff: fast}, []error{errConn, errConn, errConn}, errConn, 2},
+		{"max attempts 1 does not retry", &Policy{MaxAttempts: 1}, []error{errConn}, errConn, 1},
+		{"permanent", &Policy{MaxAttempts: 3, InitialBackoff: fast}, []error{Permanent(errConn)}, errConn, 1},
+		{"auth", &Policy{MaxAttempts: 3, InitialBackoff: fast}, []error{dberr.ErrAuth}, dberr.ErrAuth, 1},
+		{"invalid config", &Policy{MaxAttempts: 3, InitialBackoff: fast}, []error{dberr.ErrInvalidConfig}, dberr.ErrInvalidConfig, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), "retry", tt.policy, func(ctx context.Context) error {
+				attempts++
+				if attempts > len(tt.errs) {
+					return nil
+				}
+				return tt.errs[attempts-1]
+			})
+			if err != tt.want || attempts != tt.attempts {
+				t.Fatalf("Do = %v after %d attempts, want %v after %d", err, attempts, tt.want, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestDoDeadline(t *testing.T) {
+	//max_attempts 为 0 时一直重试到 deadline
+	errConn := errors.New("connection refused")
+	p := &Policy{InitialBackoff: config.Duration(10 * time.Millisecond), Deadline: config.Duration(100 * time.Millisecond)}
+	start := time.Now()
+	attempts := 0
+	err := Do(context.Background(), "retry", p, func(ctx context.Context) error {
+		attempts++
+		return errConn
+	})
+	if err != errConn || attempts < 2 {
+		t.Fatalf("Do = %v after %d attempts, want %v after several", err, attempts, errConn)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Do took %v, want about 100ms", d)
+	}
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"github.com/chu108/cmany_db/config"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxOpen     = 10
+	defaultMaxIdle     = 10
+	defaultBusyTimeout = time.Second * 5
+	defaultJournalMode = "WAL"
+	memoryFile         = ":memory:"
+)
+
+var journalModes = map[string]bool{
+	"DELETE":   true,
+	"TRUNCATE": true,
+	"PERSIST":  true,
+	"MEMORY":   true,
+	"WAL":      true,
+	"OFF":      true,
+}
+
+/*
+校验 etcd 中的配置，不打开数据库，所有问题一次返回
+dbKey etcd存储的数据库连接字符串的key
+data 配置内容
+*/
+func Validate(dbKey string, data []byte) error {
+	return config.Decode(dbKey, data, new(dbConn))
+}
+
+/*
+max_open、max_idle 默认 10，busy_timeout 默认 5s，文件数据库 journal_mode 默认 WAL
+*/
+func (c *dbConn) SetDefaults() {
+	if c.MaxOpen == 0 {
+		c.MaxOpen = defaultMaxOpen
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = defaultMaxIdle
+		if c.MaxIdle > c.MaxOpen && c.MaxOpen > 0 {
+			c.MaxIdle = c.MaxOpen
+		}
+	}
+	if c.BusyTimeout == 0 {
+		c.BusyTimeout = config.Duration(defaultBusyTimeout)
+	}
+	if c.JournalMode == "" && !c.memory() && !c.ReadOnly {
+		c.JournalMode = defaultJournalMode
+	}
+}
+
+func (c *dbConn) Validate(p *config.Problems) {
+	p.Required("file", c.File)
+	if strings.Contains(c.File, "?") {
+		p.Add("file", "parameters must be set in params")
+	}
+	if c.JournalMode != "" && !journalModes[strings.ToUpper(c.JournalMode)] {
+		p.Addf("journal_mode", "must be one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL, OFF, got %q", c.JournalMode)
+	}
+	p.NonNegative("busy_timeout", c.BusyTimeout)
+	if c.MaxOpen < 0 {
+		p.Addf("max_open", "must not be negative, got %d", c.MaxOpen)
+	}
+	if c.MaxIdle < 0 {
+		p.Addf("max_idle", "must not be negative, got %d", c.MaxIdle)
+	} else if c.MaxIdle > c.MaxOpen {
+		p.Addf("max_idle", "must not be greater than max_open %d, got %d", c.MaxOpen, c.MaxIdle)
+	}
+}
+
+/*
+生成 go-sqlite3 的 DSN，params 中的同名参数优先
+*/
+func (c *dbConn) dsn() string {
+	values := url.Values{}
+	if c.JournalMode != "" {
+		values.Set("_journal_mode", strings.ToUpper(c.JournalMode))
+	}
+	if c.BusyTimeout > 0 {
+		values.Set("_busy_timeout", strconv.FormatInt(c.BusyTimeout.Std().Milliseconds(), 10))
+	}
+	if c.ReadOnly {
+		values.Set("_query_only", "1")
+	}
+	for k, v := range c.Params {
+		values.Set(k, v)
+	}
+	if len(values) == 0 {
+		return c.File
+	}
+	return c.File + "?" + values.Encode()
+}
+
+func (c *dbConn) memory() bool {
+	return isMemory(c.File)
+}
+
+func isMemory(dsn string) bool {
+	return dsn == memoryFile || strings.HasPrefix(dsn, memoryFile+"?") || strings.Contains(dsn, "mode=memory")
+}
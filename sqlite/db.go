@@ -0,0 +1,236 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/chu108/cmany_db/config"
+	"github.com/chu108/cmany_db/dberr"
+	"github.com/chu108/cmany_db/etcd"
+	"github.com/chu108/cmany_db/health"
+	"github.com/chu108/cmany_db/metrics"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const backend = "sqlite"
+
+/*
+open 打开的连接池对应的实例名称，Close 时按名称取消注册健康检查和连接池指标
+*/
+var instances sync.Map
+
+/*
+{"file":"/data/app.db","journal_mode":"WAL","busy_timeout":"5s","params":{"_foreign_keys":"1"}}
+file 为 :memory: 时使用内存数据库，连接池只保留一个连接，关闭后数据丢失
+*/
+type dbConn struct {
+	File        string            `json:"file"`
+	JournalMode string            `json:"journal_mode"` //文件数据库默认 WAL
+	BusyTimeout config.Duration   `json:"busy_timeout"` //数据库被锁定时的等待时间，默认 5s
+	ReadOnly    bool              `json:"read_only"`    //只读打开
+	Params      map[string]string `json:"params"`       //go-sqlite3 的 DSN 参数，如 _foreign_keys、_synchronous
+	MaxOpen     int               `json:"max_open"`
+	MaxIdle     int               `json:"max_idle"`
+}
+
+/*
+通过ETCD方式连接数据库，返回的主库和从库是同一个 *sql.DB，和 mysql 包的返回值相同，方便按配置替换
+dbKey etcd存储的数据库连接字符串的key
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcd(dbKey string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	return ConnByEtcdCtx(context.Background(), dbKey, endpoints...)
+}
+
+/*
+通过ETCD方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEtcdCtx(ctx context.Context, dbKey string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.Conn(endpoints...).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ETCD 授权方式连接数据库
+dbKey etcd存储的数据库连接字符串的key
+etcdName etcd用户名
+etcdPass etcd密码
+endpoints etcd的ip节点列表
+*/
+func ConnByEtcdAuth(dbKey, etcdName, etcdPass string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	return ConnByEtcdAuthCtx(context.Background(), dbKey, etcdName, etcdPass, endpoints...)
+}
+
+/*
+通过ETCD 授权方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEtcdAuthCtx(ctx context.Context, dbKey, etcdName, etcdPass string, endpoints ...string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.Conn(endpoints...).Auth(etcdName, etcdPass).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+通过ENV 变量方式连接数据库
+env ETCD变量的名称，如ETCD_ADDR=127.0.0.1:2379
+dbKey etcd存储的数据库连接字符串的key
+*/
+func ConnByEnv(env, dbKey string) (*sql.DB, *sql.DB, error) {
+	return ConnByEnvCtx(context.Background(), env, dbKey)
+}
+
+/*
+通过ENV 变量方式连接数据库，ctx 控制读取配置和启动时 ping 的时间
+*/
+func ConnByEnvCtx(ctx context.Context, env, dbKey string) (*sql.DB, *sql.DB, error) {
+	connStr, err := etcd.ConnByEnv(env).GetCtx(ctx, dbKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return connByConnByte(ctx, dbKey, connStr)
+}
+
+/*
+直接打开数据库文件，不读取 etcd，用于单元测试和本地开发
+file 数据库文件路径，:memory: 为内存数据库
+*/
+func ConnByFile(file string) (*sql.DB, *sql.DB, error) {
+	return ConnByFileCtx(context.Background(), file)
+}
+
+/*
+直接打开数据库文件，ctx 控制启动时 ping 的时间
+*/
+func ConnByFileCtx(ctx context.Context, file string) (*sql.DB, *sql.DB, error) {
+	cfg := &dbConn{File: file}
+	cfg.SetDefaults()
+	return conn(ctx, fileName(file), cfg)
+}
+
+/*
+以字符串的方式连接数据库
+dsn go-sqlite3 的 DSN，如 file:test.db?cache=shared&mode=rwc
+maxOpen 最大打开连接
+maxIdle 最大闲置的连接数
+*/
+func ConnByStr(dsn string, maxOpen, maxIdle int) (masterDB, slaveDB *sql.DB, err error) {
+	return ConnByStrCtx(context.Background(), dsn, maxOpen, maxIdle)
+}
+
+/*
+以字符串的方式连接数据库，ctx 控制启动时 ping 的时间
+*/
+func ConnByStrCtx(ctx context.Context, dsn string, maxOpen, maxIdle int) (masterDB, slaveDB *sql.DB, err error) {
+	db, err := open(ctx, fileName(dsn), dsn, maxOpen, maxIdle, isMemory(dsn))
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db, nil
+}
+
+/*
+以 JSON 配置连接数据库，配置格式与 etcd 中的相同，用于从文件等其它来源读取的配置
+name 实例名称，用于指标标签和错误信息
+data 配置内容
+*/
+func ConnByJSON(name string, data []byte) (masterDB, slaveDB *sql.DB, err error) {
+	return ConnByJSONCtx(context.Background(), name, data)
+}
+
+/*
+以 JSON 配置连接数据库，ctx 控制启动时连接的时间
+*/
+func ConnByJSONCtx(ctx context.Context, name string, data []byte) (masterDB, slaveDB *sql.DB, err error) {
+	return connByConnByte(ctx, name, data)
+}
+
+func connByConnByte(ctx context.Context, name string, connByte []byte) (masterDB, slaveDB *sql.DB, err error) {
+	cfg := new(dbConn)
+	if err := config.Decode(name, connByte, cfg); err != nil {
+		return nil, nil, err
+	}
+	return conn(ctx, name, cfg)
+}
+
+/*
+name 实例名称，用于指标标签
+*/
+func conn(ctx context.Context, name string, cfg *dbConn) (masterDB, slaveDB *sql.DB, err error) {
+	db, err := open(ctx, name, cfg.dsn(), cfg.MaxOpen, cfg.MaxIdle, cfg.memory())
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db, nil
+}
+
+func open(ctx context.Context, name, dsn string, maxOpen, maxIdle int, memory bool) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if memory {
+		//内存数据库属于连接，多个连接会看到不同的数据库，连接关闭后数据丢失
+		maxOpen, maxIdle = 1, 1
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	if err := db.PingContext(ctx); err != nil {
+		metrics.ConnectError(backend, name)
+		db.Close()
+		return nil, dberr.Connect(name, err)
+	}
+	metrics.RegisterPool(backend, name, func() metrics.PoolStats {
+		s := db.Stats()
+		return metrics.PoolStats{
+			Open:         s.OpenConnections,
+			Idle:         s.Idle,
+			InUse:        s.InUse,
+			WaitCount:    s.WaitCount,
+			WaitDuration: s.WaitDuration,
+		}
+	})
+	health.Register(backend, name, db.PingContext)
+	instances.Store(db, name)
+	return db, nil
+}
+
+/*
+关闭 conn 返回的连接池，主从是同一个 *sql.DB，只关闭一次
+同时取消注册健康检查和连接池指标
+*/
+func Close(masterDB, slaveDB *sql.DB) error {
+	db := masterDB
+	if db == nil {
+		db = slaveDB
+	}
+	if db == nil {
+		return nil
+	}
+	if name, ok := instances.LoadAndDelete(db); ok {
+		health.Unregister(name.(string))
+		metrics.UnregisterPool(backend, name.(string))
+	}
+	return db.Close()
+}
+
+/*
+以文件名作为实例名称，DSN 中的参数和 file: 前缀不计入
+*/
+func fileName(file string) string {
+	if isMemory(file) {
+		return backend + "/memory"
+	}
+	if i := strings.IndexByte(file, '?'); i >= 0 {
+		file = file[:i]
+	}
+	return backend + "/" + filepath.Base(strings.TrimPrefix(file, "file:"))
+}
@@ -0,0 +1,64 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/chu108/cmany_db/cmanydbtest"
+	"github.com/chu108/cmany_db/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+const dbKey = "/cmanydbtest/sqlite"
+
+/*
+主库建表写入后从库能读到，然后关闭
+*/
+func exec(master, slave *sql.DB, err error) error {
+	if err != nil {
+		return err
+	}
+	defer sqlite.Close(master, slave)
+	if master != slave {
+		return errors.New("master and slave should be the same *sql.DB")
+	}
+	for _, query := range []string{"DROP TABLE IF EXISTS t", "CREATE TABLE t (id INTEGER PRIMARY KEY)", "INSERT INTO t VALUES (1)"} {
+		if _, err := master.Exec(query); err != nil {
+			return err
+		}
+	}
+	var n int
+	if err := slave.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		return err
+	}
+	if n != 1 {
+		return errors.New("inserted row not found")
+	}
+	return nil
+}
+
+func TestConstructors(t *testing.T) {
+	env := cmanydbtest.New(t)
+	file := filepath.Join(t.TempDir(), "test.db")
+	if err := env.Put(dbKey, map[string]interface{}{"file": file}); err != nil {
+		t.Fatal(err)
+	}
+	env.TestConstructors(t, cmanydbtest.Constructors{
+		Key: dbKey,
+		Etcd: func(key string, endpoints ...string) error {
+			return exec(sqlite.ConnByEtcd(key, endpoints...))
+		},
+		EtcdAuth: func(key, user, password string, endpoints ...string) error {
+			return exec(sqlite.ConnByEtcdAuth(key, user, password, endpoints...))
+		},
+		Env: func(env, key string) error {
+			return exec(sqlite.ConnByEnv(env, key))
+		},
+	})
+}
+
+func TestConnByStr(t *testing.T) {
+	if err := exec(sqlite.ConnByStr(":memory:", 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"strings"
+)
+
+/*
+SQL 脱敏，把字符串和数字字面量替换成 ?
+*/
+func SanitizeSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			//跳过整个字符串，支持反斜杠和重复引号转义
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && (i == 0 || !isIdent(query[i-1])):
+			for i+1 < len(query) && (isIdent(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+const instrumentationName = "github.com/chu108/cmany_db"
+
+var (
+	mu     sync.RWMutex
+	tracer trace.Tracer
+)
+
+/*
+开启链路追踪，默认关闭
+tp 为 nil 时使用 otel 全局的 TracerProvider
+*/
+func Enable(tp trace.TracerProvider) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	mu.Lock()
+	tracer = tp.Tracer(instrumentationName)
+	mu.Unlock()
+}
+
+/*
+关闭链路追踪
+*/
+func Disable() {
+	mu.Lock()
+	tracer = nil
+	mu.Unlock()
+}
+
+/*
+是否已开启链路追踪
+*/
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return tracer != nil
+}
+
+/*
+开始一个客户端 span，未开启时返回原 ctx 和 nil
+system db.system 的值，如 mysql、redis、mongodb、elasticsearch
+instance 实例名称，通常是 etcd 中的 key
+operation 操作名称，如 SELECT、GET、find
+statement 语句，调用方需先脱敏
+*/
+func Start(ctx context.Context, system, instance, operation, statement string) (context.Context, trace.Span) {
+	mu.RLock()
+	t := tracer
+	mu.RUnlock()
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", system),
+		attribute.String("db.operation", operation),
+		attribute.String("cmany_db.instance", instance),
+	}
+	if statement != "" {
+		attrs = append(attrs, attribute.String("db.statement", statement))
+	}
+	return t.Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+/*
+结束 span，记录错误，span 为 nil 时什么也不做
+*/
+func End(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}